@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// App is what handlers share: one connection pool for the process,
+// which a warm Lambda container keeps between invocations. Handlers go
+// through the stores, so tests can swap in MemoryStore; DB is only for
+// the health check.
+type App struct {
+	DB          *sql.DB
+	Users       UserStore
+	Hosts       HostStore
+	Events      EventStore
+	Invitations InvitationStore
+	Emails      EmailQueueStore
+}
+
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DBPoolConfigFromEnv reads the pool limits. Every Lambda container has
+// its own pool, so MaxOpenConns times the number of containers has to
+// stay under Postgres' max_connections.
+func DBPoolConfigFromEnv() DBPoolConfig {
+	return DBPoolConfig{
+		MaxOpenConns: envInt("FWF_DB_MAX_OPEN_CONNS", 5),
+		MaxIdleConns: envInt("FWF_DB_MAX_IDLE_CONNS", 2),
+		ConnMaxLifetime: time.Duration(
+			envInt("FWF_DB_CONN_MAX_LIFETIME_SECONDS", 300)) * time.Second,
+	}
+}
+
+// NewApp opens the connection pool. It doesn't connect until the first
+// query, so a database that's down shows up in the health check rather
+// than stopping the process.
+func NewApp(config DBPoolConfig) (*App, error) {
+	db, err := Connect()
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	store := PostgresStore{DB: db}
+	return &App{
+		DB:          db,
+		Users:       store,
+		Hosts:       store,
+		Events:      store,
+		Invitations: store,
+		Emails:      store,
+	}, nil
+}
+
+type HealthStatus struct {
+	Status          string `json:"status"`
+	Database        string `json:"database"`
+	OpenConnections int    `json:"openConnections"`
+}
+
+// HandleHealthCheck reports whether the database can be reached, with
+// a 503 if it can't.
+func (app *App) HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	health := HealthStatus{Status: "ok", Database: "ok"}
+	status := http.StatusOK
+	if err := app.DB.PingContext(r.Context()); err != nil {
+		fmt.Printf("Health check failed: %s\n", err.Error())
+		health.Status = "unavailable"
+		health.Database = "unreachable"
+		status = http.StatusServiceUnavailable
+	}
+	health.OpenConnections = app.DB.Stats().OpenConnections
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
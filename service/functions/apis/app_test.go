@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	app, err := NewApp(DBPoolConfigFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.DB.Close()
+
+	response := httptest.NewRecorder()
+	FoodWithFriendsHTTPHandler(app).ServeHTTP(response,
+		httptest.NewRequest("GET", "/health", nil))
+
+	var health HealthStatus
+	json.NewDecoder(response.Body).Decode(&health)
+	if response.Code != http.StatusOK || health.Status != "ok" {
+		t.Errorf("Expected a healthy database: %d %+v", response.Code,
+			health)
+	}
+}
+
+func TestHealthCheckUnavailable(t *testing.T) {
+	db, err := sql.Open("postgres",
+		"host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{DB: db}
+	defer app.DB.Close()
+
+	response := httptest.NewRecorder()
+	app.HandleHealthCheck(response, httptest.NewRequest("GET", "/health",
+		nil))
+
+	var health HealthStatus
+	json.NewDecoder(response.Body).Decode(&health)
+	if response.Code != http.StatusServiceUnavailable ||
+		health.Database != "unreachable" {
+		t.Errorf("Expected a 503 without a database: %d %+v",
+			response.Code, health)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -29,10 +30,80 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		secret := []byte(AUTH0_API_CLIENT_SECRET)
+		claims := jwt.Claims{}
+		if err = token.Claims(secret, &claims); err != nil ||
+			len(claims.Subject) == 0 {
+			fmt.Println(err)
+			fmt.Println("Claims not valid: ", claims)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+
+		// Already verified above, so this can't fail
+		customClaims := make(map[string]interface{})
+		token.Claims(secret, &customClaims)
+
+		ctx := context.WithValue(r.Context(), auth0IdContextKey,
+			claims.Subject)
+		ctx = context.WithValue(ctx, isAdminContextKey,
+			canSendInvites(customClaims))
+		ctx = context.WithValue(ctx, emailContextKey,
+			verifiedEmail(customClaims))
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+type contextKey string
+
+const auth0IdContextKey contextKey = "auth0Id"
+const isAdminContextKey contextKey = "isAdmin"
+const emailContextKey contextKey = "email"
+
+// RequestAuth0Id returns the `sub` claim of the request's validated
+// access token, as put in the context by authMiddleware.
+func RequestAuth0Id(r *http.Request) string {
+	auth0Id, _ := r.Context().Value(auth0IdContextKey).(string)
+	return auth0Id
+}
+
+// RequestEmail returns the verified email address of the request's
+// access token, or "" if it doesn't have one.
+func RequestEmail(r *http.Request) string {
+	email, _ := r.Context().Value(emailContextKey).(string)
+	return email
+}
+
+// IsRequestEmail returns whether email is the request's verified email
+// address, ignoring case and surrounding spaces.
+func IsRequestEmail(r *http.Request, email string) bool {
+	requestEmail := RequestEmail(r)
+	return len(requestEmail) > 0 && strings.EqualFold(requestEmail,
+		strings.TrimSpace(email))
+}
+
+// RequestIsAdmin returns whether the request's access token has the
+// admin role that canSendInvitesMiddleware requires.
+func RequestIsAdmin(r *http.Request) bool {
+	isAdmin, _ := r.Context().Value(isAdminContextKey).(bool)
+	return isAdmin
+}
+
+// checkOwnership writes an error response and returns false unless
+// the ownership check succeeded and the caller is the owner.
+func checkOwnership(w http.ResponseWriter, isOwner bool, err error) bool {
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	if !isOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 
 func canSendInvitesMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -67,7 +138,7 @@ func canSendInvitesMiddleware(next http.Handler) http.Handler {
 }
 
 func canSendInvites(claims map[string]interface{}) bool {
-	customScopes := claims["https://foodwithfriends.api/roles"].(string)
+	customScopes, _ := claims["https://foodwithfriends.api/roles"].(string)
 	for _, scope := range strings.Split(customScopes, " ") {
 		if scope == "send:invites" {
 			return true
@@ -76,6 +147,17 @@ func canSendInvites(claims map[string]interface{}) bool {
 	return false
 }
 
+// verifiedEmail is the email address the Auth0 rule adds to access
+// tokens, if Auth0 has verified it.
+func verifiedEmail(claims map[string]interface{}) string {
+	isVerified, _ := claims["https://foodwithfriends.api/email_verified"].(bool)
+	email, _ := claims["https://foodwithfriends.api/email"].(string)
+	if !isVerified {
+		return ""
+	}
+	return strings.TrimSpace(email)
+}
+
 func getToken(r *http.Request) (*jwt.JSONWebToken, error){
 	secret := []byte(AUTH0_API_CLIENT_SECRET)
 	secretProvider := auth0.NewKeyProvider(secret)
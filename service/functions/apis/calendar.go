@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// iCalendar methods (RFC 5546). Updates are sent as a REQUEST with a
+// higher SEQUENCE, which calendar clients apply to the existing entry
+// with the same UID.
+const CALENDAR_PUBLISH string = "PUBLISH"
+const CALENDAR_REQUEST string = "REQUEST"
+const CALENDAR_CANCEL string = "CANCEL"
+
+// How long events without an end are assumed to last in calendars
+const DEFAULT_EVENT_DURATION = 3 * time.Hour
+
+const calendarTimeFormat = "20060102T150405Z"
+
+// EventUID is the event's iCalendar UID. It has to stay the same for
+// the life of the event so calendar entries update in place.
+func EventUID(eventId int64) string {
+	return fmt.Sprintf("event-%d@foodwithfriends", eventId)
+}
+
+// escapeCalendarText escapes a TEXT value (RFC 5545 3.3.11).
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// writeCalendarLine writes a content line, folded so no line is longer
+// than 75 octets (RFC 5545 3.1).
+func writeCalendarLine(buffer *bytes.Buffer, name string, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		// Don't split a multi-byte UTF-8 character
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		buffer.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines lose an octet to the leading space
+		limit = 74
+	}
+	buffer.WriteString(line + "\r\n")
+}
+
+func eventLocation(host Host) string {
+	var parts []string
+	for _, part := range []string{host.Address, host.City, host.State,
+		host.Zipcode} {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// BuildCalendar builds an iCalendar object with a VEVENT for each
+// event. method is one of the CALENDAR_ methods, and organizer is the
+// email address invitations come from.
+func BuildCalendar(events Events, method string, organizer string) []byte {
+	now := time.Now().UTC().Format(calendarTimeFormat)
+
+	var buffer bytes.Buffer
+	writeCalendarLine(&buffer, "BEGIN", "VCALENDAR")
+	writeCalendarLine(&buffer, "VERSION", "2.0")
+	writeCalendarLine(&buffer, "PRODID", "-//Food With Friends//Potlucks//EN")
+	writeCalendarLine(&buffer, "CALSCALE", "GREGORIAN")
+	writeCalendarLine(&buffer, "METHOD", method)
+	writeCalendarLine(&buffer, "X-WR-CALNAME", "Food With Friends")
+
+	for _, event := range events {
+		writeCalendarLine(&buffer, "BEGIN", "VEVENT")
+		writeCalendarLine(&buffer, "UID", EventUID(event.EventId))
+		writeCalendarLine(&buffer, "SEQUENCE",
+			fmt.Sprintf("%d", event.Sequence))
+		writeCalendarLine(&buffer, "DTSTAMP", now)
+		writeCalendarLine(&buffer, "DTSTART",
+			event.HappeningAt.UTC().Format(calendarTimeFormat))
+		endsAt := event.HappeningAt.Add(DEFAULT_EVENT_DURATION)
+		if eventEndsAt := EventEndsAt(event); eventEndsAt != nil {
+			endsAt = *eventEndsAt
+		}
+		writeCalendarLine(&buffer, "DTEND",
+			endsAt.UTC().Format(calendarTimeFormat))
+		writeCalendarLine(&buffer, "SUMMARY",
+			escapeCalendarText(event.Title))
+		if len(event.Description) > 0 {
+			writeCalendarLine(&buffer, "DESCRIPTION",
+				escapeCalendarText(event.Description))
+		}
+		if location := eventLocation(event.Host); len(location) > 0 {
+			writeCalendarLine(&buffer, "LOCATION",
+				escapeCalendarText(location))
+		}
+		if len(organizer) > 0 {
+			writeCalendarLine(&buffer, "ORGANIZER;CN=Food With Friends",
+				"mailto:"+organizer)
+		}
+		if method == CALENDAR_CANCEL || event.Status == EVENT_CANCELLED {
+			writeCalendarLine(&buffer, "STATUS", "CANCELLED")
+		} else {
+			writeCalendarLine(&buffer, "STATUS", "CONFIRMED")
+		}
+		writeCalendarLine(&buffer, "END", "VEVENT")
+	}
+
+	writeCalendarLine(&buffer, "END", "VCALENDAR")
+	return buffer.Bytes()
+}
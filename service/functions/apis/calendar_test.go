@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCalendar(t *testing.T) {
+	event := Event{
+		EventId:     42,
+		Title:       "Soup, bread; and \\ more",
+		Description: strings.Repeat("A very long description. ", 10),
+		HappeningAt: time.Date(2018, 3, 2, 18, 30, 0, 0,
+			time.FixedZone("EST", -5*60*60)),
+		Host: Host{
+			Address: "1 Main St",
+			City:    "Philadelphia",
+			State:   "PA",
+		},
+		Sequence: 3,
+	}
+
+	calendar := string(BuildCalendar(Events{event}, CALENDAR_REQUEST,
+		"fwf@example.com"))
+
+	for _, line := range strings.Split(calendar, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 octets: %q", line)
+		}
+	}
+
+	unfolded := strings.Replace(calendar, "\r\n ", "", -1)
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:event-42@foodwithfriends\r\n",
+		"SEQUENCE:3\r\n",
+		"DTSTART:20180302T233000Z\r\n",
+		"DTEND:20180303T023000Z\r\n",
+		`SUMMARY:Soup\, bread\; and \\ more` + "\r\n",
+		"DESCRIPTION:" + strings.Repeat("A very long description. ", 10) +
+			"\r\n",
+		`LOCATION:1 Main St\, Philadelphia\, PA` + "\r\n",
+		"ORGANIZER;CN=Food With Friends:mailto:fwf@example.com\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(unfolded, expected) {
+			t.Errorf("Expected %q in calendar:\n%s", expected, calendar)
+		}
+	}
+}
+
+func TestCalendarEventEnd(t *testing.T) {
+	event := Event{
+		EventId:         1,
+		HappeningAt:     time.Date(2018, 3, 2, 18, 30, 0, 0, time.UTC),
+		DurationMinutes: 90,
+	}
+	calendar := string(BuildCalendar(Events{event}, CALENDAR_PUBLISH, ""))
+
+	if !strings.Contains(calendar, "DTEND:20180302T200000Z\r\n") {
+		t.Errorf("Expected the event's own end:\n%s", calendar)
+	}
+}
+
+func TestFoldCalendarLineUTF8(t *testing.T) {
+	event := Event{EventId: 1, Title: strings.Repeat("☕", 40)}
+	calendar := string(BuildCalendar(Events{event}, CALENDAR_PUBLISH, ""))
+
+	unfolded := strings.Replace(calendar, "\r\n ", "", -1)
+	if !strings.Contains(unfolded, "SUMMARY:"+strings.Repeat("☕", 40)) {
+		t.Errorf("Folding broke a multi-byte character:\n%s", calendar)
+	}
+}
+
+func TestBuildCancelledCalendar(t *testing.T) {
+	event := Event{EventId: 7, Title: "Potluck", Status: EVENT_CANCELLED}
+
+	calendar := string(BuildCalendar(Events{event}, CALENDAR_PUBLISH, ""))
+	if !strings.Contains(calendar, "STATUS:CANCELLED\r\n") {
+		t.Errorf("Expected cancelled status in feed:\n%s", calendar)
+	}
+
+	calendar = string(BuildCalendar(Events{Event{EventId: 7}},
+		CALENDAR_CANCEL, ""))
+	if !strings.Contains(calendar, "METHOD:CANCEL\r\n") ||
+		!strings.Contains(calendar, "STATUS:CANCELLED\r\n") {
+		t.Errorf("Expected cancellation:\n%s", calendar)
+	}
+}
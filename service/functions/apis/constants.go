@@ -1,5 +1,43 @@
 package main
 
+import "time"
+
 const EVENT_CREATED string = "event_created"
 const PENDING string = "pending"
 const PASS string = "pass"
+const COMPLETE string = "complete"
+
+// Event statuses
+const EVENT_SCHEDULED string = "scheduled"
+const EVENT_CANCELLED string = "cancelled"
+
+// Outbound email statuses. Pending emails are retried until they're
+// sent or run out of attempts.
+const EMAIL_PENDING string = "pending"
+const EMAIL_SENT string = "sent"
+const EMAIL_FAILED string = "failed"
+
+var INVITATION_STATUSES = []string{PENDING, EVENT_CREATED, PASS, COMPLETE}
+
+// Dish slots given to events that don't specify their own, in the
+// order of the old main/side/appetizer/drinks rotation.
+var DEFAULT_DISH_SLOTS = DishSlots{
+	DishSlot{Name: "main", TargetCount: 1},
+	DishSlot{Name: "side", TargetCount: 1},
+	DishSlot{Name: "appetizer", TargetCount: 1},
+	DishSlot{Name: "drinks", TargetCount: 1},
+}
+
+// Deadlines for a request's database work, by endpoint. They're under
+// the Lambda's 10s timeout in function.json, so a slow query is
+// cancelled and answered with a 504 instead of the invocation being
+// killed.
+const EVENTS_TIMEOUT time.Duration = 5 * time.Second
+const USERS_TIMEOUT time.Duration = 3 * time.Second
+const HOSTS_TIMEOUT time.Duration = 3 * time.Second
+const CALENDAR_TIMEOUT time.Duration = 5 * time.Second
+const ADMIN_TIMEOUT time.Duration = 8 * time.Second
+const HEALTH_CHECK_TIMEOUT time.Duration = 2 * time.Second
+
+// The deadline for a scheduled run's rotation and email delivery
+const SCHEDULED_RUN_TIMEOUT time.Duration = 9 * time.Second
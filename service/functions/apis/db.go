@@ -2,54 +2,209 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 func Connect() (*sql.DB, error) {
+	return sql.Open("postgres", ConnectionString())
+}
+
+func ConnectionString() string {
 	user := os.Getenv("PG_USER")
 
 	password := os.Getenv("PG_PASSWORD")
 	dbname := os.Getenv("PG_DBNAME")
 	host := os.Getenv("PG_HOST")
-	connectionString := fmt.Sprintf(
+	return fmt.Sprintf(
 		"user=%s dbname=%s password=%s host=%s sslmode=disable",
 		user, dbname, password, host)
+}
 
-	return sql.Open("postgres", connectionString)
+// Execer is satisfied by both *sql.DB and *sql.Tx, so writes that may
+// need to share a caller's transaction can take either.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func dbNullStringToArray(s sql.NullString) []string {
-	if s.Valid {
-		return strings.Split(s.String, "+")
-	} else {
-		return make([]string, 0)
+// WithTransaction runs fn in a transaction, committing it if fn
+// succeeds and rolling it back if fn returns an error or panics, so
+// writes that take several statements happen entirely or not at all.
+func WithTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// updatableTables whitelists the tables and columns UpdateBuilder can
+// write, and the key column that picks the row.
+var updatableTables = map[string]struct {
+	key     string
+	columns []string
+}{
+	"users": {"auth0_id", []string{"name", "email", "updated_at"}},
+	"hosts": {"host_id", []string{"address", "city", "state", "zipcode",
+		"max_occupancy", "timezone", "updated_at"}},
+	"events": {"event_id", []string{"title", "description",
+		"happening_at", "ends_at", "host_id", "timezone", "sequence",
+		"updated_at"}},
+}
+
+// UpdateBuilder builds an UPDATE statement for a partial update. Values
+// are only ever bound as parameters, never put in the query, and only
+// whitelisted columns can be set.
+type UpdateBuilder struct {
+	table       string
+	assignments []string
+	args        []interface{}
+	err         error
+}
+
+func NewUpdateBuilder(table string) *UpdateBuilder {
+	builder := &UpdateBuilder{table: table}
+	if _, ok := updatableTables[table]; !ok {
+		builder.err = fmt.Errorf("Can't update table %q", table)
+	}
+	return builder
+}
+
+// Param binds value as the next parameter and returns its placeholder,
+// for use in SetExpression.
+func (b *UpdateBuilder) Param(value interface{}) string {
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// Set sets column to value.
+func (b *UpdateBuilder) Set(column string, value interface{}) {
+	b.SetExpression(column, b.Param(value))
+}
+
+// SetExpression sets column to a SQL expression. The expression has to
+// be a constant, with any values bound through Param. The right hand
+// side of SET sees the row from before the update.
+func (b *UpdateBuilder) SetExpression(column string, expression string) {
+	if b.err != nil {
+		return
+	}
+	for _, updatable := range updatableTables[b.table].columns {
+		if column == updatable {
+			b.assignments = append(b.assignments,
+				column+" = "+expression)
+			return
+		}
+	}
+	b.err = fmt.Errorf("Can't update column %q of %s", column, b.table)
+}
+
+func (b *UpdateBuilder) IsEmpty() bool {
+	return len(b.assignments) == 0
+}
+
+// Query builds the statement updating the row with the key, returning
+// the comma separated returning columns.
+func (b *UpdateBuilder) Query(key interface{}, returning string) (string, []interface{}, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	if b.IsEmpty() {
+		return "", nil, errors.New("Nothing to update")
 	}
+
+	args := append([]interface{}{}, b.args...)
+	args = append(args, key)
+	query := "UPDATE " + b.table +
+		" SET " + strings.Join(b.assignments, ", ") +
+		" WHERE " + updatableTables[b.table].key +
+		" = $" + strconv.Itoa(len(args)) +
+		" RETURNING " + returning
+	return query, args, nil
+}
+
+// dietaryRestrictionsColumn selects a user's dietary restrictions, in
+// the order they were given, as a Postgres array. Scan it with
+// pq.Array.
+const dietaryRestrictionsColumn = `ARRAY(
+                                SELECT user_dietary_restrictions.restriction
+                                FROM user_dietary_restrictions
+                                WHERE user_dietary_restrictions.user_id =
+                                      users.user_id
+                                ORDER BY user_dietary_restrictions.position)`
+
+// SetUserDietaryRestrictions replaces the user's dietary restrictions
+// with the canonicalized restrictions. It takes the transaction the
+// user is written in, so a failure can't leave them half replaced.
+func SetUserDietaryRestrictions(ctx context.Context, tx *sql.Tx, userId int64, restrictions []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM user_dietary_restrictions
+                           WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_dietary_restrictions (
+                              user_id,
+                              restriction,
+                              position
+                          )
+                          SELECT $1, r.restriction, r.position
+                          FROM unnest($2::varchar[])
+                          WITH ORDINALITY AS r(restriction, position)
+                          ON CONFLICT DO NOTHING`,
+		userId, pq.Array(CanonicalizeDietaryRestrictions(restrictions)))
+	return err
+}
+
+func GetDietaryRestrictionsForUser(ctx context.Context, db *sql.DB, userId int64) ([]string, error) {
+	restrictions := []string{}
+	err := db.QueryRowContext(ctx, `SELECT `+dietaryRestrictionsColumn+`
+                            FROM users WHERE user_id = $1`,
+		userId).Scan(pq.Array(&restrictions))
+	return restrictions, err
 }
 
-func CreateUser(db *sql.DB, user User) (int64, error) {
-	dietaryRestrictionsString := strings.Join(user.DietaryRestrictions,
-		"+")
+func CreateUser(ctx context.Context, db *sql.DB, user User) (int64, error) {
 	var userId int64
-	err := db.QueryRow(
-		`INSERT INTO users (
+	err := WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO users (
                      name,
                      email,
-                     dietary_restrictions,
                      auth0_id
-                 ) VALUES ($1, $2, $3, $4)
+                 ) VALUES ($1, $2, $3)
                  RETURNING user_id`,
-		user.Name,
-		user.Email,
-		dietaryRestrictionsString,
-		user.Auth0Id).Scan(&userId)
+			user.Name,
+			user.Email,
+			user.Auth0Id).Scan(&userId)
+		if err != nil {
+			return err
+		}
 
+		return SetUserDietaryRestrictions(ctx, tx, userId,
+			user.DietaryRestrictions)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -57,11 +212,11 @@ func CreateUser(db *sql.DB, user User) (int64, error) {
 	return userId, nil
 }
 
-func GetUserByAuth0Id(db *sql.DB, auth0Id string) (User, error) {
-	row := db.QueryRow(`SELECT users.user_id,
+func GetUserByAuth0Id(ctx context.Context, db *sql.DB, auth0Id string) (User, error) {
+	row := db.QueryRowContext(ctx, `SELECT users.user_id,
                                users.name,
                                users.email,
-                               users.dietary_restrictions,
+                               `+dietaryRestrictionsColumn+`,
                                users.auth0_id,
                                host_users.host_id
                             FROM users
@@ -74,98 +229,126 @@ func GetUserByAuth0Id(db *sql.DB, auth0Id string) (User, error) {
 		user_id              int64
 		name                 string
 		email                string
-		dietary_restrictions sql.NullString
+		dietary_restrictions []string
 		auth0_id             string
 		host_id              sql.NullInt64
 	)
-	err := row.Scan(&user_id, &name, &email, &dietary_restrictions, &auth0_id, &host_id)
+	err := row.Scan(&user_id, &name, &email, pq.Array(&dietary_restrictions), &auth0_id, &host_id)
 
 	if err != nil {
 		return User{}, err
 	}
 
-	dietaryRestrictionsArray := dbNullStringToArray(dietary_restrictions)
-
 	return User{
 		UserId:              user_id,
 		Name:                name,
 		Email:               email,
-		DietaryRestrictions: dietaryRestrictionsArray,
+		DietaryRestrictions: dietary_restrictions,
 		Auth0Id:             auth0_id,
 		HostId:              host_id.Int64,
 	}, nil
 }
 
-func UpdateUser(db *sql.DB, user User) (User, error) {
-	var colsToUpdate []string
-	var updates []interface{}
-
-	if user.Name != "" {
-		colsToUpdate = append(colsToUpdate, "name")
-		updates = append(updates, user.Name)
+// UpdateUser applies the update to the user. An empty update changes
+// nothing and returns the user as they are.
+func UpdateUser(ctx context.Context, db *sql.DB, auth0Id string, update UserUpdate) (User, error) {
+	if update.IsEmpty() {
+		return GetUserByAuth0Id(ctx, db, auth0Id)
 	}
-	if user.Email != "" {
-		colsToUpdate = append(colsToUpdate, "email")
-		updates = append(updates, user.Email)
+
+	builder := NewUpdateBuilder("users")
+	builder.SetExpression("updated_at", "current_timestamp")
+	if update.Name != nil {
+		builder.Set("name", *update.Name)
 	}
-	if user.DietaryRestrictions != nil {
-		colsToUpdate = append(colsToUpdate, "dietary_restrictions")
-		updates = append(updates, strings.Join(user.DietaryRestrictions, "+"))
+	if update.Email != nil {
+		builder.Set("email", *update.Email)
 	}
 
-	colsToUpdateString := strings.Join(colsToUpdate, ", ")
-	var buffer bytes.Buffer
-	for i := 0; i < len(colsToUpdate)-1; i++ {
-		buffer.WriteString(fmt.Sprintf("$%d, ", i+1))
+	query, args, err := builder.Query(auth0Id,
+		"user_id, name, email, auth0_id")
+	if err != nil {
+		return User{}, err
 	}
-	buffer.WriteString(fmt.Sprintf("$%d", len(colsToUpdate)))
-	paramString := buffer.String()
-
-	query := fmt.Sprintf(`UPDATE users SET (%s) = (%s)
-                              WHERE auth0_id = '%s'
-                              RETURNING name, email,
-                              dietary_restrictions, auth0_id`,
-		colsToUpdateString, paramString, user.Auth0Id)
-
-	row := db.QueryRow(query, updates...)
 
 	var (
-		name                 string
-		email                string
-		dietary_restrictions sql.NullString
-		auth0_id             string
+		user_id  int64
+		name     string
+		email    string
+		auth0_id string
 	)
-	scanErr := row.Scan(&name, &email, &dietary_restrictions, &auth0_id)
-	if scanErr != nil {
-		return User{}, scanErr
+	err = WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user_id, &name,
+			&email, &auth0_id)
+		if err != nil || update.DietaryRestrictions == nil {
+			return err
+		}
+
+		return SetUserDietaryRestrictions(ctx, tx, user_id,
+			*update.DietaryRestrictions)
+	})
+	if err != nil {
+		return User{}, err
 	}
 
-	dietaryRestrictionsArray := dbNullStringToArray(dietary_restrictions)
+	dietaryRestrictions, err := GetDietaryRestrictionsForUser(ctx, db, user_id)
+	if err != nil {
+		return User{}, err
+	}
 
 	return User{
+		UserId:              user_id,
 		Name:                name,
 		Email:               email,
-		DietaryRestrictions: dietaryRestrictionsArray,
+		DietaryRestrictions: dietaryRestrictions,
 		Auth0Id:             auth0_id,
 	}, nil
 }
 
-func CreateHost(db *sql.DB, host Host) (int64, error) {
+// CreateHost creates the host and adds its users in one transaction,
+// so a failure can't leave a host without users.
+func CreateHost(ctx context.Context, db *sql.DB, host Host) (int64, error) {
 	var hostId int64
-	err := db.QueryRow(
+	err := WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		hostId, err = createHost(ctx, tx, host)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return hostId, nil
+}
+
+var ErrHostWithoutUsers = errors.New("Host has to have at least one user")
+
+func createHost(ctx context.Context, db Execer, host Host) (int64, error) {
+	if len(host.Users) == 0 {
+		return 0, ErrHostWithoutUsers
+	}
+
+	timezone := host.Timezone
+	if len(timezone) == 0 {
+		timezone = TimezoneForState(host.State)
+	}
+
+	var hostId int64
+	err := db.QueryRowContext(ctx,
 		`INSERT INTO hosts (
                          address,
                          city,
                          state,
                          zipcode,
-                         max_occupancy
-                     ) VALUES ($1, $2, $3, $4, $5)
+                         max_occupancy,
+                         timezone
+                     ) VALUES ($1, $2, $3, $4, $5, $6)
                      RETURNING host_id`,
 		host.Address,
 		host.City,
 		host.State,
 		host.Zipcode,
-		host.MaxOccupancy).Scan(&hostId)
+		host.MaxOccupancy,
+		timezone).Scan(&hostId)
 
 	if err != nil {
 		return 0, err
@@ -194,7 +377,7 @@ func CreateHost(db *sql.DB, host Host) (int64, error) {
                     host_id,
                     user_id
                  ) VALUES %s`, paramString)
-	_, insertHostUserErr := db.Exec(insertHostUserQuery,
+	_, insertHostUserErr := db.ExecContext(ctx, insertHostUserQuery,
 		insertValues...)
 
 	if insertHostUserErr != nil {
@@ -204,53 +387,71 @@ func CreateHost(db *sql.DB, host Host) (int64, error) {
 	return hostId, nil
 }
 
-func GetUsersForHost(db *sql.DB, hostId int64) (Users, error) {
-	rows, err := db.Query(`SELECT users.user_id,
+func GetUsersForHost(ctx context.Context, db *sql.DB, hostId int64) (Users, error) {
+	users, err := GetUsersForHosts(ctx, db, []int64{hostId})
+	if err != nil {
+		return Users{}, err
+	}
+	return users[hostId], nil
+}
+
+// GetUsersForHosts gets the users of every host in hostIds with one
+// query. Hosts without users get an empty list.
+func GetUsersForHosts(ctx context.Context, db *sql.DB, hostIds []int64) (map[int64]Users, error) {
+	rows, err := db.QueryContext(ctx, `SELECT host_users.host_id,
+                                users.user_id,
                                 users.name,
                                 users.email,
-                                users.dietary_restrictions,
+                                `+dietaryRestrictionsColumn+`,
                                 users.auth0_id
                             FROM users, host_users
-                            WHERE host_users.host_id = $1
-                            AND host_users.user_id = users.user_id`, hostId)
+                            WHERE host_users.host_id = ANY($1)
+                            AND host_users.user_id = users.user_id`,
+		pq.Array(hostIds))
 	if err != nil {
-		return Users{}, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	users := Users{}
+	users := make(map[int64]Users, len(hostIds))
+	for _, hostId := range hostIds {
+		users[hostId] = Users{}
+	}
 	for rows.Next() {
 		var (
+			host_id              int64
 			user_id              int64
 			name                 string
 			email                string
-			dietary_restrictions sql.NullString
+			dietary_restrictions []string
 			auth0_id             string
 		)
-		scanErr := rows.Scan(&user_id, &name, &email,
-			&dietary_restrictions, &auth0_id)
-
-		dietaryRestrictionsArray := dbNullStringToArray(dietary_restrictions)
+		scanErr := rows.Scan(&host_id, &user_id, &name, &email,
+			pq.Array(&dietary_restrictions), &auth0_id)
 
 		if scanErr != nil {
-			return Users{}, scanErr
+			return nil, scanErr
 		}
 
-		users = append(users, User{
+		users[host_id] = append(users[host_id], User{
 			UserId:              user_id,
 			Name:                name,
 			Email:               email,
-			DietaryRestrictions: dietaryRestrictionsArray,
+			DietaryRestrictions: dietary_restrictions,
 			Auth0Id:             auth0_id,
-			HostId:              hostId,
+			HostId:              host_id,
 		})
 	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return users, nil
 }
 
-func GetHost(db *sql.DB, hostId int64) (Host, error) {
-	row := db.QueryRow(`SELECT host_id, address, city,
-                            state, zipcode, max_occupancy
+func GetHost(ctx context.Context, db *sql.DB, hostId int64) (Host, error) {
+	row := db.QueryRowContext(ctx, `SELECT host_id, address, city,
+                            state, zipcode, max_occupancy, timezone
                             FROM hosts WHERE host_id = $1`, hostId)
 
 	var (
@@ -260,14 +461,15 @@ func GetHost(db *sql.DB, hostId int64) (Host, error) {
 		state         string
 		zipcode       string
 		max_occupancy int64
+		timezone      string
 	)
 	scanErr := row.Scan(&host_id, &address, &city,
-		&state, &zipcode, &max_occupancy)
+		&state, &zipcode, &max_occupancy, &timezone)
 	if scanErr != nil {
 		return Host{}, scanErr
 	}
 
-	users, getUserErr := GetUsersForHost(db, hostId)
+	users, getUserErr := GetUsersForHost(ctx, db, hostId)
 
 	if getUserErr != nil {
 		return Host{}, getUserErr
@@ -280,11 +482,33 @@ func GetHost(db *sql.DB, hostId int64) (Host, error) {
 		State:        state,
 		Zipcode:      zipcode,
 		MaxOccupancy: max_occupancy,
+		Timezone:     timezone,
 		Users:        users,
 	}, nil
 }
 
-func ReadHostsFromQueryResults(db *sql.DB, rows *sql.Rows) (Hosts, error) {
+func ReadHostsFromQueryResults(ctx context.Context, db *sql.DB, rows *sql.Rows) (Hosts, error) {
+	hosts, err := scanHosts(rows)
+	if err != nil {
+		return Hosts{}, err
+	}
+
+	if err := loadHostUsers(ctx, db, hosts); err != nil {
+		return Hosts{}, err
+	}
+	for _, host := range hosts {
+		if len(host.Users) <= 0 {
+			return Hosts{}, errors.New("Didn't find any users for host")
+		}
+	}
+
+	return hosts, nil
+}
+
+// scanHosts reads every row of a hosts query, without their users.
+func scanHosts(rows *sql.Rows) (Hosts, error) {
+	defer rows.Close()
+
 	hosts := Hosts{}
 	for rows.Next() {
 		var (
@@ -294,6 +518,7 @@ func ReadHostsFromQueryResults(db *sql.DB, rows *sql.Rows) (Hosts, error) {
 			state        string
 			zipcode      string
 			maxOccupancy int64
+			timezone     string
 		)
 
 		if err := rows.Scan(
@@ -303,18 +528,11 @@ func ReadHostsFromQueryResults(db *sql.DB, rows *sql.Rows) (Hosts, error) {
 			&state,
 			&zipcode,
 			&maxOccupancy,
+			&timezone,
 		); err != nil {
 			return Hosts{}, err
 		}
 
-		users, err := GetUsersForHost(db, hostId)
-		if err != nil {
-			return Hosts{}, err
-		}
-		if len(users) <= 0 {
-			return Hosts{}, errors.New("Didn't find any users for host")
-		}
-
 		hosts = append(hosts, Host{
 			HostId:       hostId,
 			Address:      address,
@@ -322,7 +540,7 @@ func ReadHostsFromQueryResults(db *sql.DB, rows *sql.Rows) (Hosts, error) {
 			State:        state,
 			Zipcode:      zipcode,
 			MaxOccupancy: maxOccupancy,
-			Users:        users,
+			Timezone:     timezone,
 		})
 	}
 
@@ -333,16 +551,68 @@ func ReadHostsFromQueryResults(db *sql.DB, rows *sql.Rows) (Hosts, error) {
 	return hosts, nil
 }
 
-func GetHostsByAddress(db *sql.DB, address string) (Hosts, error) {
+// loadHostUsers fills in the users of all the hosts with one query, so
+// listing hosts doesn't take a query per host.
+func loadHostUsers(ctx context.Context, db *sql.DB, hosts Hosts) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	hostIds := make([]int64, len(hosts))
+	for i, host := range hosts {
+		hostIds[i] = host.HostId
+	}
+	users, err := GetUsersForHosts(ctx, db, hostIds)
+	if err != nil {
+		return err
+	}
+	for i := range hosts {
+		hosts[i].Users = users[hosts[i].HostId]
+	}
+	return nil
+}
+
+// GetHostsByIds gets the hosts in hostIds, with their users, in two
+// queries however many there are. Ids that aren't hosts are left out.
+func GetHostsByIds(ctx context.Context, db *sql.DB, hostIds []int64) (map[int64]Host, error) {
+	byId := make(map[int64]Host, len(hostIds))
+	if len(hostIds) == 0 {
+		return byId, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT host_id, address, city,
+                            state, zipcode, max_occupancy, timezone
+                            FROM hosts WHERE host_id = ANY($1)`,
+		pq.Array(hostIds))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := scanHosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadHostUsers(ctx, db, hosts); err != nil {
+		return nil, err
+	}
+
+	for _, host := range hosts {
+		byId[host.HostId] = host
+	}
+	return byId, nil
+}
+
+func GetHostsByAddress(ctx context.Context, db *sql.DB, address string) (Hosts, error) {
 	numRegex := regexp.MustCompile("[0-9]+")
 	addressNums := strings.Join(numRegex.FindAllString(address, -1), "|")
-	rows, err := db.Query(
+	rows, err := db.QueryContext(ctx,
 		`SELECT hosts.host_id,
                 hosts.address,
                 hosts.city,
                 hosts.state,
                 hosts.zipcode,
-                hosts.max_occupancy
+                hosts.max_occupancy,
+                hosts.timezone
               FROM hosts
               WHERE hosts.address SIMILAR TO '%(' || $1 || ')%'
         `, addressNums)
@@ -350,21 +620,36 @@ func GetHostsByAddress(db *sql.DB, address string) (Hosts, error) {
 		return Hosts{}, err
 	}
 
-	return ReadHostsFromQueryResults(db, rows)
+	return ReadHostsFromQueryResults(ctx, db, rows)
+}
+
+// GetLeastRecentHosts returns the numHosts hosts that should be
+// invited next: hosts that haven't hosted yet, then the ones who hosted
+// longest ago. Hosts with a pending invitation are left out.
+func GetLeastRecentHosts(ctx context.Context, db *sql.DB, numHosts int) (Hosts, error) {
+	return getNextHosts(ctx, db, numHosts, false)
+}
+
+// GetReplacementHosts is GetLeastRecentHosts for replacing a host who
+// passed, which also leaves out hosts who passed in the current round.
+func GetReplacementHosts(ctx context.Context, db *sql.DB, numHosts int) (Hosts, error) {
+	return getNextHosts(ctx, db, numHosts, true)
 }
 
-func GetLeastRecentHosts(db *sql.DB, numHosts int) (Hosts, error) {
-	rows, err := db.Query(
+func getNextHosts(ctx context.Context, db *sql.DB, numHosts int, isReplacement bool) (Hosts, error) {
+	rows, err := db.QueryContext(ctx,
 		`SELECT h.* FROM (
                       (SELECT hosts.host_id,
                           hosts.address,
                           hosts.city,
                           hosts.state,
                           hosts.zipcode,
-                          hosts.max_occupancy
+                          hosts.max_occupancy,
+                          hosts.timezone
                       FROM hosts
                       LEFT JOIN events
                       ON events.host_id = hosts.host_id
+                      AND events.status != 'cancelled'
                       WHERE events.host_id IS NULL)
                    UNION
                      (SELECT hosts.host_id,
@@ -372,24 +657,34 @@ func GetLeastRecentHosts(db *sql.DB, numHosts int) (Hosts, error) {
                           hosts.city,
                           hosts.state,
                           hosts.zipcode,
-                          hosts.max_occupancy
+                          hosts.max_occupancy,
+                          hosts.timezone
                      FROM hosts, events, event_creation_invites
                      WHERE events.host_id = hosts.host_id
+                     AND events.status != 'cancelled'
                      AND event_creation_invites.host_id = hosts.host_id
                      AND event_creation_invites.status != 'pending'
                      ORDER BY events.created_at)
                  ) AS h
-                 LIMIT $1`, numHosts)
+                 WHERE NOT EXISTS (
+                     SELECT 1 FROM event_creation_invites invites
+                     WHERE invites.host_id = h.host_id
+                     AND (invites.status = 'pending'
+                          OR ($2 AND invites.status = 'pass'
+                              AND invites.round_started_at = (
+                                  SELECT MAX(round_started_at)
+                                  FROM event_creation_invites))))
+                 LIMIT $1`, numHosts, isReplacement)
 
 	if err != nil {
 		return Hosts{}, err
 	}
 
-	return ReadHostsFromQueryResults(db, rows)
+	return ReadHostsFromQueryResults(ctx, db, rows)
 }
 
-func AddUserToHost(db *sql.DB, hostId int64, userId int64) (Host, error) {
-	_, addUserErr := db.Exec(`INSERT INTO host_users
+func AddUserToHost(ctx context.Context, db *sql.DB, hostId int64, userId int64) (Host, error) {
+	_, addUserErr := db.ExecContext(ctx, `INSERT INTO host_users
                                   (host_id, user_id)
                                   VALUES ($1, $2)`,
 		hostId, userId)
@@ -398,7 +693,7 @@ func AddUserToHost(db *sql.DB, hostId int64, userId int64) (Host, error) {
 		return Host{}, addUserErr
 	}
 
-	host, getHostErr := GetHost(db, hostId)
+	host, getHostErr := GetHost(ctx, db, hostId)
 
 	if getHostErr != nil {
 		return Host{}, getHostErr
@@ -407,50 +702,60 @@ func AddUserToHost(db *sql.DB, hostId int64, userId int64) (Host, error) {
 	return host, nil
 }
 
-func UpdateHost(db *sql.DB, host Host) (Host, error) {
-	var colsToUpdate []string
-	var updates []interface{}
+// UpdateHost applies the update to the host. An empty update changes
+// nothing and returns the host as it is.
+func UpdateHost(ctx context.Context, db *sql.DB, hostId int64, update HostUpdate) (Host, error) {
+	if update.IsEmpty() {
+		return GetHost(ctx, db, hostId)
+	}
 
-	if host.Address != "" {
-		colsToUpdate = append(colsToUpdate, "address")
-		updates = append(updates, host.Address)
+	builder := NewUpdateBuilder("hosts")
+	builder.SetExpression("updated_at", "current_timestamp")
+	if update.Address != nil {
+		builder.Set("address", *update.Address)
 	}
-	if host.City != "" {
-		colsToUpdate = append(colsToUpdate, "city")
-		updates = append(updates, host.City)
+	if update.City != nil {
+		builder.Set("city", *update.City)
 	}
-	if host.State != "" {
-		colsToUpdate = append(colsToUpdate, "state")
-		updates = append(updates, host.State)
+	if update.State != nil {
+		builder.Set("state", *update.State)
 	}
-	if host.Zipcode != "" {
-		colsToUpdate = append(colsToUpdate, "zipcode")
-		updates = append(updates, host.Zipcode)
+	if update.Zipcode != nil {
+		builder.Set("zipcode", *update.Zipcode)
 	}
-	if host.MaxOccupancy != 0 {
-		colsToUpdate = append(colsToUpdate, "max_occupancy")
-		updates = append(updates, host.MaxOccupancy)
+	if update.MaxOccupancy != nil {
+		builder.Set("max_occupancy", *update.MaxOccupancy)
 	}
-
-	colsToUpdateString := strings.Join(colsToUpdate, ", ")
-	var buffer bytes.Buffer
-	for i := 0; i < len(colsToUpdate)-1; i++ {
-		buffer.WriteString(fmt.Sprintf("$%d, ", i+1))
+	if update.Timezone != nil {
+		timezone := *update.Timezone
+		if len(timezone) == 0 {
+			// Reset from the state, which may be changing too
+			var state string
+			if update.State != nil {
+				state = *update.State
+			} else {
+				host, err := GetHost(ctx, db, hostId)
+				if err != nil {
+					return Host{}, err
+				}
+				state = host.State
+			}
+			timezone = TimezoneForState(state)
+		}
+		builder.Set("timezone", timezone)
 	}
-	buffer.WriteString(fmt.Sprintf("$%d", len(colsToUpdate)))
-	paramString := buffer.String()
 
-	query := fmt.Sprintf(`UPDATE hosts SET (%s) = (%s)
-                              WHERE host_id = '%d'
-                              RETURNING
-                                 address,
+	query, args, err := builder.Query(hostId, `address,
                                  city,
                                  state,
                                  zipcode,
-                                 max_occupancy`,
-		colsToUpdateString, paramString, host.HostId)
+                                 max_occupancy,
+                                 timezone`)
+	if err != nil {
+		return Host{}, err
+	}
 
-	row := db.QueryRow(query, updates...)
+	row := db.QueryRowContext(ctx, query, args...)
 
 	var (
 		address       string
@@ -458,43 +763,107 @@ func UpdateHost(db *sql.DB, host Host) (Host, error) {
 		state         string
 		zipcode       string
 		max_occupancy int64
+		timezone      string
 	)
 
-	err := row.Scan(&address, &city, &state, &zipcode, &max_occupancy)
+	err = row.Scan(&address, &city, &state, &zipcode, &max_occupancy,
+		&timezone)
 	if err != nil {
 		return Host{}, err
 	}
 
-	users, getUsersErr := GetUsersForHost(db, host.HostId)
+	users, getUsersErr := GetUsersForHost(ctx, db, hostId)
 
 	if getUsersErr != nil {
 		return Host{}, getUsersErr
 	}
 
 	return Host{
-		HostId:       host.HostId,
+		HostId:       hostId,
 		Address:      address,
 		City:         city,
 		State:        state,
 		Zipcode:      zipcode,
 		MaxOccupancy: max_occupancy,
+		Timezone:     timezone,
 		Users:        users,
 	}, nil
 }
 
-func CreateEvent(db *sql.DB, event Event) (int64, error) {
+// CreateEvent creates the event and its dish slots in one transaction.
+func CreateEvent(ctx context.Context, db *sql.DB, event Event) (int64, error) {
+	var eventId int64
+	err := WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		eventId, err = createEvent(ctx, tx, event)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return eventId, nil
+}
+
+// ErrNotHostsTurn is returned when a host without a pending invitation
+// tries to create an event or pass their turn.
+var ErrNotHostsTurn = errors.New("Not user's turn to create event")
+
+// CreateInvitedEvent creates an event for a host that was invited to
+// make one and marks their invitation used, in one transaction. It
+// returns ErrNotHostsTurn if the invitation isn't pending anymore.
+func CreateInvitedEvent(ctx context.Context, db *sql.DB, event Event) (int64, error) {
+	var eventId int64
+	err := WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		err := lockPendingInvitation(ctx, tx, event.Host.HostId)
+		if err != nil {
+			return err
+		}
+
+		eventId, err = createEvent(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+
+		return UpdateHostInvitation(ctx, tx, event.Host.HostId, EVENT_CREATED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return eventId, nil
+}
+
+func createEvent(ctx context.Context, db Execer, event Event) (int64, error) {
 	var eventId int64
-	err := db.QueryRow(
+	err := db.QueryRowContext(ctx,
 		`INSERT INTO events (
                          title,
+                         description,
                          happening_at,
-                         host_id
-                     ) VALUES ($1, $2, $3)
+                         host_id,
+                         ends_at,
+                         timezone
+                     ) VALUES ($1, $2, $3, $4, $5,
+                         COALESCE(NULLIF($6, ''),
+                             (SELECT timezone FROM hosts
+                              WHERE host_id = $4)))
                      RETURNING event_id`,
 		event.Title,
+		event.Description,
 		event.HappeningAt,
-		event.Host.HostId).Scan(&eventId)
+		event.Host.HostId,
+		EventEndsAt(event),
+		event.Timezone).Scan(&eventId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	dishSlots := event.DishSlots
+	if len(dishSlots) == 0 {
+		dishSlots = DEFAULT_DISH_SLOTS
+	}
 
+	err = SetDishSlotsForEvent(ctx, db, eventId, dishSlots)
 	if err != nil {
 		return 0, err
 	}
@@ -502,71 +871,262 @@ func CreateEvent(db *sql.DB, event Event) (int64, error) {
 	return eventId, nil
 }
 
-func GetEvent(db *sql.DB, eventId int64) (Event, error) {
-	db, err := Connect()
+// SetDishSlotsForEvent replaces the event's dish slots. The order of
+// dishSlots is kept as the slots' position.
+func SetDishSlotsForEvent(ctx context.Context, db Execer, eventId int64, dishSlots DishSlots) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM event_dish_slots WHERE event_id = $1`,
+		eventId)
+	if err != nil {
+		return err
+	}
+
+	if len(dishSlots) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	var insertValues []interface{}
+
+	for i, dishSlot := range dishSlots {
+		insertValues = append(insertValues, eventId, dishSlot.Name,
+			dishSlot.TargetCount, i)
+
+		var argumentCount = i*4 + 1
+
+		var valueStr string
+		if valueStr = "($%d, $%d, $%d, $%d), "; i == len(dishSlots)-1 {
+			valueStr = "($%d, $%d, $%d, $%d) "
+		}
+
+		buffer.WriteString(fmt.Sprintf(valueStr, argumentCount,
+			argumentCount+1, argumentCount+2, argumentCount+3))
+	}
 
+	query := fmt.Sprintf(
+		`INSERT INTO event_dish_slots (
+                        event_id,
+                        name,
+                        target_count,
+                        position
+                     ) VALUES %s`, buffer.String())
+	_, err = db.ExecContext(ctx, query, insertValues...)
+
+	return err
+}
+
+func GetDishSlotsForEvent(ctx context.Context, db *sql.DB, eventId int64) (DishSlots, error) {
+	dishSlots, err := GetDishSlotsForEvents(ctx, db, []int64{eventId})
 	if err != nil {
-		return Event{}, err
+		return DishSlots{}, err
 	}
-	row := db.QueryRow(`SELECT event_id, title,
-                            happening_at, host_id
+	return dishSlots[eventId], nil
+}
+
+// GetDishSlotsForEvents gets the dish slots of every event in eventIds
+// with one query. Events without slots get an empty list.
+func GetDishSlotsForEvents(ctx context.Context, db *sql.DB, eventIds []int64) (map[int64]DishSlots, error) {
+	rows, err := db.QueryContext(ctx, `SELECT slots.event_id,
+                                slots.name,
+                                slots.target_count,
+                                COUNT(assigned.user_id)
+                            FROM event_dish_slots slots
+                            LEFT JOIN event_users assigned
+                            ON assigned.event_id = slots.event_id
+                            AND assigned.assigned_dish = slots.name
+                            WHERE slots.event_id = ANY($1)
+                            GROUP BY slots.event_dish_slot_id
+                            ORDER BY slots.event_id, slots.position`,
+		pq.Array(eventIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dishSlots := make(map[int64]DishSlots, len(eventIds))
+	for _, eventId := range eventIds {
+		dishSlots[eventId] = DishSlots{}
+	}
+	for rows.Next() {
+		var (
+			eventId  int64
+			dishSlot DishSlot
+		)
+		if err := rows.Scan(&eventId, &dishSlot.Name,
+			&dishSlot.TargetCount, &dishSlot.Filled); err != nil {
+			return nil, err
+		}
+		dishSlots[eventId] = append(dishSlots[eventId], dishSlot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return dishSlots, nil
+}
+
+func GetEvent(ctx context.Context, db *sql.DB, eventId int64) (Event, error) {
+	row := db.QueryRowContext(ctx, `SELECT event_id, title, description,
+                            happening_at, host_id, sequence, status,
+                            ends_at, timezone
                             FROM events WHERE event_id = $1`, eventId)
 
 	var (
 		event_id    int64
 		title       string
+		description sql.NullString
 		happeningAt time.Time
 		hostId      int64
+		sequence    int64
+		status      string
+		endsAt      pq.NullTime
+		timezone    string
 	)
-	scanErr := row.Scan(&event_id, &title,
-		&happeningAt, &hostId)
+	scanErr := row.Scan(&event_id, &title, &description,
+		&happeningAt, &hostId, &sequence, &status, &endsAt, &timezone)
 
 	if scanErr != nil {
 		return Event{}, scanErr
 	}
 
-	host, getHostErr := GetHost(db, hostId)
+	host, getHostErr := GetHost(ctx, db, hostId)
 
 	if getHostErr != nil {
 		return Event{}, getHostErr
 	}
 
-	users, getUsersErr := GetUsersForEvent(db, eventId)
+	users, getUsersErr := GetUsersForEvent(ctx, db, eventId)
 
 	if getUsersErr != nil {
 		return Event{}, getUsersErr
 	}
 
-	return Event{
-		EventId:      eventId,
-		Title:        title,
-		HappeningAt:  happeningAt,
-		Host:         host,
-		Participants: users,
-	}, nil
+	waitlist, err := GetWaitlistForEvent(ctx, db, eventId)
+	if err != nil {
+		return Event{}, err
+	}
+
+	dishSlots, err := GetDishSlotsForEvent(ctx, db, eventId)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return LocalizeEvent(Event{
+		EventId:         eventId,
+		Title:           title,
+		Description:     NullStringToString(description),
+		DescriptionHTML: RenderMarkdown(NullStringToString(description)),
+		HappeningAt:     happeningAt,
+		EndsAt:          NullTimeToPointer(endsAt),
+		Timezone:        timezone,
+		Host:            host,
+		Participants:    users,
+		Waitlist:        waitlist,
+		DishSlots:       dishSlots,
+		Sequence:        sequence,
+		Status:          status,
+	}), nil
 }
 
-func AddUserToEvent(db *sql.DB, eventId int64, userId int64) (Event, error) {
-	_, addUserErr := db.Exec(`INSERT INTO event_users (
+// ErrEventFull is returned by AddUserToEvent when the host's
+// max_occupancy has been reached and the user was waitlisted instead.
+var ErrEventFull = errors.New("Event is full")
+
+var ErrEventCancelled = errors.New("Event has been cancelled")
+
+var ErrUnknownDishSlot = errors.New("Event has no such dish slot")
+var ErrDishSlotFull = errors.New("Dish slot is full")
+
+// leastFilledDishSlotQuery picks the event's dish slot with the lowest
+// ratio of assigned participants to target count, breaking ties by the
+// slot's position. $1 is the event id.
+const leastFilledDishSlotQuery = `(SELECT slots.name
+                                    FROM event_dish_slots slots
+                                    LEFT JOIN event_users assigned
+                                    ON assigned.event_id = slots.event_id
+                                    AND assigned.assigned_dish = slots.name
+                                    WHERE slots.event_id = $1
+                                    GROUP BY slots.event_dish_slot_id
+                                    ORDER BY COUNT(assigned.user_id)::float /
+                                             slots.target_count,
+                                             slots.position
+                                    LIMIT 1)`
+
+const insertEventUserQuery = `INSERT INTO event_users (
                                    event_id,
                                    user_id,
                                    assigned_dish
                                   )
-                                  VALUES ($1, $2,
-                                       next_dish((SELECT eu2.assigned_dish
-                                        FROM event_users eu2
-                                        WHERE created_at = (
-                                           SELECT MAX(created_at)
-                                           FROM event_users eu3
-                                           WHERE eu3.event_id = $1)
-                                        )::dish))`,
-		eventId, userId)
+                                  VALUES ($1, $2, ` +
+	leastFilledDishSlotQuery + `)`
+
+// lockEventForParticipants locks the event row for the rest of the
+// transaction, so concurrent RSVPs can't both take the last spot, and
+// reports whether the event has reached its host's max_occupancy.
+// Cancelled events return ErrEventCancelled.
+func lockEventForParticipants(ctx context.Context, tx *sql.Tx, eventId int64) (bool, error) {
+	var (
+		maxOccupancy     int64
+		participantCount int64
+		status           string
+	)
+	err := tx.QueryRowContext(ctx, `SELECT hosts.max_occupancy,
+                               (SELECT COUNT(*)
+                                FROM event_users
+                                WHERE event_users.event_id = events.event_id),
+                               events.status
+                            FROM events, hosts
+                            WHERE events.event_id = $1
+                            AND hosts.host_id = events.host_id
+                            FOR UPDATE OF events`,
+		eventId).Scan(&maxOccupancy, &participantCount, &status)
+	if err != nil {
+		return false, err
+	}
+	if status == EVENT_CANCELLED {
+		return false, ErrEventCancelled
+	}
 
-	if addUserErr != nil {
-		return Event{}, addUserErr
+	return participantCount >= maxOccupancy, nil
+}
+
+func AddUserToEvent(ctx context.Context, db *sql.DB, eventId int64, userId int64) (Event, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Event{}, err
+	}
+
+	isFull, err := lockEventForParticipants(ctx, tx, eventId)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if isFull {
+		_, err = tx.ExecContext(ctx, `INSERT INTO event_waitlist (event_id, user_id)
+                                  SELECT $1, $2
+                                  WHERE NOT EXISTS (
+                                      SELECT 1 FROM event_users
+                                      WHERE event_id = $1
+                                      AND user_id = $2)
+                                  ON CONFLICT DO NOTHING`,
+			eventId, userId)
+	} else {
+		_, err = tx.ExecContext(ctx, insertEventUserQuery, eventId, userId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Event{}, err
+	}
+
+	if isFull {
+		return Event{}, ErrEventFull
 	}
 
-	event, getEventErr := GetEvent(db, eventId)
+	event, getEventErr := GetEvent(ctx, db, eventId)
 
 	if getEventErr != nil {
 		return Event{}, getEventErr
@@ -575,51 +1135,553 @@ func AddUserToEvent(db *sql.DB, eventId int64, userId int64) (Event, error) {
 	return event, nil
 }
 
-func GetUsersForEvent(db *sql.DB, eventId int64) (Users, error) {
-	rows, queryErr := db.Query(`SELECT DISTINCT users.user_id,
-                                users.name,
-                                users.email,
-                                users.dietary_restrictions,
-                                event_users.assigned_dish,
-                                event_users.bringing
-                            FROM users, event_users
-                            WHERE event_users.event_id = $1
-                            AND event_users.user_id = users.user_id`, eventId)
-	if queryErr != nil {
-		return Users{}, queryErr
+// PromoteFromWaitlist moves users off the front of the event's
+// waitlist, oldest first, until the event is full again. It returns
+// the users that were promoted.
+func PromoteFromWaitlist(ctx context.Context, db *sql.DB, eventId int64) (Users, error) {
+	var promotedUserIds []int64
+	err := WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		promotedUserIds, err = promoteFromWaitlist(ctx, tx, eventId)
+		return err
+	})
+	if err != nil {
+		return Users{}, err
 	}
-	defer rows.Close()
 
-	users := Users{}
-	for rows.Next() {
-		var (
+	participants, err := GetUsersForEvent(ctx, db, eventId)
+	if err != nil {
+		return Users{}, err
+	}
+
+	promoted := Users{}
+	for _, userId := range promotedUserIds {
+		for _, participant := range participants {
+			if participant.UserId == userId {
+				promoted = append(promoted, participant)
+			}
+		}
+	}
+	return promoted, nil
+}
+
+// promoteFromWaitlist is PromoteFromWaitlist in tx. It returns the ids
+// of the users that were promoted.
+func promoteFromWaitlist(ctx context.Context, tx *sql.Tx, eventId int64) ([]int64, error) {
+	promotedUserIds := []int64{}
+	for {
+		isFull, err := lockEventForParticipants(ctx, tx, eventId)
+		if err != nil {
+			return []int64{}, err
+		}
+		if isFull {
+			return promotedUserIds, nil
+		}
+
+		var userId int64
+		err = tx.QueryRowContext(ctx, `DELETE FROM event_waitlist
+                                   WHERE event_waitlist_id = (
+                                       SELECT event_waitlist_id
+                                       FROM event_waitlist
+                                       WHERE event_id = $1
+                                       ORDER BY created_at, event_waitlist_id
+                                       LIMIT 1)
+                                   RETURNING user_id`,
+			eventId).Scan(&userId)
+		if err == sql.ErrNoRows {
+			return promotedUserIds, nil
+		}
+		if err != nil {
+			return []int64{}, err
+		}
+
+		if _, err = tx.ExecContext(ctx, insertEventUserQuery, eventId, userId); err != nil {
+			return []int64{}, err
+		}
+		promotedUserIds = append(promotedUserIds, userId)
+	}
+}
+
+// rebalanceDishes reassigns dishes to the event's remaining
+// participants in RSVP order, each taking the least-filled slot, so
+// the slots stay balanced after someone leaves. Dishes participants
+// claimed for themselves are left alone.
+func rebalanceDishes(ctx context.Context, tx *sql.Tx, eventId int64) error {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id FROM event_users
+                               WHERE event_id = $1
+                               AND NOT dish_claimed
+                               ORDER BY created_at, user_id`, eventId)
+	if err != nil {
+		return err
+	}
+
+	var userIds []int64
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			rows.Close()
+			return err
+		}
+		userIds = append(userIds, userId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE event_users
+                          SET assigned_dish = NULL
+                          WHERE event_id = $1
+                          AND NOT dish_claimed`, eventId)
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range userIds {
+		_, err := tx.ExecContext(ctx, `UPDATE event_users
+                                   SET assigned_dish = `+
+			leastFilledDishSlotQuery+`
+                                   WHERE event_id = $1
+                                   AND user_id = $2`,
+			eventId, userId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reassignRemovedDishes rebalances the event's dishes after its dish
+// slots were replaced. Participants who claimed a slot that's gone are
+// assigned one like everyone else; claims on slots that are still
+// there are kept.
+func reassignRemovedDishes(ctx context.Context, tx *sql.Tx, eventId int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE event_users
+                          SET dish_claimed = false
+                          WHERE event_id = $1
+                          AND dish_claimed
+                          AND NOT EXISTS (
+                              SELECT 1 FROM event_dish_slots slots
+                              WHERE slots.event_id = event_users.event_id
+                              AND slots.name = event_users.assigned_dish)`,
+		eventId)
+	if err != nil {
+		return err
+	}
+
+	return rebalanceDishes(ctx, tx, eventId)
+}
+
+// RemoveUserFromEvent takes the user off the event's participants or
+// waitlist. Remaining dishes are rebalanced and, if a spot opened up,
+// the first waitlisted user is promoted in the same transaction. If
+// emailer isn't nil, the hosts are emailed that a participant left,
+// and promoted users that they have a seat.
+func RemoveUserFromEvent(ctx context.Context, db *sql.DB, emailer *Emailer, eventId int64, userId int64) (Event, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if _, err = lockEventForParticipants(ctx, tx, eventId); err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM event_users
+                                WHERE event_id = $1
+                                AND user_id = $2`, eventId, userId)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+	removedParticipants, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if removedParticipants > 0 {
+		err = rebalanceDishes(ctx, tx, eventId)
+		if err == nil && emailer != nil {
+			err = enqueueParticipantLeft(ctx, db, tx, emailer, eventId, userId)
+		}
+	} else {
+		result, err = tx.ExecContext(ctx, `DELETE FROM event_waitlist
+                                       WHERE event_id = $1
+                                       AND user_id = $2`, eventId, userId)
+		if err == nil {
+			var removedWaitlisted int64
+			removedWaitlisted, err = result.RowsAffected()
+			if err == nil && removedWaitlisted == 0 {
+				err = sql.ErrNoRows
+			}
+		}
+	}
+	var promotedUserIds []int64
+	if err == nil {
+		promotedUserIds, err = promoteFromWaitlist(ctx, tx, eventId)
+	}
+	if err == nil && emailer != nil && len(promotedUserIds) > 0 {
+		err = enqueueWaitlistPromoted(ctx, db, tx, emailer, eventId,
+			promotedUserIds)
+	}
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Event{}, err
+	}
+
+	return GetEvent(ctx, db, eventId)
+}
+
+// enqueueParticipantLeft queues the participant-left email in tx. The
+// event is read outside tx, so it still has the participant and the
+// dish they were bringing.
+func enqueueParticipantLeft(ctx context.Context, db *sql.DB, tx *sql.Tx, emailer *Emailer, eventId int64, userId int64) error {
+	event, err := GetEvent(ctx, db, eventId)
+	if err != nil {
+		return err
+	}
+
+	for _, participant := range event.Participants {
+		if participant.UserId == userId {
+			_, err = EnqueueHostsParticipantLeft(ctx, tx, emailer, event,
+				participant)
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueWaitlistPromoted queues the emails to the promoted users in
+// tx. The event is read outside tx, where they're still waitlisted.
+func enqueueWaitlistPromoted(ctx context.Context, db *sql.DB, tx *sql.Tx, emailer *Emailer, eventId int64, promotedUserIds []int64) error {
+	event, err := GetEvent(ctx, db, eventId)
+	if err != nil {
+		return err
+	}
+
+	promoted := Users{}
+	for _, userId := range promotedUserIds {
+		for _, waitlisted := range event.Waitlist {
+			if waitlisted.UserId == userId {
+				promoted = append(promoted, waitlisted)
+			}
+		}
+	}
+	_, err = EnqueueWaitlistPromoted(ctx, tx, emailer, event, promoted)
+	return err
+}
+
+// CancelEvent marks the event cancelled and queues cancellation emails
+// to its participants. If the host's latest invitation, in the current
+// round, was fulfilled by this event, it's put back to pending, so the
+// cancelled event doesn't cost them their turn. Older invitations are
+// left alone.
+func CancelEvent(ctx context.Context, db *sql.DB, emailer *Emailer, eventId int64) (Event, error) {
+	event, err := GetEvent(ctx, db, eventId)
+	if err != nil {
+		return Event{}, err
+	}
+	if event.Status == EVENT_CANCELLED {
+		return Event{}, ErrEventCancelled
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Event{}, err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE events
+                           SET status = 'cancelled',
+                               cancelled_at = current_timestamp,
+                               sequence = sequence + 1,
+                               updated_at = current_timestamp
+                           WHERE event_id = $1
+                           AND status != 'cancelled'
+                           RETURNING sequence`,
+		eventId).Scan(&event.Sequence)
+	if err == sql.ErrNoRows {
+		err = ErrEventCancelled
+	}
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+	event.Status = EVENT_CANCELLED
+
+	_, err = tx.ExecContext(ctx, `UPDATE event_creation_invites
+                          SET status = 'pending',
+                              sent_at = current_timestamp,
+                              reminded_at = NULL,
+                              updated_at = current_timestamp
+                          WHERE event_creation_invite_id = (
+                              SELECT event_creation_invite_id
+                              FROM event_creation_invites
+                              WHERE host_id = $1
+                              ORDER BY event_creation_invite_id DESC
+                              LIMIT 1)
+                          AND status = 'event_created'
+                          AND round_started_at = (
+                              SELECT MAX(round_started_at)
+                              FROM event_creation_invites)`,
+		event.Host.HostId)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	_, err = EnqueueEventCancelled(ctx, tx, emailer, event)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Event{}, err
+	}
+
+	return GetEvent(ctx, db, eventId)
+}
+
+func getParticipantDish(ctx context.Context, tx *sql.Tx, eventId int64, userId int64) (sql.NullString, error) {
+	var assignedDish sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT assigned_dish FROM event_users
+                            WHERE event_id = $1
+                            AND user_id = $2
+                            FOR UPDATE`,
+		eventId, userId).Scan(&assignedDish)
+	return assignedDish, err
+}
+
+// ChangeParticipantDish applies a participant's DishChange. Claiming a
+// slot only succeeds if the slot exists and isn't already full; a
+// trade swaps the two participants' dishes, so the event's dish
+// distribution is unchanged. Returns sql.ErrNoRows if either user
+// isn't a participant.
+func ChangeParticipantDish(ctx context.Context, db *sql.DB, eventId int64, userId int64, change DishChange) (Event, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Event{}, err
+	}
+
+	err = changeParticipantDish(ctx, tx, eventId, userId, change)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Event{}, err
+	}
+
+	return GetEvent(ctx, db, eventId)
+}
+
+func changeParticipantDish(ctx context.Context, tx *sql.Tx, eventId int64, userId int64, change DishChange) error {
+	if _, err := lockEventForParticipants(ctx, tx, eventId); err != nil {
+		return err
+	}
+
+	currentDish, err := getParticipantDish(ctx, tx, eventId, userId)
+	if err != nil {
+		return err
+	}
+
+	if change.TradeWithUserId != 0 {
+		otherDish, err := getParticipantDish(ctx, tx, eventId,
+			change.TradeWithUserId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE event_users
+                                  SET assigned_dish = CASE
+                                          WHEN user_id = $2 THEN $4
+                                          ELSE $5 END,
+                                      dish_claimed = true
+                                  WHERE event_id = $1
+                                  AND user_id IN ($2, $3)`,
+			eventId, userId, change.TradeWithUserId,
+			otherDish, currentDish)
+		if err != nil {
+			return err
+		}
+	} else if change.AssignedDish != "" &&
+		change.AssignedDish != currentDish.String {
+		var (
+			targetCount int64
+			filled      int64
+		)
+		err := tx.QueryRowContext(ctx, `SELECT slots.target_count,
+                                       COUNT(assigned.user_id)
+                                    FROM event_dish_slots slots
+                                    LEFT JOIN event_users assigned
+                                    ON assigned.event_id = slots.event_id
+                                    AND assigned.assigned_dish = slots.name
+                                    WHERE slots.event_id = $1
+                                    AND slots.name = $2
+                                    GROUP BY slots.event_dish_slot_id`,
+			eventId, change.AssignedDish).Scan(&targetCount, &filled)
+		if err == sql.ErrNoRows {
+			return ErrUnknownDishSlot
+		}
+		if err != nil {
+			return err
+		}
+		if filled >= targetCount {
+			return ErrDishSlotFull
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE event_users
+                                  SET assigned_dish = $3,
+                                      dish_claimed = true
+                                  WHERE event_id = $1
+                                  AND user_id = $2`,
+			eventId, userId, change.AssignedDish)
+		if err != nil {
+			return err
+		}
+	}
+
+	if change.Bringing != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE event_users
+                                  SET bringing = $3
+                                  WHERE event_id = $1
+                                  AND user_id = $2`,
+			eventId, userId, *change.Bringing)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetWaitlistForEvent(ctx context.Context, db *sql.DB, eventId int64) (Users, error) {
+	waitlists, err := GetWaitlistsForEvents(ctx, db, []int64{eventId})
+	if err != nil {
+		return Users{}, err
+	}
+	return waitlists[eventId], nil
+}
+
+// GetWaitlistsForEvents gets the waitlist of every event in eventIds
+// with one query, each in the order people joined it.
+func GetWaitlistsForEvents(ctx context.Context, db *sql.DB, eventIds []int64) (map[int64]Users, error) {
+	rows, err := db.QueryContext(ctx, `SELECT event_waitlist.event_id,
+                                users.user_id,
+                                users.name,
+                                users.email,
+                                `+dietaryRestrictionsColumn+`
+                            FROM users, event_waitlist
+                            WHERE event_waitlist.event_id = ANY($1)
+                            AND event_waitlist.user_id = users.user_id
+                            ORDER BY event_waitlist.created_at,
+                                     event_waitlist.event_waitlist_id`,
+		pq.Array(eventIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	waitlists := make(map[int64]Users, len(eventIds))
+	for _, eventId := range eventIds {
+		waitlists[eventId] = Users{}
+	}
+	for rows.Next() {
+		var (
+			event_id             int64
+			user_id              int64
+			name                 string
+			email                string
+			dietary_restrictions []string
+		)
+		if err := rows.Scan(&event_id, &user_id, &name, &email,
+			pq.Array(&dietary_restrictions)); err != nil {
+			return nil, err
+		}
+
+		waitlists[event_id] = append(waitlists[event_id], User{
+			UserId:              user_id,
+			Name:                name,
+			Email:               email,
+			DietaryRestrictions: dietary_restrictions,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return waitlists, nil
+}
+
+func GetUsersForEvent(ctx context.Context, db *sql.DB, eventId int64) (Users, error) {
+	users, err := GetUsersForEvents(ctx, db, []int64{eventId})
+	if err != nil {
+		return Users{}, err
+	}
+	return users[eventId], nil
+}
+
+// GetUsersForEvents gets the participants of every event in eventIds
+// with one query. Events without participants get an empty list.
+func GetUsersForEvents(ctx context.Context, db *sql.DB, eventIds []int64) (map[int64]Users, error) {
+	rows, queryErr := db.QueryContext(ctx, `SELECT DISTINCT event_users.event_id,
+                                users.user_id,
+                                users.name,
+                                users.email,
+                                `+dietaryRestrictionsColumn+`,
+                                event_users.assigned_dish,
+                                event_users.bringing
+                            FROM users, event_users
+                            WHERE event_users.event_id = ANY($1)
+                            AND event_users.user_id = users.user_id`,
+		pq.Array(eventIds))
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	defer rows.Close()
+
+	users := make(map[int64]Users, len(eventIds))
+	for _, eventId := range eventIds {
+		users[eventId] = Users{}
+	}
+	for rows.Next() {
+		var (
+			event_id             int64
 			user_id              int64
 			name                 string
 			email                string
-			dietary_restrictions sql.NullString
+			dietary_restrictions []string
 			assigned_dish        sql.NullString
 			bringing             sql.NullString
 		)
-		scanErr := rows.Scan(&user_id, &name, &email,
-			&dietary_restrictions,
+		scanErr := rows.Scan(&event_id, &user_id, &name, &email,
+			pq.Array(&dietary_restrictions),
 			&assigned_dish, &bringing)
 
-		dietaryRestrictionsArray :=
-			dbNullStringToArray(dietary_restrictions)
-
 		if scanErr != nil {
-			return Users{}, scanErr
+			return nil, scanErr
 		}
 
-		users = append(users, User{
+		users[event_id] = append(users[event_id], User{
 			UserId:              user_id,
 			Name:                name,
 			Email:               email,
-			DietaryRestrictions: dietaryRestrictionsArray,
+			DietaryRestrictions: dietary_restrictions,
 			AssignedDish:        NullStringToString(assigned_dish),
 			Bringing:            NullStringToString(bringing),
 		})
 	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return users, nil
 }
 
@@ -630,122 +1692,311 @@ func NullStringToString(nullStr sql.NullString) string {
 	return ""
 }
 
-func UpdateEvent(db *sql.DB, event Event) (Event, error) {
-	var colsToUpdate []string
-	var updates []interface{}
-	if event.Title != "" {
-		colsToUpdate = append(colsToUpdate, "title")
-		updates = append(updates, event.Title)
+func NullTimeToPointer(nullTime pq.NullTime) *time.Time {
+	if nullTime.Valid {
+		return &nullTime.Time
 	}
-	if !event.HappeningAt.IsZero() {
-		colsToUpdate = append(colsToUpdate, "happening_at")
-		updates = append(updates, event.HappeningAt)
+	return nil
+}
+
+// UpdateEvent applies the update to the event. If emailer isn't nil,
+// the participants are emailed the updated event, queued in the same
+// transaction as the update. An empty update changes nothing and
+// returns the event as it is.
+func UpdateEvent(ctx context.Context, db *sql.DB, emailer *Emailer, eventId int64, update EventUpdate) (Event, error) {
+	if update.IsEmpty() {
+		return GetEvent(ctx, db, eventId)
 	}
-	if event.Host.HostId != 0 {
-		colsToUpdate = append(colsToUpdate, "host_id")
-		updates = append(updates, event.Host.HostId)
+
+	builder := NewUpdateBuilder("events")
+	if update.Title != nil {
+		builder.Set("title", *update.Title)
+	}
+	if update.Description != nil {
+		builder.Set("description", sql.NullString{
+			String: *update.Description,
+			Valid:  len(*update.Description) > 0,
+		})
+	}
+	if update.HappeningAt != nil {
+		builder.Set("happening_at", *update.HappeningAt)
+	}
+	if update.HostId != nil {
+		builder.Set("host_id", *update.HostId)
 	}
 
-	colsToUpdateString := strings.Join(colsToUpdate, ", ")
-	var buffer bytes.Buffer
-	for i := 0; i < len(colsToUpdate)-1; i++ {
-		buffer.WriteString(fmt.Sprintf("$%d, ", i+1))
+	if update.Timezone != nil && len(*update.Timezone) > 0 {
+		builder.Set("timezone", *update.Timezone)
+	} else if update.Timezone != nil {
+		// Reset to the timezone of the host the event ends up with
+		hostId := "events.host_id"
+		if update.HostId != nil {
+			hostId = builder.Param(*update.HostId)
+		}
+		builder.SetExpression("timezone", `(SELECT timezone FROM hosts
+                                   WHERE hosts.host_id = `+hostId+`)`)
+	}
+
+	// The right hand side of SET sees the row from before the update
+	if update.EndsAt != nil && update.EndsAt.IsZero() {
+		builder.SetExpression("ends_at", "NULL")
+	} else if update.EndsAt != nil {
+		builder.Set("ends_at", *update.EndsAt)
+	} else if update.DurationMinutes != nil && *update.DurationMinutes == 0 {
+		builder.SetExpression("ends_at", "NULL")
+	} else if update.DurationMinutes != nil && update.HappeningAt != nil {
+		builder.Set("ends_at", *EventEndsAt(Event{
+			HappeningAt:     *update.HappeningAt,
+			DurationMinutes: *update.DurationMinutes,
+		}))
+	} else if update.DurationMinutes != nil {
+		builder.SetExpression("ends_at",
+			"happening_at + make_interval(mins => "+
+				builder.Param(*update.DurationMinutes)+")")
+	} else if update.HappeningAt != nil {
+		// Moving the start keeps the event's duration
+		builder.SetExpression("ends_at",
+			builder.Param(*update.HappeningAt)+
+				"::timestamptz + (ends_at - happening_at)")
 	}
-	buffer.WriteString(fmt.Sprintf("$%d", len(colsToUpdate)))
-	paramString := buffer.String()
 
-	query := fmt.Sprintf(`UPDATE events SET (%s) = (%s)
-                          WHERE event_id = '%d'
-                          RETURNING
-                               title,
+	builder.SetExpression("sequence", "sequence + 1")
+	builder.SetExpression("updated_at", "current_timestamp")
+	query, args, err := builder.Query(eventId, `title,
+                               description,
                                happening_at,
-                               host_id`,
-		colsToUpdateString, paramString, event.EventId)
+                               host_id,
+                               sequence,
+                               status,
+                               ends_at,
+                               timezone`)
+	if err != nil {
+		return Event{}, err
+	}
 
-	row := db.QueryRow(query, updates...)
+	var participants Users
+	if emailer != nil {
+		var err error
+		participants, err = GetUsersForEvent(ctx, db, eventId)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Event{}, err
+	}
+
+	row := tx.QueryRowContext(ctx, query, args...)
 
 	var (
 		title       string
+		description sql.NullString
 		happeningAt time.Time
 		hostId      int64
+		sequence    int64
+		status      string
+		newEndsAt   pq.NullTime
+		timezone    string
 	)
 
-	err := row.Scan(&title, &happeningAt, &hostId)
+	err = row.Scan(&title, &description, &happeningAt, &hostId, &sequence,
+		&status, &newEndsAt, &timezone)
+	if err != nil {
+		tx.Rollback()
+		return Event{}, err
+	}
+
+	if update.DishSlots != nil {
+		dishSlots := *update.DishSlots
+		if len(dishSlots) == 0 {
+			dishSlots = DEFAULT_DISH_SLOTS
+		}
+		err = SetDishSlotsForEvent(ctx, tx, eventId, dishSlots)
+		if err == nil {
+			err = reassignRemovedDishes(ctx, tx, eventId)
+		}
+		if err != nil {
+			tx.Rollback()
+			return Event{}, err
+		}
+	}
+
+	if emailer != nil {
+		host, err := GetHost(ctx, db, hostId)
+		if err == nil {
+			_, err = EnqueueEventUpdates(ctx, tx, emailer, LocalizeEvent(Event{
+				EventId:      eventId,
+				Title:        title,
+				Description:  NullStringToString(description),
+				HappeningAt:  happeningAt,
+				EndsAt:       NullTimeToPointer(newEndsAt),
+				Timezone:     timezone,
+				Participants: participants,
+				Host:         host,
+				Sequence:     sequence,
+				Status:       status,
+			}))
+		}
+		if err != nil {
+			tx.Rollback()
+			return Event{}, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Event{}, err
+	}
+
+	host, err := GetHost(ctx, db, hostId)
 	if err != nil {
 		return Event{}, err
 	}
 
-	host, err := GetHost(db, hostId)
+	participants, err = GetUsersForEvent(ctx, db, eventId)
 	if err != nil {
 		return Event{}, err
 	}
 
-	participants, err := GetUsersForEvent(db, event.EventId)
+	waitlist, err := GetWaitlistForEvent(ctx, db, eventId)
 	if err != nil {
 		return Event{}, err
 	}
 
-	return Event{
-		EventId:      event.EventId,
-		Title:        title,
-		HappeningAt:  happeningAt,
-		Participants: participants,
-		Host:         host,
-	}, nil
+	dishSlots, err := GetDishSlotsForEvent(ctx, db, eventId)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return LocalizeEvent(Event{
+		EventId:         eventId,
+		Title:           title,
+		Description:     NullStringToString(description),
+		DescriptionHTML: RenderMarkdown(NullStringToString(description)),
+		HappeningAt:     happeningAt,
+		EndsAt:          NullTimeToPointer(newEndsAt),
+		Timezone:        timezone,
+		Participants:    participants,
+		Waitlist:        waitlist,
+		DishSlots:       dishSlots,
+		Host:            host,
+		Sequence:        sequence,
+		Status:          status,
+	}), nil
 }
 
-func ReadEventsFromQueryResults(db *sql.DB, rows *sql.Rows) (Events, error) {
+// ReadEventsFromQueryResults reads a list of events, then loads their
+// hosts, participants, waitlists and dish slots with one query each, so
+// the number of queries doesn't grow with the number of events.
+func ReadEventsFromQueryResults(ctx context.Context, db *sql.DB, rows *sql.Rows) (Events, error) {
+	defer rows.Close()
+
 	events := Events{}
 	for rows.Next() {
 		var (
 			eventId     int64
 			title       string
+			description sql.NullString
 			happeningAt time.Time
 			hostId      int64
+			sequence    int64
+			status      string
+			endsAt      pq.NullTime
+			timezone    string
 		)
 		if scanErr := rows.Scan(
 			&eventId,
 			&title,
+			&description,
 			&happeningAt,
-			&hostId); scanErr != nil {
+			&hostId,
+			&sequence,
+			&status,
+			&endsAt,
+			&timezone); scanErr != nil {
 			return Events{}, scanErr
 		}
-		host, getHostErr := GetHost(db, hostId)
-
-		if getHostErr != nil {
-			return Events{}, getHostErr
-		}
-
-		participants, getUsersErr := GetUsersForEvent(db, eventId)
-
-		if getUsersErr != nil {
-			return Events{}, getUsersErr
-		}
 
 		events = append(events, Event{
-			EventId:      eventId,
-			Title:        title,
-			HappeningAt:  happeningAt,
-			Participants: participants,
-			Host:         host,
+			EventId:         eventId,
+			Title:           title,
+			Description:     NullStringToString(description),
+			DescriptionHTML: RenderMarkdown(NullStringToString(description)),
+			HappeningAt:     happeningAt,
+			EndsAt:          NullTimeToPointer(endsAt),
+			Timezone:        timezone,
+			Host:            Host{HostId: hostId},
+			Sequence:        sequence,
+			Status:          status,
 		})
 	}
 
 	if err := rows.Err(); err != nil {
 		return Events{}, err
 	}
+	// Give the connection back before the next queries
+	rows.Close()
+
+	if len(events) == 0 {
+		return events, nil
+	}
+
+	eventIds := make([]int64, len(events))
+	hostIds := make([]int64, len(events))
+	for i, event := range events {
+		eventIds[i] = event.EventId
+		hostIds[i] = event.Host.HostId
+	}
+
+	hosts, err := GetHostsByIds(ctx, db, hostIds)
+	if err != nil {
+		return Events{}, err
+	}
+
+	participants, err := GetUsersForEvents(ctx, db, eventIds)
+	if err != nil {
+		return Events{}, err
+	}
+
+	waitlists, err := GetWaitlistsForEvents(ctx, db, eventIds)
+	if err != nil {
+		return Events{}, err
+	}
+
+	dishSlots, err := GetDishSlotsForEvents(ctx, db, eventIds)
+	if err != nil {
+		return Events{}, err
+	}
+
+	for i, event := range events {
+		host, ok := hosts[event.Host.HostId]
+		if !ok {
+			return Events{}, sql.ErrNoRows
+		}
+		event.Host = host
+		event.Participants = participants[event.EventId]
+		event.Waitlist = waitlists[event.EventId]
+		event.DishSlots = dishSlots[event.EventId]
+		events[i] = LocalizeEvent(event)
+	}
 
 	return events, nil
 }
 
-func GetPastEventsForUser(db *sql.DB, userId int64) (Events, error) {
-	rows, err := db.Query(
+func GetPastEventsForUser(ctx context.Context, db *sql.DB, userId int64) (Events, error) {
+	rows, err := db.QueryContext(ctx,
 		`SELECT * FROM
                  ((SELECT
                         events.event_id,
                         events.title,
+                        events.description,
                         events.happening_at,
-                        events.host_id
+                        events.host_id,
+                        events.sequence,
+                        events.status,
+                        events.ends_at,
+                        events.timezone
                  FROM events, event_users
                  WHERE event_users.user_id = $1
 	         AND event_users.event_id = events.event_id
@@ -754,8 +2005,13 @@ func GetPastEventsForUser(db *sql.DB, userId int64) (Events, error) {
 		(SELECT
 		        events.event_id,
 		        events.title,
+		        events.description,
 		        events.happening_at,
-		        events.host_id
+		        events.host_id,
+		        events.sequence,
+		        events.status,
+		        events.ends_at,
+		        events.timezone
 		 FROM events, host_users
 		 WHERE host_users.host_id = events.host_id
 		 AND host_users.user_id = $1
@@ -767,33 +2023,84 @@ func GetPastEventsForUser(db *sql.DB, userId int64) (Events, error) {
 		return Events{}, err
 	}
 
-	return ReadEventsFromQueryResults(db, rows)
+	return ReadEventsFromQueryResults(ctx, db, rows)
 }
 
-func GetCurrentEvents(db *sql.DB) (Events, error) {
-	rows, queryErr := db.Query(
+// GetUpcomingEventsForUser gets the events the user is going to or
+// hosting that haven't happened yet, soonest first.
+func GetUpcomingEventsForUser(ctx context.Context, db *sql.DB, userId int64) (Events, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT * FROM
+                 ((SELECT
+                        events.event_id,
+                        events.title,
+                        events.description,
+                        events.happening_at,
+                        events.host_id,
+                        events.sequence,
+                        events.status,
+                        events.ends_at,
+                        events.timezone
+                 FROM events, event_users
+                 WHERE event_users.user_id = $1
+                 AND event_users.event_id = events.event_id
+                )
+                UNION
+                (SELECT
+                        events.event_id,
+                        events.title,
+                        events.description,
+                        events.happening_at,
+                        events.host_id,
+                        events.sequence,
+                        events.status,
+                        events.ends_at,
+                        events.timezone
+                 FROM events, host_users
+                 WHERE host_users.host_id = events.host_id
+                 AND host_users.user_id = $1
+                )) as result
+                 WHERE result.happening_at >= current_timestamp
+                 ORDER BY result.happening_at`, userId)
+
+	if err != nil {
+		return Events{}, err
+	}
+
+	return ReadEventsFromQueryResults(ctx, db, rows)
+}
+
+func GetCurrentEvents(ctx context.Context, db *sql.DB) (Events, error) {
+	rows, queryErr := db.QueryContext(ctx,
 		`SELECT events.event_id,
                         events.title,
+                        events.description,
                         events.happening_at,
-                        events.host_id
+                        events.host_id,
+                        events.sequence,
+                        events.status,
+                        events.ends_at,
+                        events.timezone
                  FROM events
-                 WHERE events.happening_at >= current_timestamp`)
+                 WHERE events.happening_at >= current_timestamp
+                 AND events.status != 'cancelled'`)
 
 	if queryErr != nil {
 		return Events{}, queryErr
 	}
 
-	return ReadEventsFromQueryResults(db, rows)
+	return ReadEventsFromQueryResults(ctx, db, rows)
 }
 
-func GetPendingHosts(db *sql.DB) (Hosts, error) {
-	rows, err := db.Query(
+func GetPendingHosts(ctx context.Context, db *sql.DB) (Hosts, error) {
+	rows, err := db.QueryContext(ctx,
 		`SELECT hosts.host_id,
                 hosts.address,
                 hosts.city,
                 hosts.state,
                 hosts.zipcode,
-                hosts.max_occupancy
+                hosts.max_occupancy,
+                hosts.timezone
          FROM hosts, event_creation_invites
          WHERE event_creation_invites.status = 'pending'
          AND hosts.host_id = event_creation_invites.host_id`)
@@ -802,10 +2109,30 @@ func GetPendingHosts(db *sql.DB) (Hosts, error) {
 		return Hosts{}, err
 	}
 
-	return ReadHostsFromQueryResults(db, rows)
+	return ReadHostsFromQueryResults(ctx, db, rows)
+}
+
+// AddHostInvitations invites the hosts in a new round.
+func AddHostInvitations(ctx context.Context, db Execer, hosts Hosts) error {
+	return addHostInvitations(ctx, db, hosts, "current_timestamp")
+}
+
+// AddReplacementInvitations invites the hosts in place of hosts who
+// passed, as part of the current round.
+func AddReplacementInvitations(ctx context.Context, db Execer, hosts Hosts) error {
+	return addHostInvitations(ctx, db, hosts, `COALESCE(
+                     (SELECT MAX(round_started_at)
+                      FROM event_creation_invites),
+                     current_timestamp)`)
 }
 
-func AddHostInvitations(db *sql.DB, hosts Hosts) error {
+// addHostInvitations invites the hosts, in the round started at the
+// roundStartedAt SQL expression.
+func addHostInvitations(ctx context.Context, db Execer, hosts Hosts, roundStartedAt string) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+
 	var buffer bytes.Buffer
 	var insertValues []interface{}
 
@@ -816,12 +2143,12 @@ func AddHostInvitations(db *sql.DB, hosts Hosts) error {
 		var argumentCount = (i+1)*2 - 1
 
 		var valueStr string
-		if valueStr = "($%d, $%d), "; i == len(hosts)-1 {
-			valueStr = "($%d, $%d) "
+		if valueStr = "($%d, $%d, %s), "; i == len(hosts)-1 {
+			valueStr = "($%d, $%d, %s) "
 		}
 
 		buffer.WriteString(fmt.Sprintf(valueStr, argumentCount,
-			argumentCount+1))
+			argumentCount+1, roundStartedAt))
 	}
 
 	paramStr := buffer.String()
@@ -829,9 +2156,10 @@ func AddHostInvitations(db *sql.DB, hosts Hosts) error {
 	query := fmt.Sprintf(
 		`INSERT INTO event_creation_invites (
                         host_id,
-                        status
+                        status,
+                        round_started_at
                      ) VALUES %s`, paramStr)
-	_, err := db.Exec(query,
+	_, err := db.ExecContext(ctx, query,
 		insertValues...)
 
 	if err != nil {
@@ -840,9 +2168,10 @@ func AddHostInvitations(db *sql.DB, hosts Hosts) error {
 	return nil
 }
 
-func UpdateHostInvitation(db *sql.DB, hostId int64, status string) error {
-	_, err := db.Exec(`UPDATE event_creation_invites
-                              SET status = $1
+func UpdateHostInvitation(ctx context.Context, db Execer, hostId int64, status string) error {
+	_, err := db.ExecContext(ctx, `UPDATE event_creation_invites
+                              SET status = $1,
+                                  updated_at = current_timestamp
                               WHERE host_id = $2
                               AND status != 'event_created'
                               AND status != 'complete'
@@ -854,36 +2183,216 @@ func UpdateHostInvitation(db *sql.DB, hostId int64, status string) error {
 	return nil
 }
 
-func ExpireEventInvitations(db *sql.DB) (Hosts, error) {
-	rows, err := db.Query(`UPDATE event_creation_invites
-                                  SET status = 'complete'
+func ExpireEventInvitations(ctx context.Context, db *sql.DB) (Hosts, error) {
+	rows, err := db.QueryContext(ctx, `UPDATE event_creation_invites
+                                  SET status = 'complete',
+                                      updated_at = current_timestamp
                                   WHERE status = 'event_created'
                                   RETURNING host_id`)
 	if err != nil {
 		return Hosts{}, err
 	}
+	defer rows.Close()
 
-	hosts := Hosts{}
+	hostIds := []int64{}
 	for rows.Next() {
 		var hostId int64
 		if err := rows.Scan(&hostId); err != nil {
 			return Hosts{}, err
 		}
-		host, err := GetHost(db, hostId)
-		if err != nil {
-			return Hosts{}, err
-		}
-		hosts = append(hosts, host)
+		hostIds = append(hostIds, hostId)
 	}
 
 	if err = rows.Err(); err != nil {
 		return Hosts{}, err
 	}
+	rows.Close()
+
+	byId, err := GetHostsByIds(ctx, db, hostIds)
+	if err != nil {
+		return Hosts{}, err
+	}
+
+	hosts := Hosts{}
+	for _, hostId := range hostIds {
+		host, ok := byId[hostId]
+		if !ok {
+			return Hosts{}, sql.ErrNoRows
+		}
+		hosts = append(hosts, host)
+	}
 	return hosts, nil
 }
 
-func CanHostCreateEvent(db *sql.DB, hostId int64) (bool, error) {
-	rows, err := db.Query(
+func ReadInvitationsFromQueryResults(ctx context.Context, db *sql.DB, rows *sql.Rows) (Invitations, error) {
+	defer rows.Close()
+
+	invitations := Invitations{}
+	for rows.Next() {
+		var (
+			invitationId int64
+			hostId       int64
+			status       string
+			sentAt       time.Time
+			updatedAt    time.Time
+		)
+		if err := rows.Scan(
+			&invitationId,
+			&hostId,
+			&status,
+			&sentAt,
+			&updatedAt,
+		); err != nil {
+			return Invitations{}, err
+		}
+
+		invitations = append(invitations, Invitation{
+			InvitationId: invitationId,
+			Host:         Host{HostId: hostId},
+			Status:       status,
+			SentAt:       sentAt,
+			UpdatedAt:    updatedAt,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return Invitations{}, err
+	}
+	rows.Close()
+
+	hostIds := make([]int64, len(invitations))
+	for i, invitation := range invitations {
+		hostIds[i] = invitation.Host.HostId
+	}
+	hosts, err := GetHostsByIds(ctx, db, hostIds)
+	if err != nil {
+		return Invitations{}, err
+	}
+	for i := range invitations {
+		host, ok := hosts[invitations[i].Host.HostId]
+		if !ok {
+			return Invitations{}, sql.ErrNoRows
+		}
+		invitations[i].Host = host
+	}
+
+	return invitations, nil
+}
+
+// GetInvitations returns every invitation ever sent, most recent first.
+func GetInvitations(ctx context.Context, db *sql.DB) (Invitations, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT event_creation_invite_id,
+                host_id,
+                status,
+                sent_at,
+                updated_at
+         FROM event_creation_invites
+         ORDER BY sent_at DESC, event_creation_invite_id DESC`)
+
+	if err != nil {
+		return Invitations{}, err
+	}
+
+	return ReadInvitationsFromQueryResults(ctx, db, rows)
+}
+
+func SetInvitationStatus(ctx context.Context, db *sql.DB, invitationId int64, status string) (Invitation, error) {
+	rows, err := db.QueryContext(ctx,
+		`UPDATE event_creation_invites
+         SET status = $2,
+             updated_at = current_timestamp
+         WHERE event_creation_invite_id = $1
+         RETURNING event_creation_invite_id,
+                   host_id,
+                   status,
+                   sent_at,
+                   updated_at`, invitationId, status)
+
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	invitations, err := ReadInvitationsFromQueryResults(ctx, db, rows)
+	if err != nil {
+		return Invitation{}, err
+	}
+	if len(invitations) == 0 {
+		return Invitation{}, sql.ErrNoRows
+	}
+	return invitations[0], nil
+}
+
+// IsInvitationRoundDue reports whether it's been at least cadence since
+// the last round of invitations started, or there hasn't been one yet.
+// Invitations replacing hosts who passed don't start a round.
+func IsInvitationRoundDue(ctx context.Context, db *sql.DB, cadence time.Duration) (bool, error) {
+	var isDue bool
+	err := db.QueryRowContext(ctx,
+		`SELECT COALESCE(
+                    MAX(round_started_at) <= current_timestamp -
+                                    make_interval(secs => $1),
+                    true)
+         FROM event_creation_invites`,
+		cadence.Seconds()).Scan(&isDue)
+	return isDue, err
+}
+
+// GetStalePendingInvitations returns pending invitations sent at least
+// age ago. If unremindedOnly is set, invitations that already had a
+// reminder are left out.
+func GetStalePendingInvitations(ctx context.Context, db *sql.DB, age time.Duration, unremindedOnly bool) (Invitations, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT event_creation_invite_id,
+                host_id,
+                status,
+                sent_at,
+                updated_at
+         FROM event_creation_invites
+         WHERE status = 'pending'
+         AND sent_at <= current_timestamp - make_interval(secs => $1)
+         AND (NOT $2 OR reminded_at IS NULL)
+         ORDER BY sent_at, event_creation_invite_id`,
+		age.Seconds(), unremindedOnly)
+
+	if err != nil {
+		return Invitations{}, err
+	}
+
+	return ReadInvitationsFromQueryResults(ctx, db, rows)
+}
+
+func MarkInvitationReminded(ctx context.Context, db Execer, invitationId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE event_creation_invites
+                           SET reminded_at = current_timestamp
+                           WHERE event_creation_invite_id = $1`,
+		invitationId)
+	return err
+}
+
+// lockPendingInvitation locks the host's pending invitation for the
+// rest of the transaction, so two requests can't both use it, or
+// returns ErrNotHostsTurn if they don't have one.
+func lockPendingInvitation(ctx context.Context, tx *sql.Tx, hostId int64) error {
+	var pending int64
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM
+                            (SELECT event_creation_invite_id
+                             FROM event_creation_invites
+                             WHERE host_id = $1
+                             AND status = 'pending'
+                             FOR UPDATE) AS pending`,
+		hostId).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		return ErrNotHostsTurn
+	}
+	return nil
+}
+
+func CanHostCreateEvent(ctx context.Context, db *sql.DB, hostId int64) (bool, error) {
+	rows, err := db.QueryContext(ctx,
 		`SELECT event_creation_invites.host_id,
                 event_creation_invites.status
          FROM event_creation_invites
@@ -893,10 +2402,259 @@ func CanHostCreateEvent(db *sql.DB, hostId int64) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	// The pool is shared, so an unclosed result would leak its connection
+	defer rows.Close()
 
 	for rows.Next() {
 		return true, nil
 	}
 
-	return false, nil
+	return false, rows.Err()
+}
+
+func IsAuth0User(ctx context.Context, db *sql.DB, userId int64, auth0Id string) (bool, error) {
+	var isUser bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+                                SELECT 1 FROM users
+                                WHERE user_id = $1
+                                AND auth0_id = $2)`,
+		userId, auth0Id).Scan(&isUser)
+	return isUser, err
+}
+
+func IsAuth0UserInHost(ctx context.Context, db *sql.DB, hostId int64, auth0Id string) (bool, error) {
+	var isInHost bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+                                SELECT 1 FROM host_users, users
+                                WHERE host_users.host_id = $1
+                                AND host_users.user_id = users.user_id
+                                AND users.auth0_id = $2)`,
+		hostId, auth0Id).Scan(&isInHost)
+	return isInHost, err
+}
+
+func IsAuth0UserHostOfEvent(ctx context.Context, db *sql.DB, eventId int64, auth0Id string) (bool, error) {
+	var isHost bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+                                SELECT 1 FROM events, host_users, users
+                                WHERE events.event_id = $1
+                                AND host_users.host_id = events.host_id
+                                AND host_users.user_id = users.user_id
+                                AND users.auth0_id = $2)`,
+		eventId, auth0Id).Scan(&isHost)
+	return isHost, err
+}
+
+func IsEmailWhitelisted(ctx context.Context, db *sql.DB, email string) (bool, error) {
+	var isWhitelisted bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+                                SELECT 1 FROM user_whitelist
+                                WHERE lower(email) = lower($1))`,
+		strings.TrimSpace(email)).Scan(&isWhitelisted)
+	return isWhitelisted, err
+}
+
+func GetWhitelistedEmails(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT email FROM user_whitelist
+                               ORDER BY lower(email)`)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	emails := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return []string{}, err
+		}
+		emails = append(emails, email)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []string{}, err
+	}
+	return emails, nil
+}
+
+// AddWhitelistedEmails whitelists every email, skipping ones that are
+// already whitelisted, and returns how many were added.
+func AddWhitelistedEmails(ctx context.Context, db *sql.DB, emails []string) (int64, error) {
+	result, err := db.ExecContext(ctx, `INSERT INTO user_whitelist (email)
+                                SELECT DISTINCT ON (lower(e.email)) e.email
+                                FROM unnest($1::varchar[]) AS e(email)
+                                ON CONFLICT (lower(email)) DO NOTHING`,
+		pq.Array(emails))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func RemoveWhitelistedEmail(ctx context.Context, db *sql.DB, email string) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM user_whitelist
+                                WHERE lower(email) = lower($1)`,
+		strings.TrimSpace(email))
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EnqueueOutboundEmails queues emails for delivery and returns their
+// ids. Pass a transaction to queue them along with the change they're
+// about.
+func EnqueueOutboundEmails(ctx context.Context, db Execer, emails OutboundEmails) ([]int64, error) {
+	ids := []int64{}
+	for _, email := range emails {
+		var (
+			outboundEmailId int64
+			userId          sql.NullInt64
+		)
+		if email.UserId != 0 {
+			userId = sql.NullInt64{Int64: email.UserId, Valid: true}
+		}
+		err := db.QueryRowContext(ctx, `INSERT INTO outbound_emails (
+                                        user_id,
+                                        template,
+                                        sender,
+                                        recipient,
+                                        message
+                                    ) VALUES ($1, $2, $3, $4, $5)
+                                    RETURNING outbound_email_id`,
+			userId, email.Template, email.Sender, email.Recipient,
+			email.Message).Scan(&outboundEmailId)
+		if err != nil {
+			return []int64{}, err
+		}
+		ids = append(ids, outboundEmailId)
+	}
+	return ids, nil
+}
+
+const outboundEmailColumns = `outbound_email_id,
+                                COALESCE(user_id, 0),
+                                template,
+                                sender,
+                                recipient,
+                                message,
+                                status,
+                                attempts,
+                                COALESCE(last_error, ''),
+                                next_attempt_at`
+
+func ReadOutboundEmailsFromQueryResults(rows *sql.Rows) (OutboundEmails, error) {
+	defer rows.Close()
+
+	emails := OutboundEmails{}
+	for rows.Next() {
+		var email OutboundEmail
+		err := rows.Scan(
+			&email.OutboundEmailId,
+			&email.UserId,
+			&email.Template,
+			&email.Sender,
+			&email.Recipient,
+			&email.Message,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.NextAttemptAt)
+		if err != nil {
+			return OutboundEmails{}, err
+		}
+		emails = append(emails, email)
+	}
+
+	if err := rows.Err(); err != nil {
+		return OutboundEmails{}, err
+	}
+	return emails, nil
+}
+
+// ClaimOutboundEmails takes up to limit pending emails that are due,
+// or only those in ids if it isn't empty, and counts an attempt for
+// each. Claimed emails aren't due again until lease has passed, so
+// concurrent workers don't send them twice.
+func ClaimOutboundEmails(ctx context.Context, db *sql.DB, ids []int64, limit int, lease time.Duration) (OutboundEmails, error) {
+	var onlyIds interface{}
+	if len(ids) > 0 {
+		onlyIds = pq.Array(ids)
+	}
+
+	rows, err := db.QueryContext(ctx, `UPDATE outbound_emails
+                               SET attempts = attempts + 1,
+                                   next_attempt_at = current_timestamp +
+                                       $3 * interval '1 second'
+                               WHERE outbound_email_id IN (
+                                   SELECT outbound_email_id
+                                   FROM outbound_emails
+                                   WHERE status = 'pending'
+                                   AND next_attempt_at <= current_timestamp
+                                   AND ($1::integer[] IS NULL OR
+                                        outbound_email_id = ANY($1))
+                                   ORDER BY next_attempt_at
+                                   LIMIT $2
+                                   FOR UPDATE SKIP LOCKED)
+                               RETURNING `+outboundEmailColumns,
+		onlyIds, limit, int64(lease/time.Second))
+	if err != nil {
+		return OutboundEmails{}, err
+	}
+
+	return ReadOutboundEmailsFromQueryResults(rows)
+}
+
+func MarkOutboundEmailSent(ctx context.Context, db *sql.DB, outboundEmailId int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE outbound_emails
+                           SET status = 'sent',
+                               last_error = NULL,
+                               sent_at = current_timestamp
+                           WHERE outbound_email_id = $1`,
+		outboundEmailId)
+	return err
+}
+
+// MarkOutboundEmailFailed records a failed attempt. The email is tried
+// again after retryAfter, or given up on if retryAfter is zero.
+func MarkOutboundEmailFailed(ctx context.Context, db *sql.DB, outboundEmailId int64, sendErr string, retryAfter time.Duration) error {
+	status := EMAIL_PENDING
+	if retryAfter == 0 {
+		status = EMAIL_FAILED
+	}
+
+	_, err := db.ExecContext(ctx, `UPDATE outbound_emails
+                           SET status = $2,
+                               last_error = $3,
+                               next_attempt_at = current_timestamp +
+                                   $4 * interval '1 second'
+                           WHERE outbound_email_id = $1`,
+		outboundEmailId, status, sendErr, int64(retryAfter/time.Second))
+	return err
+}
+
+func GetCalendarToken(ctx context.Context, db *sql.DB, userId int64) (string, error) {
+	var calendarToken string
+	err := db.QueryRowContext(ctx, `SELECT calendar_token FROM users
+                            WHERE user_id = $1`,
+		userId).Scan(&calendarToken)
+	return calendarToken, err
+}
+
+// GetUserIdByCalendarToken returns sql.ErrNoRows if no user has the
+// token.
+func GetUserIdByCalendarToken(ctx context.Context, db *sql.DB, calendarToken string) (int64, error) {
+	var userId int64
+	err := db.QueryRowContext(ctx, `SELECT user_id FROM users
+                            WHERE calendar_token = $1`,
+		calendarToken).Scan(&userId)
+	return userId, err
 }
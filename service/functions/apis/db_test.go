@@ -1,20 +1,92 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"math/rand"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func DeleteEverything(db *sql.DB) {
+	db.Exec("DELETE FROM outbound_emails")
 	db.Exec("DELETE FROM event_creation_invites")
+	db.Exec("DELETE FROM event_waitlist")
 	db.Exec("DELETE FROM event_users")
 	db.Exec("DELETE FROM host_users")
 	db.Exec("DELETE FROM events")
 	db.Exec("DELETE FROM hosts")
 	db.Exec("DELETE FROM users")
+	db.Exec("DELETE FROM user_whitelist")
+}
+
+// Updates
+
+// Values that would break out of a quoted SQL string or identifier
+var hostileInputs = []string{
+	"' OR '1'='1",
+	"x'; DELETE FROM users; --",
+	`"; DROP TABLE events; --`,
+	"$1",
+}
+
+func TestUpdateBuilder(t *testing.T) {
+	builder := NewUpdateBuilder("users")
+	if !builder.IsEmpty() {
+		t.Error("Expected a new builder to be empty")
+	}
+	if _, _, err := builder.Query("auth0|1", "user_id"); err == nil {
+		t.Error("Expected an empty update to be an error")
+	}
+
+	for _, hostile := range hostileInputs {
+		builder.Set("name", hostile)
+	}
+	builder.SetExpression("updated_at", "current_timestamp")
+	query, args, err := builder.Query(hostileInputs[0], "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "UPDATE users SET name = $1, name = $2, name = $3, " +
+		"name = $4, updated_at = current_timestamp " +
+		"WHERE auth0_id = $5 RETURNING user_id"
+	if query != expected {
+		t.Errorf("Expected %q, got %q", expected, query)
+	}
+	if len(args) != 5 || args[4] != hostileInputs[0] {
+		t.Errorf("Expected the values and key as parameters: %v", args)
+	}
+	for _, hostile := range hostileInputs[:3] {
+		if strings.Contains(query, hostile) {
+			t.Errorf("Value %q ended up in the query", hostile)
+		}
+	}
+}
+
+func TestUpdateBuilderWhitelist(t *testing.T) {
+	builder := NewUpdateBuilder("events")
+	builder.Set("title", "Soup")
+	for _, column := range append(hostileInputs, "auth0_id", "status") {
+		builder.Set(column, "x")
+		if _, _, err := builder.Query(1, "title"); err == nil {
+			t.Errorf("Expected column %q to be rejected", column)
+		}
+		builder = NewUpdateBuilder("events")
+	}
+
+	builder = NewUpdateBuilder("users; DROP TABLE users")
+	builder.Set("name", "x")
+	if _, _, err := builder.Query(1, "user_id"); err == nil {
+		t.Error("Expected an unknown table to be rejected")
+	}
 }
 
 // User
@@ -63,15 +135,16 @@ func AreUsersEqual(user1 User, user2 User) bool {
 }
 
 func TestCreateReadUser(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
 	fakeUser := GetTestUser()
-	CreateUser(db, fakeUser)
+	CreateUser(ctx, db, fakeUser)
 
-	userFromDb, err := GetUserByAuth0Id(db, fakeUser.Auth0Id)
+	userFromDb, err := GetUserByAuth0Id(ctx, db, fakeUser.Auth0Id)
 	if err != nil {
 		t.Error(err)
 	}
@@ -86,12 +159,13 @@ func TestCreateReadUser(t *testing.T) {
 }
 
 func TestEditUser(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 	fakeUser := GetTestUser()
-	_, err = CreateUser(db, fakeUser)
+	_, err = CreateUser(ctx, db, fakeUser)
 
 	if err != nil {
 		t.Error(err)
@@ -100,7 +174,10 @@ func TestEditUser(t *testing.T) {
 	fakeUser.Email = "AnotherEmail"
 	fakeUser.DietaryRestrictions = []string{"blueberries", "nuts"}
 
-	editedFakeDbUser, err := UpdateUser(db, fakeUser)
+	editedFakeDbUser, err := UpdateUser(ctx, db, fakeUser.Auth0Id, UserUpdate{
+		Email:               &fakeUser.Email,
+		DietaryRestrictions: &fakeUser.DietaryRestrictions,
+	})
 
 	if err != nil {
 		t.Error(err)
@@ -112,6 +189,157 @@ func TestEditUser(t *testing.T) {
 			fakeUser)
 	}
 
+	unchangedUser, err := UpdateUser(ctx, db, fakeUser.Auth0Id, UserUpdate{})
+	if err != nil || !AreUsersEqual(unchangedUser, fakeUser) {
+		t.Errorf("Expected an empty update to change nothing: %v %v",
+			unchangedUser, err)
+	}
+
+	patch := Patch{"dietaryRestrictions": []byte("null")}
+	update, err := patch.UserUpdate()
+	if err != nil {
+		t.Error(err)
+	}
+	clearedUser, err := UpdateUser(ctx, db, fakeUser.Auth0Id, update)
+	if err != nil || len(clearedUser.DietaryRestrictions) != 0 ||
+		clearedUser.Email != fakeUser.Email {
+		t.Errorf("Expected only dietary restrictions to be cleared: %v %v",
+			clearedUser, err)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestEditWithHostileInput(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+	otherUser := GetTestUser()
+	_, err = CreateUser(ctx, db, otherUser)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, hostile := range hostileInputs {
+		name := hostile
+		_, err = UpdateUser(ctx, db, hostile, UserUpdate{Name: &name})
+		if err != sql.ErrNoRows {
+			t.Errorf("Expected no user with auth0Id %q, got %v", hostile,
+				err)
+		}
+
+		editedEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId,
+			EventUpdate{Title: &name, Description: &name})
+		if err != nil || editedEvent.Title != hostile ||
+			editedEvent.Description != hostile {
+			t.Errorf("Expected %q to be stored as is: %v %v", hostile,
+				editedEvent, err)
+		}
+
+		editedHost, err := UpdateHost(ctx, db, fakeEvent.Host.HostId,
+			HostUpdate{Address: &name})
+		if err != nil || editedHost.Address != hostile {
+			t.Errorf("Expected %q to be stored as is: %v %v", hostile,
+				editedHost, err)
+		}
+	}
+
+	dbUser, err := GetUserByAuth0Id(ctx, db, otherUser.Auth0Id)
+	if err != nil || dbUser.Name != otherUser.Name {
+		t.Errorf("Expected other users to be untouched: %v %v", dbUser, err)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestDietaryRestrictionsWithPlus(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeUser := GetTestUser()
+	fakeUser.DietaryRestrictions = []string{"no cilantro + no onions",
+		"Gluten Free"}
+	_, err = CreateUser(ctx, db, fakeUser)
+	if err != nil {
+		t.Error(err)
+	}
+
+	userFromDb, err := GetUserByAuth0Id(ctx, db, fakeUser.Auth0Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeUser.DietaryRestrictions = []string{"no cilantro + no onions",
+		"gluten-free"}
+	if !AreUsersEqual(userFromDb, fakeUser) {
+		t.Errorf("User in DB doesn't match created user: \n  %v \n %v \n",
+			userFromDb,
+			fakeUser)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestWhitelist(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	added, err := AddWhitelistedEmails(ctx, db, []string{"friend@example.com",
+		"Friend@Example.com", "neighbor@example.com"})
+	if err != nil {
+		t.Error(err)
+	}
+	if added != 2 {
+		t.Errorf("Expected 2 emails added, got %d", added)
+	}
+
+	added, err = AddWhitelistedEmails(ctx, db, []string{"neighbor@example.com"})
+	if err != nil {
+		t.Error(err)
+	}
+	if added != 0 {
+		t.Errorf("Expected already whitelisted email to be skipped")
+	}
+
+	isWhitelisted, err := IsEmailWhitelisted(ctx, db, "FRIEND@example.com")
+	if err != nil || !isWhitelisted {
+		t.Errorf("Expected whitelist to ignore email case")
+	}
+
+	err = RemoveWhitelistedEmail(ctx, db, "friend@example.com")
+	if err != nil {
+		t.Error(err)
+	}
+
+	emails, err := GetWhitelistedEmails(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(emails, []string{"neighbor@example.com"}) {
+		t.Errorf("Wrong whitelisted emails: %v", emails)
+	}
+
+	err = RemoveWhitelistedEmail(ctx, db, "friend@example.com")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
 	DeleteEverything(db)
 	db.Close()
 }
@@ -159,9 +387,9 @@ func AreHostsEqual(host1 Host, host2 Host) bool {
 			host2.Users)
 }
 
-func CreateFakeHost(db *sql.DB) (Host, error) {
+func CreateFakeHost(ctx context.Context, db *sql.DB) (Host, error) {
 	fakeUser := GetTestUser()
-	userId, err := CreateUser(db, fakeUser)
+	userId, err := CreateUser(ctx, db, fakeUser)
 
 	if err != nil {
 		return Host{}, err
@@ -169,24 +397,25 @@ func CreateFakeHost(db *sql.DB) (Host, error) {
 
 	fakeHost := GetTestHost()
 	fakeHost.Users = Users{User{UserId: userId}}
-	hostId, err := CreateHost(db, fakeHost)
+	hostId, err := CreateHost(ctx, db, fakeHost)
 	fakeHost.HostId = hostId
 	return fakeHost, err
 }
 
 func TestCreateReadHost(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeHost, err := CreateFakeHost(db)
+	fakeHost, err := CreateFakeHost(ctx, db)
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeDbHost, err := GetHost(db, fakeHost.HostId)
+	fakeDbHost, err := GetHost(ctx, db, fakeHost.HostId)
 
 	if err != nil {
 		t.Error(err)
@@ -203,19 +432,20 @@ func TestCreateReadHost(t *testing.T) {
 }
 
 func TestGetLeastRecentHosts(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	event1, err := CreateFakeEvent(db, GetFakeEvent())
+	event1, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 	if err != nil {
 		t.Error(err)
 	}
 
 	host1 := event1.Host
 
-	event2, err := CreateFakeEvent(db, GetFakeEvent())
+	event2, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 	if err != nil {
 		t.Error(err)
 	}
@@ -223,14 +453,14 @@ func TestGetLeastRecentHosts(t *testing.T) {
 	host2 := event2.Host
 
 	// Create a third event that is the *most* recent
-	_, err = CreateFakeEvent(db, GetFakeEvent())
+	_, err = CreateFakeEvent(ctx, db, GetFakeEvent())
 	if err != nil {
 		t.Error(err)
 	}
 
 	leastRecentHosts := Hosts{host1, host2}
 
-	leastRecentDbHosts, err := GetLeastRecentHosts(db, 2)
+	leastRecentDbHosts, err := GetLeastRecentHosts(ctx, db, 2)
 	if err != nil {
 		t.Error(err)
 	}
@@ -258,12 +488,13 @@ func TestGetLeastRecentHosts(t *testing.T) {
 }
 
 func TestAddUserToHost(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeHost, err := CreateFakeHost(db)
+	fakeHost, err := CreateFakeHost(ctx, db)
 
 	if err != nil {
 		t.Error(err)
@@ -275,14 +506,14 @@ func TestAddUserToHost(t *testing.T) {
 		Auth0Id: "19191",
 	}
 
-	userId, err := CreateUser(db, anotherFakeUser)
+	userId, err := CreateUser(ctx, db, anotherFakeUser)
 	if err != nil {
 		t.Error(err)
 	}
 
 	anotherFakeUser.UserId = userId
 
-	fakeDbHost, err := AddUserToHost(db, fakeHost.HostId, userId)
+	fakeDbHost, err := AddUserToHost(ctx, db, fakeHost.HostId, userId)
 
 	if err != nil {
 		t.Error(err)
@@ -301,12 +532,13 @@ func TestAddUserToHost(t *testing.T) {
 }
 
 func TestEditHost(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeHost, err := CreateFakeHost(db)
+	fakeHost, err := CreateFakeHost(ctx, db)
 
 	if err != nil {
 		t.Error(err)
@@ -315,7 +547,10 @@ func TestEditHost(t *testing.T) {
 	fakeHost.Address = "Another Address"
 	fakeHost.MaxOccupancy = 1241
 
-	editedFakeDbHost, err := UpdateHost(db, fakeHost)
+	editedFakeDbHost, err := UpdateHost(ctx, db, fakeHost.HostId, HostUpdate{
+		Address:      &fakeHost.Address,
+		MaxOccupancy: &fakeHost.MaxOccupancy,
+	})
 
 	if err != nil {
 		t.Error(err)
@@ -354,25 +589,25 @@ func GetFakeEvent() Event {
 	}
 }
 
-func CreateFakeEvent(db *sql.DB, event Event) (Event, error) {
-	fakeHost, err := CreateFakeHost(db)
+func CreateFakeEvent(ctx context.Context, db *sql.DB, event Event) (Event, error) {
+	fakeHost, err := CreateFakeHost(ctx, db)
 
 	if err != nil {
 		return Event{}, err
 	}
 
-	err = AddHostInvitations(db, Hosts{fakeHost})
+	err = AddHostInvitations(ctx, db, Hosts{fakeHost})
 	if err != nil {
 		return Event{}, err
 	}
 
 	event.Host = fakeHost
-	eventId, err := CreateEvent(db, event)
+	eventId, err := CreateEvent(ctx, db, event)
 	if err != nil {
 		return Event{}, err
 	}
 	event.EventId = eventId
-	err = UpdateHostInvitation(db, fakeHost.HostId, EVENT_CREATED)
+	err = UpdateHostInvitation(ctx, db, fakeHost.HostId, EVENT_CREATED)
 	if err != nil {
 		return Event{}, err
 	}
@@ -381,18 +616,19 @@ func CreateFakeEvent(db *sql.DB, event Event) (Event, error) {
 }
 
 func TestCreateReadEvent(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEvent, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeDbEvent, err := GetEvent(db, fakeEvent.EventId)
+	fakeDbEvent, err := GetEvent(ctx, db, fakeEvent.EventId)
 
 	if err != nil {
 		t.Error(err)
@@ -413,12 +649,13 @@ func TestCreateReadEvent(t *testing.T) {
 }
 
 func TestAddParticipantToEvent(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEvent, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 
 	if err != nil {
 		t.Error(err)
@@ -430,14 +667,14 @@ func TestAddParticipantToEvent(t *testing.T) {
 		Auth0Id: "191911919",
 	}
 
-	userId, err := CreateUser(db, anotherFakeUser)
+	userId, err := CreateUser(ctx, db, anotherFakeUser)
 	anotherFakeUser.UserId = userId
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeDbEvent, err := AddUserToEvent(db, fakeEvent.EventId, userId)
+	fakeDbEvent, err := AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
 
 	if err != nil {
 		t.Error(err)
@@ -463,231 +700,995 @@ func TestAddParticipantToEvent(t *testing.T) {
 	db.Close()
 }
 
-func TestReadCurrentEvents(t *testing.T) {
+func TestAddParticipantToFullEvent(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakePastEventPartial := GetFakeEvent()
-	fakePastEventPartial.HappeningAt = time.Now().AddDate(-1, 0, 0)
-	_, err = CreateFakeEvent(db, fakePastEventPartial)
-
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 	if err != nil {
 		t.Error(err)
 	}
 
-	futureFakeEvent, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEvent.Host.MaxOccupancy = 1
+	_, err = UpdateHost(ctx, db, fakeEvent.Host.HostId, HostUpdate{
+		MaxOccupancy: &fakeEvent.Host.MaxOccupancy,
+	})
+	if err != nil {
+		t.Error(err)
+	}
 
+	firstUserId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	secondUserId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	thirdUserId, err := CreateUser(ctx, db, GetTestUser())
 	if err != nil {
 		t.Error(err)
 	}
 
-	futureEvents := Events{futureFakeEvent}
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, firstUserId)
+	if err != nil {
+		t.Error(err)
+	}
 
-	futureDbEvents, err := GetCurrentEvents(db)
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, secondUserId)
+	if err != ErrEventFull {
+		t.Errorf("Expected ErrEventFull, got %v", err)
+	}
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, thirdUserId)
+	if err != ErrEventFull {
+		t.Errorf("Expected ErrEventFull, got %v", err)
+	}
 
+	fakeDbEvent, err := GetEvent(ctx, db, fakeEvent.EventId)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if len(futureEvents) != len(futureDbEvents) {
-		t.Errorf(`Current events got the wrong number of events
+	if len(fakeDbEvent.Participants) != 1 ||
+		fakeDbEvent.Participants[0].UserId != firstUserId {
+		t.Errorf("Expected only the first user to be a participant: %v",
+			fakeDbEvent.Participants)
+	}
 
-                          len %d:  %s
+	if len(fakeDbEvent.Waitlist) != 2 ||
+		fakeDbEvent.Waitlist[0].UserId != secondUserId ||
+		fakeDbEvent.Waitlist[1].UserId != thirdUserId {
+		t.Errorf("Expected waitlist in RSVP order: %v",
+			fakeDbEvent.Waitlist)
+	}
 
-                          len %d:  %s`,
-			len(futureEvents), futureEvents,
-			len(futureDbEvents), futureDbEvents)
+	db.Exec(`DELETE FROM event_users WHERE user_id = $1`, firstUserId)
+
+	promoted, err := PromoteFromWaitlist(ctx, db, fakeEvent.EventId)
+	if err != nil {
+		t.Error(err)
 	}
 
-	if !AreEventsEqual(futureEvents[0], futureDbEvents[0]) {
-		t.Errorf(`Current events got the wrong events
+	if len(promoted) != 1 || promoted[0].UserId != secondUserId {
+		t.Errorf("Expected the first waitlisted user to be promoted: %v",
+			promoted)
+	}
 
-                          %s
+	fakeDbEvent, err = GetEvent(ctx, db, fakeEvent.EventId)
+	if err != nil {
+		t.Error(err)
+	}
 
-                          %s`, futureEvents, futureDbEvents)
+	if len(fakeDbEvent.Waitlist) != 1 ||
+		fakeDbEvent.Waitlist[0].UserId != thirdUserId {
+		t.Errorf("Expected the last user to still be waitlisted: %v",
+			fakeDbEvent.Waitlist)
 	}
 
 	DeleteEverything(db)
 	db.Close()
 }
 
-func TestReadEventsForUser(t *testing.T) {
+func TestRemoveParticipantFromEvent(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEvent, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEventWithParticipant, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEvent.Host.MaxOccupancy = 2
+	_, err = UpdateHost(ctx, db, fakeEvent.Host.HostId, HostUpdate{
+		MaxOccupancy: &fakeEvent.Host.MaxOccupancy,
+	})
 	if err != nil {
 		t.Error(err)
 	}
 
-	anotherFakeUser := User{
-		Name:    "A User By Any Other Name",
-		Email:   "email@email.org",
-		Auth0Id: "191911919",
-	}
+	var userIds []int64
+	for i := 0; i < 3; i++ {
+		userId, err := CreateUser(ctx, db, GetTestUser())
+		if err != nil {
+			t.Error(err)
+		}
+		userIds = append(userIds, userId)
 
-	userId, createUserErr := CreateUser(db, anotherFakeUser)
-	anotherFakeUser.UserId = userId
+		AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+	}
 
-	if createUserErr != nil {
-		t.Error(createUserErr)
+	// A failed promotion rolls the removal back with it
+	testDB := openTestDB(t)
+	defer testDB.Close()
+	testPostgres.FailOn("INSERT INTO event_users")
+	_, err = RemoveUserFromEvent(ctx, testDB, nil, fakeEvent.EventId,
+		userIds[0])
+	testPostgres.FailOn("")
+	if err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	fakeDbEvent, err := GetEvent(ctx, db, fakeEvent.EventId)
+	if err != nil || !UsersContainsId(fakeDbEvent.Participants, userIds[0]) {
+		t.Errorf("Expected the user to still be a participant: %v %v",
+			fakeDbEvent.Participants, err)
 	}
 
-	_, err = AddUserToEvent(db, fakeEventWithParticipant.EventId, userId)
+	emailer, _ := newTestEmailer(t)
+	fakeDbEvent, err = RemoveUserFromEvent(ctx, db, emailer,
+		fakeEvent.EventId, userIds[0])
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEventWithParticipant.Participants = append(
-		fakeEventWithParticipant.Participants,
-		anotherFakeUser,
-	)
-
-	// Test that we only get the events for the participant
-	eventsWithAnotherUser := Events{fakeEventWithParticipant}
-
-	eventsDbWithAnotherUser, err := GetPastEventsForUser(db, userId)
-	if err != nil {
-		t.Error(err)
+	var promotedUserId int64
+	err = db.QueryRow(`SELECT user_id FROM outbound_emails
+                           WHERE template = $1`,
+		EMAIL_WAITLIST_PROMOTED).Scan(&promotedUserId)
+	if err != nil || promotedUserId != userIds[2] {
+		t.Errorf("Expected the promoted user to be emailed, got %d: %v",
+			promotedUserId, err)
 	}
 
-	if len(eventsWithAnotherUser) != len(eventsDbWithAnotherUser) {
-		t.Errorf(`Events for user got the wrong number of events
-                          user_id: %d
+	if UsersContainsId(fakeDbEvent.Participants, userIds[0]) {
+		t.Errorf("Removed user is still a participant: %v",
+			fakeDbEvent.Participants)
+	}
 
-                          len %d:  %s
+	if len(fakeDbEvent.Participants) != 2 ||
+		!UsersContainsId(fakeDbEvent.Participants, userIds[2]) ||
+		len(fakeDbEvent.Waitlist) != 0 {
+		t.Errorf("Expected waitlisted user to be promoted: %v %v",
+			fakeDbEvent.Participants, fakeDbEvent.Waitlist)
+	}
 
-                          len %d:  %s`,
-			userId,
-			len(eventsWithAnotherUser), eventsWithAnotherUser,
-			len(eventsDbWithAnotherUser), eventsDbWithAnotherUser)
+	for _, participant := range fakeDbEvent.Participants {
+		if participant.UserId == userIds[1] &&
+			participant.AssignedDish != "main" {
+			t.Errorf("Expected dishes to be rebalanced, got %s",
+				participant.AssignedDish)
+		}
 	}
 
-	if !AreEventsEqual(eventsWithAnotherUser[0], eventsDbWithAnotherUser[0]) {
-		t.Errorf(`Events for user got the wrong events
+	_, err = RemoveUserFromEvent(ctx, db, nil, fakeEvent.EventId, userIds[0])
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows removing a non-participant, got %v",
+			err)
+	}
 
-                          %s
+	DeleteEverything(db)
+	db.Close()
+}
 
-                          %s`, eventsWithAnotherUser, eventsDbWithAnotherUser[0])
+func TestAssignLeastFilledDishSlot(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
 	}
 
-	// Test that we only get the events for a host user
-	hostUserEvents := Events{fakeEvent}
-
-	hostUserId := fakeEvent.Host.Users[0].UserId
-	hostUserDbEvents, err := GetPastEventsForUser(db, hostUserId)
+	fakeEventPartial := GetFakeEvent()
+	fakeEventPartial.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 2},
+		DishSlot{Name: "side", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+	}
+	fakeEvent, err := CreateFakeEvent(ctx, db, fakeEventPartial)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if len(hostUserEvents) != len(hostUserDbEvents) {
-		t.Errorf(`Events for user got the wrong number of events
-                          user_id: %d
+	var fakeDbEvent Event
+	for i := 0; i < 4; i++ {
+		userId, err := CreateUser(ctx, db, GetTestUser())
+		if err != nil {
+			t.Error(err)
+		}
 
-                          len %d:  %s
+		fakeDbEvent, err = AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+		if err != nil {
+			t.Error(err)
+		}
+	}
 
-                          len %d:  %s`,
-			hostUserId,
-			len(hostUserEvents), hostUserEvents,
-			len(hostUserDbEvents), hostUserDbEvents)
+	expectedDishSlots := DishSlots{
+		DishSlot{Name: "main", TargetCount: 2, Filled: 2},
+		DishSlot{Name: "side", TargetCount: 1, Filled: 1},
+		DishSlot{Name: "dessert", TargetCount: 1, Filled: 1},
 	}
 
-	if !AreEventsEqual(hostUserEvents[0], hostUserDbEvents[0]) {
-		t.Errorf(`Events for user got the wrong events
+	if !reflect.DeepEqual(fakeDbEvent.DishSlots, expectedDishSlots) {
+		t.Errorf(`Dish slots weren't filled evenly
 
-                          %s
+                          %v
 
-                          %s`, hostUserEvents, hostUserDbEvents)
+                          %v`, fakeDbEvent.DishSlots, expectedDishSlots)
 	}
 
 	DeleteEverything(db)
 	db.Close()
 }
 
-func TestEditEvent(t *testing.T) {
+func TestChangeParticipantDish(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEvent, err := CreateFakeEvent(db, GetFakeEvent())
+	fakeEventPartial := GetFakeEvent()
+	fakeEventPartial.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+		DishSlot{Name: "chairs", TargetCount: 1},
+	}
+	fakeEvent, err := CreateFakeEvent(ctx, db, fakeEventPartial)
+	if err != nil {
+		t.Error(err)
+	}
 
+	firstUserId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	secondUserId, err := CreateUser(ctx, db, GetTestUser())
 	if err != nil {
 		t.Error(err)
 	}
 
-	fakeEvent.Title = "Another Title"
-	fakeEvent.Description = "gunna be lit"
+	// main, then dessert
+	AddUserToEvent(ctx, db, fakeEvent.EventId, firstUserId)
+	AddUserToEvent(ctx, db, fakeEvent.EventId, secondUserId)
+
+	_, err = ChangeParticipantDish(ctx, db, fakeEvent.EventId, firstUserId,
+		DishChange{AssignedDish: "dessert"})
+	if err != ErrDishSlotFull {
+		t.Errorf("Expected ErrDishSlotFull, got %v", err)
+	}
 
-	editedFakeDbEvent, err := UpdateEvent(db, fakeEvent)
+	_, err = ChangeParticipantDish(ctx, db, fakeEvent.EventId, firstUserId,
+		DishChange{AssignedDish: "soup"})
+	if err != ErrUnknownDishSlot {
+		t.Errorf("Expected ErrUnknownDishSlot, got %v", err)
+	}
 
+	bringing := "folding chairs"
+	_, err = ChangeParticipantDish(ctx, db, fakeEvent.EventId, firstUserId,
+		DishChange{AssignedDish: "chairs", Bringing: &bringing})
 	if err != nil {
 		t.Error(err)
 	}
 
-	if !AreEventsEqual(editedFakeDbEvent, fakeEvent) {
-		t.Errorf("Event in DB doesn't match edited event \n  %s \n %s \n",
-			editedFakeDbEvent,
-			fakeEvent)
+	fakeDbEvent, err := ChangeParticipantDish(ctx, db, fakeEvent.EventId,
+		firstUserId, DishChange{TradeWithUserId: secondUserId})
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, participant := range fakeDbEvent.Participants {
+		if participant.UserId == firstUserId &&
+			(participant.AssignedDish != "dessert" ||
+				participant.Bringing != bringing) {
+			t.Errorf("First user's dish wasn't traded: %v", participant)
+		}
+		if participant.UserId == secondUserId &&
+			participant.AssignedDish != "chairs" {
+			t.Errorf("Second user's dish wasn't traded: %v", participant)
+		}
 	}
 
 	DeleteEverything(db)
 	db.Close()
 }
 
-func TestSendEmail(t *testing.T) {
+func TestReadCurrentEvents(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	aliceUser := User{
-		Name:    "Alice Rottersman",
-		Email:   "foodwithphriends@mailinator.com",
-		Auth0Id: "1919191",
-	}
-
-	userId, err := CreateUser(db, aliceUser)
-
-	aliceUser.UserId = userId
+	fakePastEventPartial := GetFakeEvent()
+	fakePastEventPartial.HappeningAt = time.Now().AddDate(-1, 0, 0)
+	_, err = CreateFakeEvent(ctx, db, fakePastEventPartial)
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	_, err = CreateHost(db, Host{
-		Address: "743 South Darien Str",
-		Users:   Users{aliceUser},
-	})
+	futureFakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	leastRecentHosts, _ := GetLeastRecentHosts(db, 1)
+	futureEvents := Events{futureFakeEvent}
+
+	futureDbEvents, err := GetCurrentEvents(ctx, db)
 
-	err = SendEmailsToLeastRecentHosts(db, 1)
 	if err != nil {
 		t.Error(err)
 	}
 
-	pendingHosts, err := GetPendingHosts(db)
-
+	if len(futureEvents) != len(futureDbEvents) {
+		t.Errorf(`Current events got the wrong number of events
+
+                          len %d:  %s
+
+                          len %d:  %s`,
+			len(futureEvents), futureEvents,
+			len(futureDbEvents), futureDbEvents)
+	}
+
+	if !AreEventsEqual(futureEvents[0], futureDbEvents[0]) {
+		t.Errorf(`Current events got the wrong events
+
+                          %s
+
+                          %s`, futureEvents, futureDbEvents)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestReadEventsForUser(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEventWithParticipant, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	anotherFakeUser := User{
+		Name:    "A User By Any Other Name",
+		Email:   "email@email.org",
+		Auth0Id: "191911919",
+	}
+
+	userId, createUserErr := CreateUser(ctx, db, anotherFakeUser)
+	anotherFakeUser.UserId = userId
+
+	if createUserErr != nil {
+		t.Error(createUserErr)
+	}
+
+	_, err = AddUserToEvent(ctx, db, fakeEventWithParticipant.EventId, userId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEventWithParticipant.Participants = append(
+		fakeEventWithParticipant.Participants,
+		anotherFakeUser,
+	)
+
+	// Test that we only get the events for the participant
+	eventsWithAnotherUser := Events{fakeEventWithParticipant}
+
+	eventsDbWithAnotherUser, err := GetPastEventsForUser(ctx, db, userId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(eventsWithAnotherUser) != len(eventsDbWithAnotherUser) {
+		t.Errorf(`Events for user got the wrong number of events
+                          user_id: %d
+
+                          len %d:  %s
+
+                          len %d:  %s`,
+			userId,
+			len(eventsWithAnotherUser), eventsWithAnotherUser,
+			len(eventsDbWithAnotherUser), eventsDbWithAnotherUser)
+	}
+
+	if !AreEventsEqual(eventsWithAnotherUser[0], eventsDbWithAnotherUser[0]) {
+		t.Errorf(`Events for user got the wrong events
+
+                          %s
+
+                          %s`, eventsWithAnotherUser, eventsDbWithAnotherUser[0])
+	}
+
+	// Test that we only get the events for a host user
+	hostUserEvents := Events{fakeEvent}
+
+	hostUserId := fakeEvent.Host.Users[0].UserId
+	hostUserDbEvents, err := GetPastEventsForUser(ctx, db, hostUserId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(hostUserEvents) != len(hostUserDbEvents) {
+		t.Errorf(`Events for user got the wrong number of events
+                          user_id: %d
+
+                          len %d:  %s
+
+                          len %d:  %s`,
+			hostUserId,
+			len(hostUserEvents), hostUserEvents,
+			len(hostUserDbEvents), hostUserDbEvents)
+	}
+
+	if !AreEventsEqual(hostUserEvents[0], hostUserDbEvents[0]) {
+		t.Errorf(`Events for user got the wrong events
+
+                          %s
+
+                          %s`, hostUserEvents, hostUserDbEvents)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestEditEvent(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent.Title = "Another Title"
+	fakeEvent.Description = "gunna be lit"
+
+	editedFakeDbEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId,
+		EventUpdate{
+			Title:       &fakeEvent.Title,
+			Description: &fakeEvent.Description,
+		})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !AreEventsEqual(editedFakeDbEvent, fakeEvent) {
+		t.Errorf("Event in DB doesn't match edited event \n  %s \n %s \n",
+			editedFakeDbEvent,
+			fakeEvent)
+	}
+
+	unchangedEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId,
+		EventUpdate{})
+	if err != nil || !AreEventsEqual(unchangedEvent, fakeEvent) ||
+		unchangedEvent.Sequence != editedFakeDbEvent.Sequence {
+		t.Errorf("Expected an empty update to change nothing: %v %v",
+			unchangedEvent, err)
+	}
+
+	patch := Patch{"description": []byte("null")}
+	update, err := patch.EventUpdate()
+	if err != nil {
+		t.Error(err)
+	}
+	clearedEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId, update)
+	if err != nil || clearedEvent.Description != "" ||
+		clearedEvent.Title != fakeEvent.Title {
+		t.Errorf("Expected only the description to be cleared: %v %v",
+			clearedEvent, err)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestEditEventDishSlots(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEventPartial := GetFakeEvent()
+	fakeEventPartial.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+		DishSlot{Name: "chairs", TargetCount: 1},
+		DishSlot{Name: "drinks", TargetCount: 1},
+	}
+	fakeEvent, err := CreateFakeEvent(ctx, db, fakeEventPartial)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var userIds []int64
+	for i := 0; i < 3; i++ {
+		userId, err := CreateUser(ctx, db, GetTestUser())
+		if err != nil {
+			t.Error(err)
+		}
+		// main, dessert, then chairs
+		_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+		if err != nil {
+			t.Error(err)
+		}
+		userIds = append(userIds, userId)
+	}
+
+	// The third user claims drinks, then the second claims chairs
+	_, err = ChangeParticipantDish(ctx, db, fakeEvent.EventId, userIds[2],
+		DishChange{AssignedDish: "drinks"})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = ChangeParticipantDish(ctx, db, fakeEvent.EventId, userIds[1],
+		DishChange{AssignedDish: "chairs"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	dishSlots := DishSlots{
+		DishSlot{Name: "chairs", TargetCount: 1},
+		DishSlot{Name: "salad", TargetCount: 2},
+	}
+	editedEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId,
+		EventUpdate{DishSlots: &dishSlots})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The claim on chairs is kept, and the main and the claim on drinks
+	// are gone, so those participants move to salad
+	expectedDishes := map[int64]string{
+		userIds[0]: "salad",
+		userIds[1]: "chairs",
+		userIds[2]: "salad",
+	}
+	for _, participant := range editedEvent.Participants {
+		if participant.AssignedDish != expectedDishes[participant.UserId] {
+			t.Errorf("Expected user %d to bring %s, got %s",
+				participant.UserId, expectedDishes[participant.UserId],
+				participant.AssignedDish)
+		}
+	}
+	for _, dishSlot := range editedEvent.DishSlots {
+		if dishSlot.Filled != dishSlot.TargetCount {
+			t.Errorf("Expected %s to be filled: %v", dishSlot.Name,
+				dishSlot)
+		}
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestOwnershipChecks(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeDbHost, err := GetHost(ctx, db, fakeEvent.Host.HostId)
+	if err != nil {
+		t.Error(err)
+	}
+	hostUser := fakeDbHost.Users[0]
+
+	otherUser := GetTestUser()
+	otherUserId, err := CreateUser(ctx, db, otherUser)
+	if err != nil {
+		t.Error(err)
+	}
+
+	isUser, err := IsAuth0User(ctx, db, otherUserId, otherUser.Auth0Id)
+	if err != nil || !isUser {
+		t.Errorf("Expected user to own their own account")
+	}
+	isUser, err = IsAuth0User(ctx, db, hostUser.UserId, otherUser.Auth0Id)
+	if err != nil || isUser {
+		t.Errorf("Expected user not to own another user's account")
+	}
+
+	isInHost, err := IsAuth0UserInHost(ctx, db, fakeEvent.Host.HostId,
+		hostUser.Auth0Id)
+	if err != nil || !isInHost {
+		t.Errorf("Expected host user to belong to host")
+	}
+	isInHost, err = IsAuth0UserInHost(ctx, db, fakeEvent.Host.HostId,
+		otherUser.Auth0Id)
+	if err != nil || isInHost {
+		t.Errorf("Expected other user not to belong to host")
+	}
+
+	isHost, err := IsAuth0UserHostOfEvent(ctx, db, fakeEvent.EventId,
+		hostUser.Auth0Id)
+	if err != nil || !isHost {
+		t.Errorf("Expected host user to host event")
+	}
+	isHost, err = IsAuth0UserHostOfEvent(ctx, db, fakeEvent.EventId,
+		otherUser.Auth0Id)
+	if err != nil || isHost {
+		t.Errorf("Expected other user not to host event")
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestSendEmail(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	aliceUser := User{
+		Name:    "Alice Rottersman",
+		Email:   "foodwithphriends@mailinator.com",
+		Auth0Id: "1919191",
+	}
+
+	userId, err := CreateUser(ctx, db, aliceUser)
+
+	aliceUser.UserId = userId
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = CreateHost(ctx, db, Host{
+		Address: "743 South Darien Str",
+		Users:   Users{aliceUser},
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	leastRecentHosts, _ := GetLeastRecentHosts(ctx, db, 1)
+
+	emailer, mailer := newTestEmailer(t)
+	ids, err := SendEmailsToLeastRecentHosts(ctx, db, emailer, 1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(mailer.Sent()) != 0 {
+		t.Errorf("Expected emails to be queued, not sent: %v", mailer.Sent())
+	}
+
+	results, err := DeliverOutboundEmails(ctx, PostgresStore{DB: db}, mailer,
+		EmailQueueConfigFromEnv(), ids)
+	if err != nil || len(results.Failed()) != 0 {
+		t.Errorf("Expected queued emails to be sent: %v %v", results, err)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 ||
+		!reflect.DeepEqual(sent[0].To, []string{aliceUser.Email}) {
+		t.Errorf("Expected one email to the host: %v", sent)
+	}
+
+	pendingHosts, err := GetPendingHosts(ctx, db)
+
 	if len(leastRecentHosts) != len(pendingHosts) ||
 		!AreHostsEqual(leastRecentHosts[0], pendingHosts[0]) {
 		t.Errorf(`least recent hosts should be the same as pending hosts:
                           %s
 
-                          %s`, leastRecentHosts, pendingHosts)
+                          %s`, leastRecentHosts, pendingHosts)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestOutboundEmailRetries(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	userId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	emailer, _ := newTestEmailer(t)
+	fakeEvent.Title = "Moved to the park"
+	_, err = UpdateEvent(ctx, db, emailer, fakeEvent.EventId,
+		EventUpdate{Title: &fakeEvent.Title})
+	if err != nil {
+		t.Error(err)
+	}
+
+	participants, _ := GetUsersForEvent(ctx, db, fakeEvent.EventId)
+	mailer := &rejectingMailer{Reject: participants[0].Email}
+	config := EmailQueueConfig{
+		MaxAttempts:   2,
+		RetryDelay:    time.Second,
+		MaxRetryDelay: time.Minute,
+		BatchSize:     10,
+		Lease:         time.Minute,
+	}
+	queue := PostgresStore{DB: db}
+
+	results, err := DeliverOutboundEmails(ctx, queue, mailer, config, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(results) != 1 || results[0].Status != EMAIL_PENDING ||
+		results[0].UserId != userId {
+		t.Errorf("Expected the update email to be retried: %v", results)
+	}
+
+	results, _ = DeliverOutboundEmails(ctx, queue, mailer, config, nil)
+	if len(results) != 0 {
+		t.Errorf("Expected the retry to wait: %v", results)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	results, _ = DeliverOutboundEmails(ctx, queue, mailer, config, nil)
+	if len(results) != 1 || results[0].Status != EMAIL_FAILED {
+		t.Errorf("Expected the email to fail after 2 attempts: %v", results)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestCalendarFeed(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	userId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	calendarToken, err := GetCalendarToken(ctx, db, userId)
+	if err != nil || len(calendarToken) != 64 {
+		t.Errorf("Expected a 256 bit calendar token, got %q: %v",
+			calendarToken, err)
+	}
+
+	tokenUserId, err := GetUserIdByCalendarToken(ctx, db, calendarToken)
+	if err != nil || tokenUserId != userId {
+		t.Errorf("Expected token to belong to user %d, got %d: %v",
+			userId, tokenUserId, err)
+	}
+
+	_, err = GetUserIdByCalendarToken(ctx, db, "not-a-token")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a bad token, got %v", err)
+	}
+
+	events, err := GetUpcomingEventsForUser(ctx, db, userId)
+	if err != nil || len(events) != 1 ||
+		events[0].EventId != fakeEvent.EventId {
+		t.Fatalf("Expected the RSVPed event in the feed: %v %v",
+			events, err)
+	}
+
+	fakeEvent.Title = "Moved to the park"
+	updatedEvent, err := UpdateEvent(ctx, db, nil, fakeEvent.EventId,
+		EventUpdate{Title: &fakeEvent.Title})
+	if err != nil {
+		t.Error(err)
+	}
+	if updatedEvent.Sequence != events[0].Sequence+1 {
+		t.Errorf("Expected sequence to be bumped: %d -> %d",
+			events[0].Sequence, updatedEvent.Sequence)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestCancelEvent(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeEvent, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Error(err)
+	}
+
+	userId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, userId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	emailer, mailer := newTestEmailer(t)
+	cancelledEvent, err := CancelEvent(ctx, db, emailer, fakeEvent.EventId)
+	if err != nil {
+		t.Error(err)
+	}
+	if cancelledEvent.Status != EVENT_CANCELLED ||
+		len(cancelledEvent.Participants) != 1 {
+		t.Errorf("Expected a cancelled event that kept its participants: %v",
+			cancelledEvent)
+	}
+
+	_, err = CancelEvent(ctx, db, emailer, fakeEvent.EventId)
+	if err != ErrEventCancelled {
+		t.Errorf("Expected ErrEventCancelled cancelling twice, got %v", err)
+	}
+
+	otherUserId, _ := CreateUser(ctx, db, GetTestUser())
+	_, err = AddUserToEvent(ctx, db, fakeEvent.EventId, otherUserId)
+	if err != ErrEventCancelled {
+		t.Errorf("Expected ErrEventCancelled joining, got %v", err)
+	}
+
+	pendingHosts, err := GetPendingHosts(ctx, db)
+	if err != nil || len(pendingHosts) != 1 ||
+		pendingHosts[0].HostId != fakeEvent.Host.HostId {
+		t.Errorf("Expected host's invitation to be pending again: %v",
+			pendingHosts)
+	}
+
+	currentEvents, _ := GetCurrentEvents(ctx, db)
+	for _, event := range currentEvents {
+		if event.EventId == fakeEvent.EventId {
+			t.Error("Cancelled event shouldn't be a current event")
+		}
+	}
+
+	results, err := DeliverOutboundEmails(ctx, PostgresStore{DB: db}, mailer,
+		EmailQueueConfigFromEnv(), nil)
+	if err != nil || len(results) != 1 || results[0].UserId != userId {
+		t.Errorf("Expected a cancellation email to the participant: %v %v",
+			results, err)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestEventTimesAndTimezone(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+	dbHost, err := GetHost(ctx, db, fakeHost.HostId)
+	if err != nil || dbHost.Timezone != "America/New_York" {
+		t.Errorf("Expected the host's timezone from their state: %v %v",
+			dbHost.Timezone, err)
+	}
+
+	fakeEvent := GetFakeEvent()
+	fakeEvent.Host = fakeHost
+	fakeEvent.DurationMinutes = 120
+	eventId, err := CreateEvent(ctx, db, fakeEvent)
+	if err != nil {
+		t.Error(err)
+	}
+
+	dbEvent, err := GetEvent(ctx, db, eventId)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbEvent.Timezone != "America/New_York" ||
+		dbEvent.HappeningAt.Location().String() != "America/New_York" {
+		t.Errorf("Expected the event in its host's timezone: %v",
+			dbEvent.HappeningAt)
+	}
+	if dbEvent.EndsAt == nil || dbEvent.DurationMinutes != 120 {
+		t.Errorf("Expected a two hour event, got %v", dbEvent.EndsAt)
+	}
+	if !AreEventsEqual(dbEvent, fakeEvent) {
+		t.Errorf("Expected %v to round trip, got %v", fakeEvent, dbEvent)
+	}
+
+	// Moving the start keeps the duration
+	happeningAt := fakeEvent.HappeningAt.Add(time.Hour)
+	timezone := "America/Los_Angeles"
+	updatedEvent, err := UpdateEvent(ctx, db, nil, eventId, EventUpdate{
+		HappeningAt: &happeningAt,
+		Timezone:    &timezone,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if updatedEvent.DurationMinutes != 120 ||
+		updatedEvent.HappeningAt.Location().String() != "America/Los_Angeles" {
+		t.Errorf("Expected a moved two hour event in Pacific time: %v %v",
+			updatedEvent.HappeningAt, updatedEvent.EndsAt)
+	}
+
+	durationMinutes := int64(45)
+	updatedEvent, err = UpdateEvent(ctx, db, nil, eventId, EventUpdate{
+		DurationMinutes: &durationMinutes,
+	})
+	if err != nil || updatedEvent.DurationMinutes != 45 {
+		t.Errorf("Expected a 45 minute event: %v %v", updatedEvent.EndsAt,
+			err)
+	}
+
+	patch := Patch{"endsAt": []byte("null"), "timezone": []byte("null")}
+	update, err := patch.EventUpdate()
+	if err != nil {
+		t.Error(err)
+	}
+	updatedEvent, err = UpdateEvent(ctx, db, nil, eventId, update)
+	if err != nil || updatedEvent.EndsAt != nil ||
+		updatedEvent.Timezone != "America/New_York" {
+		t.Errorf("Expected no end and the host's timezone again: %v %v %v",
+			updatedEvent.EndsAt, updatedEvent.Timezone, err)
 	}
 
 	DeleteEverything(db)
@@ -695,33 +1696,34 @@ func TestSendEmail(t *testing.T) {
 }
 
 func TestExpireEventInvitations(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	pendingHost, err := CreateFakeHost(db)
+	pendingHost, err := CreateFakeHost(ctx, db)
 	if err != nil {
 		t.Error(err)
 	}
 
-	eventCreatedHost, err := CreateFakeHost(db)
+	eventCreatedHost, err := CreateFakeHost(ctx, db)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = AddHostInvitations(db, Hosts{pendingHost,
+	err = AddHostInvitations(ctx, db, Hosts{pendingHost,
 		eventCreatedHost})
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = UpdateHostInvitation(db, eventCreatedHost.HostId, EVENT_CREATED)
+	err = UpdateHostInvitation(ctx, db, eventCreatedHost.HostId, EVENT_CREATED)
 	if err != nil {
 		t.Error(err)
 	}
 
-	expiredHosts, err := ExpireEventInvitations(db)
+	expiredHosts, err := ExpireEventInvitations(ctx, db)
 	if err != nil {
 		t.Error(err)
 	}
@@ -737,26 +1739,143 @@ func TestExpireEventInvitations(t *testing.T) {
 	db.Close()
 }
 
+func TestInvitationHistory(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	fakeHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = AddHostInvitations(ctx, db, Hosts{fakeHost})
+	if err != nil {
+		t.Error(err)
+	}
+
+	invitations, err := GetInvitations(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(invitations) != 1 ||
+		invitations[0].Status != PENDING ||
+		!AreHostsEqual(invitations[0].Host, fakeHost) {
+		t.Errorf("Expected one pending invitation for host: %v",
+			invitations)
+	}
+
+	invitation, err := SetInvitationStatus(ctx, db,
+		invitations[0].InvitationId, PASS)
+	if err != nil {
+		t.Error(err)
+	}
+	if invitation.Status != PASS {
+		t.Errorf("Expected invitation to be passed: %v", invitation)
+	}
+
+	canCreate, err := CanHostCreateEvent(ctx, db, fakeHost.HostId)
+	if err != nil || canCreate {
+		t.Errorf("Passed host shouldn't be able to create event")
+	}
+
+	_, err = SetInvitationStatus(ctx, db, invitation.InvitationId+1, PASS)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func TestStalePendingInvitations(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect()
+	if err != nil {
+		t.Error(err)
+	}
+
+	day := 24 * time.Hour
+
+	isRoundDue, err := IsInvitationRoundDue(ctx, db, 14*day)
+	if err != nil || !isRoundDue {
+		t.Errorf("Expected a round to be due with no invitations")
+	}
+
+	staleHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+	freshHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = AddHostInvitations(ctx, db, Hosts{staleHost, freshHost})
+	if err != nil {
+		t.Error(err)
+	}
+	db.Exec(`UPDATE event_creation_invites
+                 SET sent_at = current_timestamp - interval '4 days'
+                 WHERE host_id = $1`, staleHost.HostId)
+
+	isRoundDue, err = IsInvitationRoundDue(ctx, db, 14*day)
+	if err != nil || isRoundDue {
+		t.Errorf("Expected no round to be due right after invitations")
+	}
+
+	stale, err := GetStalePendingInvitations(ctx, db, 3*day, true)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(stale) != 1 || stale[0].Host.HostId != staleHost.HostId {
+		t.Errorf("Expected only the stale host's invitation: %v", stale)
+	}
+
+	err = MarkInvitationReminded(ctx, db, stale[0].InvitationId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	stale, err = GetStalePendingInvitations(ctx, db, 3*day, true)
+	if err != nil || len(stale) != 0 {
+		t.Errorf("Expected reminded invitation to be left out: %v", stale)
+	}
+
+	stale, err = GetStalePendingInvitations(ctx, db, 3*day, false)
+	if err != nil || len(stale) != 1 {
+		t.Errorf("Expected reminded invitation to still be stale: %v",
+			stale)
+	}
+
+	DeleteEverything(db)
+	db.Close()
+}
+
 func TestCheckUserCanCreateEvent(t *testing.T) {
+	ctx := context.Background()
 	db, err := Connect()
 	if err != nil {
 		t.Error(err)
 	}
 
-	pendingHost, err := CreateFakeHost(db)
+	pendingHost, err := CreateFakeHost(ctx, db)
 	if err != nil {
 		t.Error(err)
 	}
 
-	uninvitedHost, err := CreateFakeHost(db)
+	uninvitedHost, err := CreateFakeHost(ctx, db)
 
-	err = AddHostInvitations(db, Hosts{pendingHost})
+	err = AddHostInvitations(ctx, db, Hosts{pendingHost})
 	if err != nil {
 		t.Error(err)
 	}
 
-	canPendingHostCreateEvent, err := CanHostCreateEvent(db, pendingHost.HostId)
-	canUninvitedHostCreateEvent, err := CanHostCreateEvent(db, uninvitedHost.HostId)
+	canPendingHostCreateEvent, err := CanHostCreateEvent(ctx, db, pendingHost.HostId)
+	canUninvitedHostCreateEvent, err := CanHostCreateEvent(ctx, db, uninvitedHost.HostId)
 
 	if canPendingHostCreateEvent == false {
 		t.Errorf("Pending host should be able to create event")
@@ -795,3 +1914,348 @@ func TestCreateEventCreationLink(t *testing.T) {
 		    - use 4b to set status to reject, send new email
 	*/
 }
+
+// Query counts and failures
+
+// testDriver wraps the Postgres driver to count the queries run through
+// it, and to make a query fail to test what happens to the ones before
+// it. pq runs every query and exec through Query and Exec.
+type testDriver struct {
+	queries int64
+	failOn  atomic.Value
+}
+
+type testConn struct {
+	driver.Conn
+	driver *testDriver
+}
+
+var errInjected = errors.New("Injected failure")
+
+func (d *testDriver) Open(name string) (driver.Conn, error) {
+	conn, err := pq.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return testConn{conn, d}, nil
+}
+
+// FailOn makes queries containing fragment fail, until it's called
+// again with "".
+func (d *testDriver) FailOn(fragment string) {
+	d.failOn.Store(fragment)
+}
+
+func (c testConn) run(query string) error {
+	atomic.AddInt64(&c.driver.queries, 1)
+	fragment, _ := c.driver.failOn.Load().(string)
+	if len(fragment) > 0 && strings.Contains(query, fragment) {
+		return errInjected
+	}
+	return nil
+}
+
+func (c testConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if err := c.run(query); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.Queryer).Query(query, args)
+}
+
+func (c testConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if err := c.run(query); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.Execer).Exec(query, args)
+}
+
+var testPostgres = &testDriver{}
+
+func init() {
+	sql.Register("postgres-test", testPostgres)
+}
+
+// openTestDB connects through testPostgres.
+func openTestDB(tb testing.TB) *sql.DB {
+	db, err := sql.Open("postgres-test", ConnectionString())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// countQueries runs list and returns how many queries it took.
+func countQueries(b *testing.B, list func() (int, error), expected int) int64 {
+	atomic.StoreInt64(&testPostgres.queries, 0)
+	listed, err := list()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if listed != expected {
+		b.Fatalf("Expected %d results, got %d", expected, listed)
+	}
+	return atomic.LoadInt64(&testPostgres.queries)
+}
+
+// benchmarkListQueryCount times list as more events are added, and
+// fails if the number of queries it takes changes.
+func benchmarkListQueryCount(b *testing.B, list func(db *sql.DB) (int, error)) {
+	ctx := context.Background()
+	db := openTestDB(b)
+	defer db.Close()
+	DeleteEverything(db)
+	defer DeleteEverything(db)
+
+	var baseline int64
+	numEvents := 0
+	for _, size := range []int{1, 10, 50} {
+		for ; numEvents < size; numEvents++ {
+			if _, err := CreateFakeEvent(ctx, db, GetFakeEvent()); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		listAll := func() (int, error) { return list(db) }
+		queries := countQueries(b, listAll, size)
+		if baseline == 0 {
+			baseline = queries
+		} else if queries != baseline {
+			b.Fatalf("Listing %d events took %d queries, but 1 took %d",
+				size, queries, baseline)
+		}
+
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if countQueries(b, listAll, size) != baseline {
+					b.Fatal("Query count changed")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkListEventsQueryCount(b *testing.B) {
+	ctx := context.Background()
+	benchmarkListQueryCount(b, func(db *sql.DB) (int, error) {
+		events, err := GetCurrentEvents(ctx, db)
+		return len(events), err
+	})
+}
+
+func BenchmarkListHostsQueryCount(b *testing.B) {
+	ctx := context.Background()
+	benchmarkListQueryCount(b, func(db *sql.DB) (int, error) {
+		hosts, err := GetLeastRecentHosts(ctx, db, 1000)
+		return len(hosts), err
+	})
+}
+
+func TestCreateUserIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+
+	user := GetTestUser()
+	testPostgres.FailOn("INSERT INTO user_dietary_restrictions")
+	if _, err := CreateUser(ctx, db, user); err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	testPostgres.FailOn("")
+
+	if _, err := GetUserByAuth0Id(ctx, db, user.Auth0Id); err != sql.ErrNoRows {
+		t.Errorf("Expected no user without their restrictions, got %v", err)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestUpdateUserIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+
+	user := GetTestUser()
+	if _, err := CreateUser(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "Someone Else"
+	restrictions := []string{"vegan"}
+	testPostgres.FailOn("INSERT INTO user_dietary_restrictions")
+	_, err := UpdateUser(ctx, db, user.Auth0Id, UserUpdate{
+		Name:                &name,
+		DietaryRestrictions: &restrictions,
+	})
+	if err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	testPostgres.FailOn("")
+
+	storedUser, err := GetUserByAuth0Id(ctx, db, user.Auth0Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AreUsersEqual(storedUser, user) {
+		t.Errorf("Expected the user to be unchanged: %v %v", storedUser, user)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestCreateHostIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+
+	userId, err := CreateUser(ctx, db, GetTestUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := GetTestHost()
+	host.Users = Users{User{UserId: userId}}
+
+	testPostgres.FailOn("INSERT INTO host_users")
+	if _, err := CreateHost(ctx, db, host); err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	testPostgres.FailOn("")
+
+	var hosts int64
+	if err := db.QueryRow(`SELECT COUNT(*) FROM hosts`).Scan(&hosts); err != nil {
+		t.Fatal(err)
+	}
+	if hosts != 0 {
+		t.Errorf("Expected no host without users, found %d hosts", hosts)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestCreateInvitedEventIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+
+	fakeHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddHostInvitations(ctx, db, Hosts{fakeHost}); err != nil {
+		t.Fatal(err)
+	}
+	event := GetFakeEvent()
+	event.Host = fakeHost
+
+	for _, step := range []string{
+		"INSERT INTO event_dish_slots",
+		"UPDATE event_creation_invites",
+	} {
+		testPostgres.FailOn(step)
+		if _, err := CreateInvitedEvent(ctx, db, event); err != errInjected {
+			t.Errorf("Expected %s to fail, got %v", step, err)
+		}
+		testPostgres.FailOn("")
+
+		canCreate, err := CanHostCreateEvent(ctx, db, fakeHost.HostId)
+		if err != nil || !canCreate {
+			t.Errorf("Expected the invitation to still be pending after %s failed",
+				step)
+		}
+		var events int64
+		err = db.QueryRow(`SELECT COUNT(*) FROM events WHERE host_id = $1`,
+			fakeHost.HostId).Scan(&events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if events != 0 {
+			t.Errorf("Expected no event after %s failed, found %d", step,
+				events)
+		}
+	}
+
+	if _, err := CreateInvitedEvent(ctx, db, event); err != nil {
+		t.Fatal(err)
+	}
+	canCreate, err := CanHostCreateEvent(ctx, db, fakeHost.HostId)
+	if err != nil || canCreate {
+		t.Error("Expected the invitation to be used up")
+	}
+	if _, err := CreateInvitedEvent(ctx, db, event); err != ErrNotHostsTurn {
+		t.Errorf("Expected ErrNotHostsTurn for a second event, got %v", err)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestPassHostTurnIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+	emailer, _ := newTestEmailer(t)
+
+	passingHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddHostInvitations(ctx, db, Hosts{passingHost}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateFakeHost(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	testPostgres.FailOn("INSERT INTO outbound_emails")
+	if _, err := PassHostTurn(ctx, db, emailer, passingHost.HostId); err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	testPostgres.FailOn("")
+
+	canCreate, err := CanHostCreateEvent(ctx, db, passingHost.HostId)
+	if err != nil || !canCreate {
+		t.Error("Expected the pass to be rolled back with the emails")
+	}
+
+	ids, err := PassHostTurn(ctx, db, emailer, passingHost.HostId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) == 0 {
+		t.Error("Expected the pass to queue an invitation")
+	}
+
+	uninvitedHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PassHostTurn(ctx, db, emailer, uninvitedHost.HostId)
+	if err != ErrNotHostsTurn {
+		t.Errorf("Expected ErrNotHostsTurn for an uninvited host, got %v", err)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestQueriesStopAtDeadline(t *testing.T) {
+	db, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	if _, err := GetCurrentEvents(ctx, db); err != context.DeadlineExceeded {
+		t.Errorf("Expected a query past its deadline to stop, got %v", err)
+	}
+	_, err = CreateHost(ctx, db, GetTestHost())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected a transaction past its deadline to stop, got %v",
+			err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The canonical dietary restrictions offered to users. Users can also
+// enter their own; anything matching one of these regardless of case,
+// spacing, or hyphens is stored with the canonical spelling.
+var DIETARY_RESTRICTION_VOCABULARY = []string{
+	"vegan",
+	"vegetarian",
+	"pescatarian",
+	"gluten-free",
+	"dairy-free",
+	"nut allergy",
+	"shellfish allergy",
+	"kosher",
+	"halal",
+}
+
+var nutIngredients = []string{"nut", "peanut", "almond", "cashew", "pecan",
+	"walnut", "pistachio", "hazelnut"}
+
+// Ingredients to look for in what participants are bringing, for
+// restrictions that don't name the ingredient themselves. Restrictions
+// not listed here are matched by their own words, minus filler like
+// "allergy" or "free".
+var RESTRICTED_INGREDIENTS = map[string][]string{
+	"vegan": []string{"meat", "beef", "pork", "chicken", "turkey",
+		"bacon", "ham", "sausage", "fish", "shrimp", "egg", "eggs",
+		"cheese", "milk", "butter", "cream", "yogurt", "honey"},
+	"vegetarian": []string{"meat", "beef", "pork", "chicken", "turkey",
+		"bacon", "ham", "sausage", "fish", "shrimp"},
+	"pescatarian": []string{"meat", "beef", "pork", "chicken", "turkey",
+		"bacon", "ham", "sausage"},
+	"gluten-free": []string{"gluten", "wheat", "flour", "bread", "pasta",
+		"noodle", "cake", "cookie", "pie", "barley", "rye", "couscous"},
+	"dairy-free": []string{"dairy", "milk", "cheese", "butter", "cream",
+		"yogurt"},
+	"nut allergy": nutIngredients,
+	"nuts":        nutIngredients,
+	"shellfish allergy": []string{"shellfish", "shrimp", "crab", "lobster",
+		"clam", "mussel", "oyster", "scallop"},
+}
+
+var restrictionFillerWords = map[string]bool{
+	"allergy": true, "allergic": true, "free": true, "no": true,
+	"intolerance": true, "intolerant": true,
+}
+
+type DietaryRestrictionSummary struct {
+	Restriction string `json:"restriction"`
+	Users       Users  `json:"users"`
+}
+
+type DietaryConflict struct {
+	Participant     User   `json:"participant"`
+	Restriction     string `json:"restriction"`
+	Ingredient      string `json:"ingredient"`
+	RestrictedUsers Users  `json:"restrictedUsers"`
+}
+
+type DietaryRestrictionReport struct {
+	EventId      int64                       `json:"eventId"`
+	Restrictions []DietaryRestrictionSummary `json:"restrictions"`
+	Conflicts    []DietaryConflict           `json:"conflicts"`
+}
+
+func dietaryRestrictionKey(restriction string) string {
+	return strings.Join(strings.Fields(
+		strings.Replace(strings.ToLower(restriction), "-", " ", -1)), " ")
+}
+
+// CanonicalizeDietaryRestrictions trims and de-duplicates the
+// restrictions, replacing any that match the vocabulary with their
+// canonical spelling. Custom restrictions are kept as written.
+func CanonicalizeDietaryRestrictions(restrictions []string) []string {
+	canonical := make(map[string]string)
+	for _, restriction := range DIETARY_RESTRICTION_VOCABULARY {
+		canonical[dietaryRestrictionKey(restriction)] = restriction
+	}
+
+	seen := make(map[string]bool)
+	canonicalized := []string{}
+	for _, restriction := range restrictions {
+		key := dietaryRestrictionKey(restriction)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if canonicalRestriction, ok := canonical[key]; ok {
+			canonicalized = append(canonicalized, canonicalRestriction)
+		} else {
+			canonicalized = append(canonicalized,
+				strings.Join(strings.Fields(restriction), " "))
+		}
+	}
+	return canonicalized
+}
+
+func normalizeRestriction(restriction string) string {
+	return strings.ToLower(strings.TrimSpace(restriction))
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+func ingredientsForRestriction(restriction string) []string {
+	if ingredients, ok := RESTRICTED_INGREDIENTS[restriction]; ok {
+		return ingredients
+	}
+
+	var ingredients []string
+	for _, word := range splitWords(restriction) {
+		if !restrictionFillerWords[word] {
+			ingredients = append(ingredients, word)
+		}
+	}
+	return ingredients
+}
+
+// mentionsIngredient reports whether any word of text is the
+// ingredient, allowing for simple plurals either way round.
+func mentionsIngredient(text string, ingredient string) bool {
+	for _, word := range splitWords(text) {
+		if word == ingredient ||
+			word == ingredient+"s" ||
+			word == ingredient+"es" ||
+			ingredient == word+"s" ||
+			ingredient == word+"es" {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildDietaryRestrictionReport groups the event's participants by
+// dietary restriction and flags participants whose Bringing text
+// mentions an ingredient someone else at the event can't have.
+func BuildDietaryRestrictionReport(event Event) DietaryRestrictionReport {
+	usersByRestriction := make(map[string]Users)
+	for _, participant := range event.Participants {
+		for _, restriction := range participant.DietaryRestrictions {
+			restriction = normalizeRestriction(restriction)
+			if restriction == "" {
+				continue
+			}
+			usersByRestriction[restriction] = append(
+				usersByRestriction[restriction], participant)
+		}
+	}
+
+	var restrictions []string
+	for restriction := range usersByRestriction {
+		restrictions = append(restrictions, restriction)
+	}
+	sort.Strings(restrictions)
+
+	report := DietaryRestrictionReport{
+		EventId:      event.EventId,
+		Restrictions: []DietaryRestrictionSummary{},
+		Conflicts:    []DietaryConflict{},
+	}
+	for _, restriction := range restrictions {
+		report.Restrictions = append(report.Restrictions,
+			DietaryRestrictionSummary{
+				Restriction: restriction,
+				Users:       usersByRestriction[restriction],
+			})
+	}
+
+	for _, participant := range event.Participants {
+		if participant.Bringing == "" {
+			continue
+		}
+		for _, restriction := range restrictions {
+			for _, ingredient := range ingredientsForRestriction(restriction) {
+				if mentionsIngredient(participant.Bringing, ingredient) {
+					report.Conflicts = append(report.Conflicts,
+						DietaryConflict{
+							Participant:     participant,
+							Restriction:     restriction,
+							Ingredient:      ingredient,
+							RestrictedUsers: usersByRestriction[restriction],
+						})
+					break
+				}
+			}
+		}
+	}
+
+	return report
+}
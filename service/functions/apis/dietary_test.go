@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildDietaryRestrictionReport(t *testing.T) {
+	event := Event{
+		EventId: 1,
+		Participants: Users{
+			User{
+				UserId:              1,
+				DietaryRestrictions: []string{"Vegan", "nuts"},
+			},
+			User{
+				UserId:              2,
+				DietaryRestrictions: []string{"vegan"},
+				Bringing:            "Lentil soup",
+			},
+			User{
+				UserId:              3,
+				DietaryRestrictions: []string{""},
+				Bringing:            "Peanut butter cookies",
+			},
+			User{
+				UserId:   4,
+				Bringing: "Mac and cheese",
+			},
+		},
+	}
+
+	report := BuildDietaryRestrictionReport(event)
+
+	if len(report.Restrictions) != 2 ||
+		report.Restrictions[0].Restriction != "nuts" ||
+		report.Restrictions[1].Restriction != "vegan" ||
+		len(report.Restrictions[1].Users) != 2 {
+		t.Errorf("Wrong restrictions in report: %v", report.Restrictions)
+	}
+
+	if len(report.Conflicts) != 3 {
+		t.Fatalf("Expected three conflicts, got %v", report.Conflicts)
+	}
+
+	if report.Conflicts[0].Participant.UserId != 3 ||
+		report.Conflicts[0].Restriction != "nuts" ||
+		report.Conflicts[0].Ingredient != "peanut" {
+		t.Errorf("Expected peanut butter cookies to conflict with nuts: %v",
+			report.Conflicts[0])
+	}
+
+	if report.Conflicts[1].Participant.UserId != 3 ||
+		report.Conflicts[1].Restriction != "vegan" ||
+		report.Conflicts[1].Ingredient != "butter" {
+		t.Errorf("Expected peanut butter cookies to conflict with vegan: %v",
+			report.Conflicts[1])
+	}
+
+	if report.Conflicts[2].Participant.UserId != 4 ||
+		report.Conflicts[2].Restriction != "vegan" ||
+		report.Conflicts[2].Ingredient != "cheese" {
+		t.Errorf("Expected mac and cheese to conflict with vegan: %v",
+			report.Conflicts[2])
+	}
+}
+
+func TestCanonicalizeDietaryRestrictions(t *testing.T) {
+	restrictions := CanonicalizeDietaryRestrictions([]string{
+		"Gluten Free",
+		"  strawberries ",
+		"",
+		"gluten-free",
+		"NUT  ALLERGY",
+		"no cilantro + no onions",
+	})
+
+	expected := []string{
+		"gluten-free",
+		"strawberries",
+		"nut allergy",
+		"no cilantro + no onions",
+	}
+
+	if !reflect.DeepEqual(restrictions, expected) {
+		t.Errorf("Wrong canonical restrictions: %v", restrictions)
+	}
+}
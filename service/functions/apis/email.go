@@ -1,98 +1,271 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"net/smtp"
+	"net/mail"
 	"os"
-	"strings"
 )
 
-func SendEmailsToLeastRecentHosts(db *sql.DB, numHosts int) error {
-	leastRecentHosts, err := GetLeastRecentHosts(db, numHosts)
+// Emailer renders notification templates into outbound emails and
+// sends them with a Mailer.
+type Emailer struct {
+	Mailer    Mailer
+	Templates *EmailTemplates
+	From      string
+}
+
+func EmailerFromEnv() (*Emailer, error) {
+	mailer, err := MailerFromEnv()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if len(leastRecentHosts) <= 0 && numHosts > 0 {
-		return errors.New("Didn't find any hosts that haven't received emails yet.")
+	templates, err := EmailTemplatesFromEnv()
+	if err != nil {
+		return nil, err
 	}
+	return &Emailer{
+		Mailer:    mailer,
+		Templates: templates,
+		From:      os.Getenv("FWF_EMAIL"),
+	}, nil
+}
 
-	fwfEmail := os.Getenv("FWF_EMAIL")
-	fwfEmailPassword := os.Getenv("FWF_EMAIL_PASSWORD")
-
-	auth := smtp.PlainAuth("", fwfEmail, fwfEmailPassword, "smtp.gmail.com")
+// render renders the named template for each recipient into their own
+// multipart/alternative message, so recipients don't see each other's
+// addresses and one bad address doesn't stop the rest.
+func (emailer *Emailer) render(name string, data EmailData, recipients Users, attachments ...EmailAttachment) (OutboundEmails, error) {
+	from := mail.Address{Address: emailer.From}
 
-	var recipients []string
-	for _, host := range leastRecentHosts {
-		for _, user := range host.Users {
-			recipients = append(recipients, user.Email)
+	emails := OutboundEmails{}
+	for _, user := range recipients {
+		data.Recipient = user
+		rendered, err := emailer.Templates.Render(name, data)
+		if err != nil {
+			return OutboundEmails{}, err
 		}
+		rendered.Attachments = attachments
+
+		to := mail.Address{Name: user.Name, Address: user.Email}
+		emails = append(emails, OutboundEmail{
+			UserId:    user.UserId,
+			Template:  name,
+			Sender:    emailer.From,
+			Recipient: user.Email,
+			Message:   BuildMIMEMessage(from, []mail.Address{to}, rendered),
+		})
+	}
+	return emails, nil
+}
+
+// enqueue renders the named template for each recipient and queues the
+// emails in tx. It returns the queued emails' ids.
+func (emailer *Emailer) enqueue(ctx context.Context, tx Execer, name string, data EmailData, recipients Users, attachments ...EmailAttachment) ([]int64, error) {
+	emails, err := emailer.render(name, data, recipients, attachments...)
+	if err != nil {
+		return []int64{}, err
+	}
+	return EnqueueOutboundEmails(ctx, tx, emails)
+}
+
+// yourTurnEmails renders the "your turn" emails to the hosts' users.
+func yourTurnEmails(emailer *Emailer, hosts Hosts) (OutboundEmails, error) {
+	var recipients Users
+	for _, host := range hosts {
+		recipients = append(recipients, host.Users...)
 	}
 
 	if len(recipients) <= 0 {
-		return errors.New(fmt.Sprintf("Failed to find any recipients emails for least recent hosts, host.Users is %s", leastRecentHosts))
+		return OutboundEmails{}, errors.New(fmt.Sprintf("Failed to find any recipients emails for least recent hosts, host.Users is %v", hosts))
 	}
 
-	recipientsString := strings.Join(recipients, ",")
+	return emailer.render(EMAIL_YOUR_TURN, EmailData{}, recipients)
+}
 
-	msg := []byte("From: " + fwfEmail + "\r\n" +
-		"To: " + recipientsString + "\r\n" +
-		"Subject: Your turn to host! \r\n" +
-		"\r\n" +
-		"Please visit https://d6ye2sqzk9ylp.cloudfront.net/#create-event " +
-		"to create your event. Only one of your house need create one. " +
-		"Make sure to rsvp to the event yourself when you're done. " +
-		"Thanks <3 \r\n")
-	err = smtp.SendMail("smtp.gmail.com:587", auth, fwfEmail, recipients, msg)
+// inviteHosts marks hosts as pending with addInvitations, and queues
+// their "your turn" emails in tx.
+func inviteHosts(ctx context.Context, tx *sql.Tx, emailer *Emailer, hosts Hosts, addInvitations func(context.Context, Execer, Hosts) error) ([]int64, error) {
+	emails, err := yourTurnEmails(emailer, hosts)
+	if err != nil {
+		return []int64{}, err
+	}
 
+	err = addInvitations(ctx, tx, hosts)
 	if err != nil {
-		return err
+		return []int64{}, err
 	}
 
-	err = AddHostInvitations(db, leastRecentHosts)
+	return EnqueueOutboundEmails(ctx, tx, emails)
+}
+
+var ErrNoHostsToInvite = errors.New("Didn't find any hosts that haven't received emails yet.")
+
+// SendEmailsToLeastRecentHosts invites the numHosts least recent hosts
+// to create an event. It returns the ids of the queued emails.
+func SendEmailsToLeastRecentHosts(ctx context.Context, db *sql.DB, emailer *Emailer, numHosts int) ([]int64, error) {
+	leastRecentHosts, err := GetLeastRecentHosts(ctx, db, numHosts)
+	if err != nil {
+		return []int64{}, err
+	}
+	if len(leastRecentHosts) <= 0 {
+		if numHosts > 0 {
+			return []int64{}, ErrNoHostsToInvite
+		}
+		return []int64{}, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return []int64{}, err
+	}
+
+	ids, err := inviteHosts(ctx, tx, emailer, leastRecentHosts,
+		AddHostInvitations)
+	if err != nil {
+		tx.Rollback()
+		return []int64{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return []int64{}, err
+	}
+	return ids, nil
+}
+
+// PassHostTurn passes the host's turn along to the next least recent
+// host. It returns the ids of the queued emails. The pass and the
+// invitation it sends are made together, and the pass is kept even if
+// there's no one left to invite, which returns ErrNoHostsToInvite. It
+// returns ErrNotHostsTurn if the host's invitation isn't pending.
+func PassHostTurn(ctx context.Context, db *sql.DB, emailer *Emailer, hostId int64) ([]int64, error) {
+	leastRecentHosts, err := GetReplacementHosts(ctx, db, 1)
 	if err != nil {
+		return []int64{}, err
+	}
+
+	ids := []int64{}
+	err = WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		err := lockPendingInvitation(ctx, tx, hostId)
+		if err != nil {
+			return err
+		}
+
+		err = UpdateHostInvitation(ctx, tx, hostId, PASS)
+		if err != nil {
+			return err
+		}
+
+		if len(leastRecentHosts) > 0 {
+			ids, err = inviteHosts(ctx, tx, emailer, leastRecentHosts,
+				AddReplacementInvitations)
+		}
 		return err
+	})
+	if err != nil {
+		return []int64{}, err
 	}
 
-	return nil
+	if len(leastRecentHosts) <= 0 {
+		return ids, ErrNoHostsToInvite
+	}
+	return ids, nil
 }
 
-func EmailEventUpdates(updatedEvent Event) error {
-	fwfEmail := os.Getenv("FWF_EMAIL")
-	fwfEmailPassword := os.Getenv("FWF_EMAIL_PASSWORD")
+// RemindPendingHost queues a reminder to a host that hasn't acted on
+// their invitation yet and marks the invitation reminded.
+func RemindPendingHost(ctx context.Context, db *sql.DB, emailer *Emailer, invitation Invitation) ([]int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return []int64{}, err
+	}
 
-	auth := smtp.PlainAuth("", fwfEmail, fwfEmailPassword, "smtp.gmail.com")
+	ids, err := emailer.enqueue(ctx, tx, EMAIL_HOST_REMINDER,
+		EmailData{Host: invitation.Host}, invitation.Host.Users)
+	if err == nil {
+		err = MarkInvitationReminded(ctx, tx, invitation.InvitationId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return []int64{}, err
+	}
 
-	var recipients []string
-	for _, user := range updatedEvent.Participants {
-		recipients = append(recipients, user.Email)
+	if err = tx.Commit(); err != nil {
+		return []int64{}, err
 	}
+	return ids, nil
+}
 
-	// Don't do anything if there are no
-	// participants to email
-	if len(recipients) <= 0 {
-		return nil
+// CalendarAttachment attaches the events as an .ics file, so the
+// email can add or update them in the recipient's calendar.
+func (emailer *Emailer) CalendarAttachment(events Events, method string) EmailAttachment {
+	return EmailAttachment{
+		Filename: "invite.ics",
+		ContentType: "text/calendar; charset=\"utf-8\"; method=" +
+			method,
+		Content: BuildCalendar(events, method, emailer.From),
 	}
+}
 
-	recipientsString := strings.Join(recipients, ",")
+// eventUpdateEmails, participantLeftEmails, waitlistPromotedEmails and
+// eventCancelledEmails render the notifications the Enqueue functions
+// queue, for MemoryStore to queue its own way.
+func eventUpdateEmails(emailer *Emailer, updatedEvent Event) (OutboundEmails, error) {
+	return emailer.render(EMAIL_EVENT_UPDATE,
+		EmailData{Event: updatedEvent, Host: updatedEvent.Host},
+		updatedEvent.Participants,
+		emailer.CalendarAttachment(Events{updatedEvent}, CALENDAR_REQUEST))
+}
 
-	msg := []byte("From: " + fwfEmail + "\r\n" +
-		"To: " + recipientsString + "\r\n" +
-		"Subject: Your Potluck's Got An Update \r\n" +
-		"\r\n" +
-		"Hello. \n You're receiving this email because " +
-		"you RSVPed to a potluck with the VFA potluck app." +
-		"The hosts have updated the event. The info is now: \n" +
-		"Time: " + updatedEvent.HappeningAt.Format("Mon January 2, 15:04") + "\n" +
-		"Description: " + updatedEvent.Title + "\n" +
-		"You can log onto the app for more info. \n Bye. \n\n" +
-		"https://d6ye2sqzk9ylp.cloudfront.net/ \r\n")
-	err := smtp.SendMail("smtp.gmail.com:587", auth, fwfEmail, recipients, msg)
+func participantLeftEmails(emailer *Emailer, event Event, participant User) (OutboundEmails, error) {
+	return emailer.render(EMAIL_PARTICIPANT_LEFT,
+		EmailData{Event: event, Host: event.Host, Participant: participant},
+		event.Host.Users)
+}
+
+// waitlistPromotedEmails are to the users promoted off the event's
+// waitlist.
+func waitlistPromotedEmails(emailer *Emailer, event Event, promoted Users) (OutboundEmails, error) {
+	return emailer.render(EMAIL_WAITLIST_PROMOTED,
+		EmailData{Event: event, Host: event.Host}, promoted)
+}
+
+func eventCancelledEmails(emailer *Emailer, cancelledEvent Event) (OutboundEmails, error) {
+	return emailer.render(EMAIL_EVENT_CANCELLED,
+		EmailData{Event: cancelledEvent, Host: cancelledEvent.Host},
+		cancelledEvent.Participants,
+		emailer.CalendarAttachment(Events{cancelledEvent}, CALENDAR_CANCEL))
+}
 
+func EnqueueEventUpdates(ctx context.Context, tx Execer, emailer *Emailer, updatedEvent Event) ([]int64, error) {
+	emails, err := eventUpdateEmails(emailer, updatedEvent)
 	if err != nil {
-		return err
+		return []int64{}, err
 	}
+	return EnqueueOutboundEmails(ctx, tx, emails)
+}
 
-	return nil
+func EnqueueHostsParticipantLeft(ctx context.Context, tx Execer, emailer *Emailer, event Event, participant User) ([]int64, error) {
+	emails, err := participantLeftEmails(emailer, event, participant)
+	if err != nil {
+		return []int64{}, err
+	}
+	return EnqueueOutboundEmails(ctx, tx, emails)
+}
+
+func EnqueueWaitlistPromoted(ctx context.Context, tx Execer, emailer *Emailer, event Event, promoted Users) ([]int64, error) {
+	emails, err := waitlistPromotedEmails(emailer, event, promoted)
+	if err != nil {
+		return []int64{}, err
+	}
+	return EnqueueOutboundEmails(ctx, tx, emails)
+}
+
+func EnqueueEventCancelled(ctx context.Context, tx Execer, emailer *Emailer, cancelledEvent Event) ([]int64, error) {
+	emails, err := eventCancelledEmails(emailer, cancelledEvent)
+	if err != nil {
+		return []int64{}, err
+	}
+	return EnqueueOutboundEmails(ctx, tx, emails)
 }
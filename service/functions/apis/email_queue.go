@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EmailQueueConfig controls delivery of the outbound email queue.
+type EmailQueueConfig struct {
+	// How many times an email is tried before it's marked failed
+	MaxAttempts int64
+	// How long to wait after the first failed attempt. The wait
+	// doubles after each further failure, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// How many emails are sent per run
+	BatchSize int
+	// How long a claimed email is left alone before it's considered
+	// abandoned and retried
+	Lease time.Duration
+	// How often the dev mode loop drains the queue
+	CheckInterval time.Duration
+}
+
+// EmailResult is the outcome of one attempt to deliver a queued email.
+type EmailResult struct {
+	OutboundEmailId int64  `json:"outboundEmailId"`
+	UserId          int64  `json:"userId"`
+	Email           string `json:"email"`
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+}
+
+type EmailResults []EmailResult
+
+// Failed returns the results for emails that weren't sent, whether
+// they'll be retried or not.
+func (results EmailResults) Failed() EmailResults {
+	failed := EmailResults{}
+	for _, result := range results {
+		if result.Status != EMAIL_SENT {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+var runEmailQueueLoop, _ = strconv.ParseBool(os.Getenv("FWF_EMAIL_QUEUE_LOOP"))
+
+func EmailQueueConfigFromEnv() EmailQueueConfig {
+	return EmailQueueConfig{
+		MaxAttempts: int64(envInt("FWF_EMAIL_MAX_ATTEMPTS", 8)),
+		RetryDelay: time.Duration(
+			envInt("FWF_EMAIL_RETRY_SECONDS", 60)) * time.Second,
+		MaxRetryDelay: time.Duration(
+			envInt("FWF_EMAIL_MAX_RETRY_MINUTES", 360)) * time.Minute,
+		BatchSize: envInt("FWF_EMAIL_BATCH_SIZE", 50),
+		Lease:     10 * time.Minute,
+		CheckInterval: time.Duration(
+			envInt("FWF_EMAIL_QUEUE_CHECK_SECONDS", 30)) * time.Second,
+	}
+}
+
+// RetryDelayAfter is how long to wait before trying an email again after
+// its attempts-th attempt failed, or zero if it's out of attempts.
+func (config EmailQueueConfig) RetryDelayAfter(attempts int64) time.Duration {
+	if attempts >= config.MaxAttempts {
+		return 0
+	}
+
+	delay := config.RetryDelay
+	for i := int64(1); i < attempts && delay < config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.MaxRetryDelay {
+		delay = config.MaxRetryDelay
+	}
+	return delay
+}
+
+// DeliverOutboundEmails sends queued emails that are due, or only
+// those in ids if it isn't empty, and records each one's outcome.
+// Failed sends are retried with exponential backoff until they run out
+// of attempts. The error is only for problems with the queue itself;
+// send failures are in the results.
+func DeliverOutboundEmails(ctx context.Context, queue EmailQueueStore, mailer Mailer, config EmailQueueConfig, ids []int64) (EmailResults, error) {
+	results := EmailResults{}
+
+	emails, err := queue.ClaimOutboundEmails(ctx, ids, config.BatchSize,
+		config.Lease)
+	if err != nil {
+		return results, err
+	}
+
+	for _, email := range emails {
+		result := EmailResult{
+			OutboundEmailId: email.OutboundEmailId,
+			UserId:          email.UserId,
+			Email:           email.Recipient,
+			Status:          EMAIL_SENT,
+		}
+
+		sendErr := mailer.Send(email.Sender, []string{email.Recipient},
+			email.Message)
+		if sendErr == nil {
+			err = queue.MarkOutboundEmailSent(ctx, email.OutboundEmailId)
+		} else {
+			fmt.Printf("Couldn't email %s: %s\n", email.Recipient,
+				sendErr.Error())
+			retryAfter := config.RetryDelayAfter(email.Attempts)
+			result.Error = sendErr.Error()
+			result.Status = EMAIL_PENDING
+			if retryAfter == 0 {
+				result.Status = EMAIL_FAILED
+			}
+			err = queue.MarkOutboundEmailFailed(ctx, email.OutboundEmailId,
+				sendErr.Error(), retryAfter)
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func DeliverScheduledEmails(ctx context.Context, queue EmailQueueStore) (EmailResults, error) {
+	mailer, err := MailerFromEnv()
+	if err != nil {
+		return EmailResults{}, err
+	}
+
+	// Keep going until a batch comes back short, so a backlog doesn't
+	// wait for the next run
+	config := EmailQueueConfigFromEnv()
+	results := EmailResults{}
+	for {
+		batch, err := DeliverOutboundEmails(ctx, queue, mailer, config, nil)
+		results = append(results, batch...)
+		if err != nil || len(batch) < config.BatchSize {
+			return results, err
+		}
+	}
+}
+
+// RunEmailQueueLoop drains the email queue every CheckInterval,
+// forever. It stands in for the scheduled Lambda trigger in dev mode.
+func RunEmailQueueLoop(queue EmailQueueStore) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			SCHEDULED_RUN_TIMEOUT)
+		results, err := DeliverScheduledEmails(ctx, queue)
+		cancel()
+		if err != nil {
+			fmt.Printf("Email delivery failed: %s\n", err.Error())
+		} else if len(results) > 0 {
+			fmt.Printf("Delivered emails: %+v\n", results)
+		}
+		time.Sleep(EmailQueueConfigFromEnv().CheckInterval)
+	}
+}
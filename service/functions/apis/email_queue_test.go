@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelayAfter(t *testing.T) {
+	config := EmailQueueConfig{
+		MaxAttempts:   6,
+		RetryDelay:    time.Minute,
+		MaxRetryDelay: 10 * time.Minute,
+	}
+
+	expected := []time.Duration{
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		10 * time.Minute,
+		0,
+	}
+	for i, delay := range expected {
+		attempts := int64(i + 1)
+		if actual := config.RetryDelayAfter(attempts); actual != delay {
+			t.Errorf("After %d attempts expected %s, got %s",
+				attempts, delay, actual)
+		}
+	}
+
+	failed := EmailResults{
+		EmailResult{OutboundEmailId: 1, Status: EMAIL_SENT},
+		EmailResult{OutboundEmailId: 2, Status: EMAIL_PENDING},
+		EmailResult{OutboundEmailId: 3, Status: EMAIL_FAILED},
+	}.Failed()
+	if len(failed) != 2 || failed[0].OutboundEmailId != 2 {
+		t.Errorf("Expected pending and failed results: %v", failed)
+	}
+}
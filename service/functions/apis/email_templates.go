@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const EMAIL_YOUR_TURN string = "your_turn"
+const EMAIL_HOST_REMINDER string = "host_reminder"
+const EMAIL_EVENT_UPDATE string = "event_update"
+const EMAIL_PARTICIPANT_LEFT string = "participant_left"
+const EMAIL_EVENT_CANCELLED string = "event_cancelled"
+const EMAIL_WAITLIST_PROMOTED string = "waitlist_promoted"
+
+const DEFAULT_SITE_URL string = "https://d6ye2sqzk9ylp.cloudfront.net/"
+
+// EmailData is what every email template is rendered with. Fields that
+// don't apply to a notification are left zero.
+type EmailData struct {
+	SiteURL     string
+	Recipient   User
+	Event       Event
+	Host        Host
+	Participant User
+}
+
+// EmailMessage is a rendered email, ready to be built into a MIME
+// message.
+type EmailMessage struct {
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []EmailAttachment
+}
+
+type EmailAttachment struct {
+	Filename string
+	// ContentType may have parameters, e.g. text/calendar's method
+	ContentType string
+	Content     []byte
+}
+
+type emailTemplateSource struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+type EmailTemplates struct {
+	SiteURL   string
+	templates map[string]emailTemplate
+}
+
+var emailTemplateFuncs = map[string]interface{}{
+	"formatTime": func(t time.Time) string {
+		return t.Format("Mon January 2, 15:04 MST")
+	},
+	// Only for HTML templates; the result isn't escaped
+	"markdown": func(markdown string) htmltemplate.HTML {
+		return htmltemplate.HTML(RenderMarkdown(markdown))
+	},
+}
+
+var DEFAULT_EMAIL_TEMPLATES = map[string]emailTemplateSource{
+	EMAIL_YOUR_TURN: emailTemplateSource{
+		Subject: `Your turn to host!`,
+		Text: `Hi {{.Recipient.Name}},
+
+Please visit {{.SiteURL}}#create-event to create your event.
+Only one of your house need create one. Make sure to rsvp to the event
+yourself when you're done.
+
+Thanks <3
+`,
+		HTML: `<p>Hi {{.Recipient.Name}},</p>
+<p>
+  Please visit <a href="{{.SiteURL}}#create-event">Food With Friends</a>
+  to create your event. Only one of your house need create one. Make
+  sure to rsvp to the event yourself when you're done.
+</p>
+<p>Thanks &lt;3</p>
+`,
+	},
+	EMAIL_HOST_REMINDER: emailTemplateSource{
+		Subject: `Reminder: it's your turn to host!`,
+		Text: `Hi {{.Recipient.Name}},
+
+Just a reminder that it's your turn to host a potluck.
+
+Please visit {{.SiteURL}}#create-event to create your event, or let us
+know you can't host this time so we can ask the next house. If we don't
+hear from you soon we'll pass your turn along.
+
+Thanks <3
+`,
+		HTML: `<p>Hi {{.Recipient.Name}},</p>
+<p>Just a reminder that it's your turn to host a potluck.</p>
+<p>
+  Please visit <a href="{{.SiteURL}}#create-event">Food With Friends</a>
+  to create your event, or let us know you can't host this time so we
+  can ask the next house. If we don't hear from you soon we'll pass your
+  turn along.
+</p>
+<p>Thanks &lt;3</p>
+`,
+	},
+	EMAIL_EVENT_UPDATE: emailTemplateSource{
+		Subject: `Your Potluck's Got An Update`,
+		Text: `Hello {{.Recipient.Name}}.
+
+You're receiving this email because you RSVPed to a potluck with the
+VFA potluck app. The hosts have updated the event. The info is now:
+
+Event: {{.Event.Title}}
+Time: {{formatTime .Event.HappeningAt}}
+Where: {{.Event.Host.Address}}, {{.Event.Host.City}}
+{{if .Event.Description}}
+{{.Event.Description}}
+{{end}}
+You can log onto the app for more info.
+
+Bye.
+
+{{.SiteURL}}
+`,
+		HTML: `<p>Hello {{.Recipient.Name}}.</p>
+<p>
+  You're receiving this email because you RSVPed to a potluck with the
+  VFA potluck app. The hosts have updated the event. The info is now:
+</p>
+<ul>
+  <li>Event: {{.Event.Title}}</li>
+  <li>Time: {{formatTime .Event.HappeningAt}}</li>
+  <li>Where: {{.Event.Host.Address}}, {{.Event.Host.City}}</li>
+</ul>
+{{if .Event.Description}}{{markdown .Event.Description}}{{end}}<p>You can log onto <a href="{{.SiteURL}}">the app</a> for more info.</p>
+<p>Bye.</p>
+`,
+	},
+	EMAIL_EVENT_CANCELLED: emailTemplateSource{
+		Subject: `Your potluck has been cancelled`,
+		Text: `Hello {{.Recipient.Name}}.
+
+Sorry, the hosts have cancelled "{{.Event.Title}}" on
+{{formatTime .Event.HappeningAt}}. You don't need to bring anything.
+
+Keep an eye out for the next one.
+
+Bye.
+
+{{.SiteURL}}
+`,
+		HTML: `<p>Hello {{.Recipient.Name}}.</p>
+<p>
+  Sorry, the hosts have cancelled "{{.Event.Title}}" on
+  {{formatTime .Event.HappeningAt}}. You don't need to bring anything.
+</p>
+<p>Keep an eye out for <a href="{{.SiteURL}}">the next one</a>.</p>
+<p>Bye.</p>
+`,
+	},
+	EMAIL_PARTICIPANT_LEFT: emailTemplateSource{
+		Subject: `A guest can't make it to your potluck`,
+		Text: `Hello {{.Recipient.Name}}.
+
+{{.Participant.Name}} is no longer coming to "{{.Event.Title}}" on
+{{formatTime .Event.HappeningAt}}.{{if .Participant.AssignedDish}} They were bringing {{.Participant.AssignedDish}}.{{end}}
+Dishes have been reassigned among the remaining guests.
+
+You can log onto the app for more info.
+
+Bye.
+
+{{.SiteURL}}
+`,
+		HTML: `<p>Hello {{.Recipient.Name}}.</p>
+<p>
+  {{.Participant.Name}} is no longer coming to "{{.Event.Title}}" on
+  {{formatTime .Event.HappeningAt}}.
+  {{if .Participant.AssignedDish}}They were bringing {{.Participant.AssignedDish}}.{{end}}
+  Dishes have been reassigned among the remaining guests.
+</p>
+<p>You can log onto <a href="{{.SiteURL}}">the app</a> for more info.</p>
+<p>Bye.</p>
+`,
+	},
+	EMAIL_WAITLIST_PROMOTED: emailTemplateSource{
+		Subject: `You've got a seat at the potluck`,
+		Text: `Hello {{.Recipient.Name}}.
+
+A spot opened up at "{{.Event.Title}}" on
+{{formatTime .Event.HappeningAt}}, so you're off the waitlist and
+coming to the potluck.
+
+Where: {{.Event.Host.Address}}, {{.Event.Host.City}}
+
+You can log onto the app to see what you're bringing.
+
+Bye.
+
+{{.SiteURL}}
+`,
+		HTML: `<p>Hello {{.Recipient.Name}}.</p>
+<p>
+  A spot opened up at "{{.Event.Title}}" on
+  {{formatTime .Event.HappeningAt}}, so you're off the waitlist and
+  coming to the potluck.
+</p>
+<ul>
+  <li>Where: {{.Event.Host.Address}}, {{.Event.Host.City}}</li>
+</ul>
+<p>You can log onto <a href="{{.SiteURL}}">the app</a> to see what you're bringing.</p>
+<p>Bye.</p>
+`,
+	},
+}
+
+func normalizeSiteURL(siteURL string) string {
+	if !strings.HasSuffix(siteURL, "/") {
+		return siteURL + "/"
+	}
+	return siteURL
+}
+
+// readTemplateOverride returns the contents of dir/name if dir is set
+// and the file exists, otherwise defaultSource.
+func readTemplateOverride(dir string, name string, defaultSource string) (string, error) {
+	if len(dir) == 0 {
+		return defaultSource, nil
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return defaultSource, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+// LoadEmailTemplates parses the default email templates. Any of them
+// can be overridden by files in dir named <template>.subject.txt,
+// <template>.txt, and <template>.html.
+func LoadEmailTemplates(dir string, siteURL string) (*EmailTemplates, error) {
+	templates := &EmailTemplates{
+		SiteURL:   normalizeSiteURL(siteURL),
+		templates: make(map[string]emailTemplate),
+	}
+
+	for name, source := range DEFAULT_EMAIL_TEMPLATES {
+		subject, err := readTemplateOverride(dir, name+".subject.txt",
+			source.Subject)
+		if err != nil {
+			return nil, err
+		}
+		text, err := readTemplateOverride(dir, name+".txt", source.Text)
+		if err != nil {
+			return nil, err
+		}
+		html, err := readTemplateOverride(dir, name+".html", source.HTML)
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed emailTemplate
+		parsed.subject, err = texttemplate.New(name + ".subject.txt").
+			Funcs(emailTemplateFuncs).Parse(strings.TrimSpace(subject))
+		if err != nil {
+			return nil, err
+		}
+		parsed.text, err = texttemplate.New(name + ".txt").
+			Funcs(emailTemplateFuncs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		parsed.html, err = htmltemplate.New(name + ".html").
+			Funcs(emailTemplateFuncs).Parse(html)
+		if err != nil {
+			return nil, err
+		}
+		templates.templates[name] = parsed
+	}
+
+	return templates, nil
+}
+
+// EmailTemplatesFromEnv loads the templates with overrides from
+// FWF_EMAIL_TEMPLATE_DIR, linking to FWF_SITE_URL.
+func EmailTemplatesFromEnv() (*EmailTemplates, error) {
+	siteURL := os.Getenv("FWF_SITE_URL")
+	if len(siteURL) == 0 {
+		siteURL = DEFAULT_SITE_URL
+	}
+	return LoadEmailTemplates(os.Getenv("FWF_EMAIL_TEMPLATE_DIR"), siteURL)
+}
+
+// Render renders the named template. data.SiteURL is filled in from
+// the templates' configuration.
+func (t *EmailTemplates) Render(name string, data EmailData) (EmailMessage, error) {
+	template, ok := t.templates[name]
+	if !ok {
+		return EmailMessage{}, errors.New("No email template named " + name)
+	}
+
+	data.SiteURL = t.SiteURL
+	// Times are shown where the event is, not where the server is
+	data.Event = LocalizeEvent(data.Event)
+
+	var subject, text, html bytes.Buffer
+	if err := template.subject.Execute(&subject, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := template.text.Execute(&text, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := template.html.Execute(&html, data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	return EmailMessage{
+		Subject: strings.Replace(subject.String(), "\n", " ", -1),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func writeQuotedPrintablePart(buffer *bytes.Buffer, boundary string, contentType string, body string) {
+	buffer.WriteString("--" + boundary + "\r\n")
+	buffer.WriteString("Content-Type: " + contentType + "; charset=\"utf-8\"\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(buffer)
+	writer.Write([]byte(strings.Replace(body, "\n", "\r\n", -1)))
+	writer.Close()
+	buffer.WriteString("\r\n")
+}
+
+func writeAttachmentPart(buffer *bytes.Buffer, boundary string, attachment EmailAttachment) {
+	buffer.WriteString("--" + boundary + "\r\n")
+	buffer.WriteString("Content-Type: " + attachment.ContentType +
+		"; name=\"" + attachment.Filename + "\"\r\n")
+	buffer.WriteString("Content-Disposition: attachment; filename=\"" +
+		attachment.Filename + "\"\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+	for len(encoded) > 76 {
+		buffer.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buffer.WriteString(encoded + "\r\n")
+}
+
+// BuildMIMEMessage builds a multipart/alternative message with the
+// email's plain text and HTML bodies. If the email has attachments,
+// that's wrapped in a multipart/mixed message along with them.
+func BuildMIMEMessage(from mail.Address, to []mail.Address, email EmailMessage) []byte {
+	var recipients []string
+	for _, address := range to {
+		recipients = append(recipients, address.String())
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("From: " + from.String() + "\r\n")
+	buffer.WriteString("To: " + strings.Join(recipients, ", ") + "\r\n")
+	buffer.WriteString("Subject: " +
+		mime.QEncoding.Encode("utf-8", email.Subject) + "\r\n")
+	buffer.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+
+	var mixedBoundary string
+	if len(email.Attachments) > 0 {
+		mixedBoundary = randomBoundary()
+		buffer.WriteString("Content-Type: multipart/mixed; boundary=\"" +
+			mixedBoundary + "\"\r\n\r\n")
+		buffer.WriteString("--" + mixedBoundary + "\r\n")
+	}
+
+	boundary := randomBoundary()
+	buffer.WriteString("Content-Type: multipart/alternative; boundary=\"" +
+		boundary + "\"\r\n\r\n")
+
+	writeQuotedPrintablePart(&buffer, boundary, "text/plain", email.Text)
+	writeQuotedPrintablePart(&buffer, boundary, "text/html", email.HTML)
+	buffer.WriteString("--" + boundary + "--\r\n")
+
+	if len(email.Attachments) > 0 {
+		for _, attachment := range email.Attachments {
+			writeAttachmentPart(&buffer, mixedBoundary, attachment)
+		}
+		buffer.WriteString("--" + mixedBoundary + "--\r\n")
+	}
+
+	return buffer.Bytes()
+}
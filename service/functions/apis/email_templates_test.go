@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEmailer(t *testing.T) (*Emailer, *MemoryMailer) {
+	templates, err := LoadEmailTemplates("", "https://fwf.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &MemoryMailer{}
+	return &Emailer{
+		Mailer:    mailer,
+		Templates: templates,
+		From:      "fwf@example.com",
+	}, mailer
+}
+
+func TestRenderDefaultEmailTemplates(t *testing.T) {
+	templates, err := LoadEmailTemplates("", "https://fwf.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := EmailData{
+		Event: Event{
+			Title:       "Soup & <Bread>",
+			HappeningAt: time.Date(2018, 3, 2, 18, 30, 0, 0, time.UTC),
+			Host:        Host{Address: "1 Main St", City: "Philadelphia"},
+		},
+		Participant: User{Name: "Alice", AssignedDish: "main"},
+	}
+
+	for name := range DEFAULT_EMAIL_TEMPLATES {
+		rendered, err := templates.Render(name, data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if len(rendered.Subject) == 0 || len(rendered.Text) == 0 ||
+			len(rendered.HTML) == 0 {
+			t.Errorf("%s rendered an empty part: %v", name, rendered)
+		}
+		if !strings.Contains(rendered.Text, "https://fwf.example.com/") {
+			t.Errorf("%s doesn't link to the site: %s", name, rendered.Text)
+		}
+	}
+
+	data.Event.Description = "**Bring** <chairs>"
+	rendered, _ := templates.Render(EMAIL_EVENT_UPDATE, data)
+	if !strings.Contains(rendered.HTML,
+		"<strong>Bring</strong> &lt;chairs&gt;") {
+		t.Errorf("Expected rendered Markdown description: %s",
+			rendered.HTML)
+	}
+	if !strings.Contains(rendered.Text, "**Bring** <chairs>") {
+		t.Errorf("Expected plain description: %s", rendered.Text)
+	}
+	if !strings.Contains(rendered.Text, "Soup & <Bread>") {
+		t.Errorf("Plain text shouldn't be escaped: %s", rendered.Text)
+	}
+	if !strings.Contains(rendered.HTML, "Soup &amp; &lt;Bread&gt;") {
+		t.Errorf("HTML should be escaped: %s", rendered.HTML)
+	}
+	if !strings.Contains(rendered.Text, "Fri March 2, 18:30 UTC") {
+		t.Errorf("Expected the event time: %s", rendered.Text)
+	}
+
+	data.Event.Timezone = "America/New_York"
+	rendered, _ = templates.Render(EMAIL_EVENT_UPDATE, data)
+	if !strings.Contains(rendered.Text, "Fri March 2, 13:30 EST") {
+		t.Errorf("Expected the time where the event is: %s", rendered.Text)
+	}
+}
+
+func TestOverrideEmailTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwf-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, EMAIL_YOUR_TURN+".subject.txt"),
+		[]byte("Hosting time\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadEmailTemplates(dir, "https://fwf.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := templates.Render(EMAIL_YOUR_TURN, EmailData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Subject != "Hosting time" {
+		t.Errorf("Expected overridden subject, got %q", rendered.Subject)
+	}
+	if !strings.Contains(rendered.Text, "create your event") {
+		t.Errorf("Expected default text body, got %s", rendered.Text)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, EMAIL_YOUR_TURN+".html"),
+		[]byte("{{.Missing"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadEmailTemplates(dir, "https://fwf.example.com/")
+	if err == nil {
+		t.Error("Expected a broken override to fail to load")
+	}
+}
+
+func TestBuildMIMEMessage(t *testing.T) {
+	msg := BuildMIMEMessage(
+		mail.Address{Address: "fwf@example.com"},
+		[]mail.Address{mail.Address{Name: "Alice", Address: "a@example.com"}},
+		EmailMessage{
+			Subject: "Soup's on ☕",
+			Text:    "Hello\n",
+			HTML:    "<p>Hello</p>\n",
+		})
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(
+		parsed.Header.Get("Subject"))
+	if err != nil || subject != "Soup's on ☕" {
+		t.Errorf("Unexpected subject %q: %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(
+		parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected content type %s: %v", mediaType, err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var contentTypes []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+	}
+	if len(contentTypes) != 2 ||
+		!strings.HasPrefix(contentTypes[0], "text/plain") ||
+		!strings.HasPrefix(contentTypes[1], "text/html") {
+		t.Errorf("Expected text and html parts, got %v", contentTypes)
+	}
+}
+
+// rejectingMailer fails to send to one address.
+type rejectingMailer struct {
+	MemoryMailer
+	Reject string
+}
+
+func (mailer *rejectingMailer) Send(from string, to []string, msg []byte) error {
+	for _, address := range to {
+		if address == mailer.Reject {
+			return errors.New("No such mailbox")
+		}
+	}
+	return mailer.MemoryMailer.Send(from, to, msg)
+}
+
+func TestBuildMIMEMessageWithAttachment(t *testing.T) {
+	msg := BuildMIMEMessage(
+		mail.Address{Address: "fwf@example.com"},
+		[]mail.Address{mail.Address{Address: "a@example.com"}},
+		EmailMessage{
+			Subject: "Update",
+			Text:    "Hello\n",
+			HTML:    "<p>Hello</p>\n",
+			Attachments: []EmailAttachment{EmailAttachment{
+				Filename:    "invite.ics",
+				ContentType: "text/calendar; method=REQUEST",
+				Content:     []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"),
+			}},
+		})
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(
+		parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Unexpected content type %s: %v", mediaType, err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	body, err := reader.NextPart()
+	if err != nil ||
+		!strings.HasPrefix(body.Header.Get("Content-Type"),
+			"multipart/alternative") {
+		t.Fatalf("Expected the body first: %v", err)
+	}
+
+	attachment, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "invite.ics" {
+		t.Errorf("Unexpected attachment %s", attachment.FileName())
+	}
+	content, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding,
+		attachment))
+	if string(content) != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("Unexpected attachment content %q", content)
+	}
+}
+
+func TestRenderEmailPerRecipient(t *testing.T) {
+	emailer, _ := newTestEmailer(t)
+
+	participants := Users{
+		User{UserId: 1, Name: "Alice", Email: "a@example.com"},
+		User{UserId: 3, Name: "Carol", Email: "c@example.com"},
+	}
+	emails, err := emailer.render(EMAIL_EVENT_UPDATE, EmailData{
+		Event: Event{Title: "Potluck", Participants: participants},
+	}, participants)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emails) != 2 {
+		t.Fatalf("Expected 2 emails, got %d", len(emails))
+	}
+	if emails[0].UserId != 1 || emails[0].Recipient != "a@example.com" ||
+		emails[0].Template != EMAIL_EVENT_UPDATE ||
+		emails[0].Sender != "fwf@example.com" {
+		t.Errorf("Unexpected email: %+v", emails[0])
+	}
+	if !bytes.Contains(emails[0].Message, []byte("To: \"Alice\" <a@example.com>")) ||
+		bytes.Contains(emails[0].Message, []byte("c@example.com")) {
+		t.Errorf("Expected email addressed only to Alice: %s",
+			emails[0].Message)
+	}
+	if !bytes.Contains(emails[1].Message, []byte("Hello Carol.")) {
+		t.Errorf("Expected email to greet Carol: %s", emails[1].Message)
+	}
+}
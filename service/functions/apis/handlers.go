@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 
@@ -18,8 +21,6 @@ func idFromStr(idStr string) (int64, error) {
 	return strconv.ParseInt(idStr, 10, 64)
 }
 
-// TODO check that access token usr and usr match
-
 // TODO
 // func handleCreate(w http.ResponseWriter,
 //                     r *http.Request,
@@ -29,76 +30,122 @@ func idFromStr(idStr string) (int64, error) {
 //
 //                     }
 
-func EventHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) EventHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		if len(r.URL.Query().Get("eventId")) > 0 {
-			HandleEventDetails(w, r)
+		if strings.HasSuffix(r.URL.Path, ".ics") {
+			app.HandleEventCalendar(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "dietary-restrictions/") {
+			app.HandleDietaryRestrictionReport(w, r)
+		} else if len(r.URL.Query().Get("eventId")) > 0 {
+			app.HandleEventDetails(w, r)
 		} else if len(r.URL.Query().Get("userId")) > 0 {
-			HandleEventsForUser(w, r)
+			app.HandleEventsForUser(w, r)
 		} else if len(r.URL.Query()) == 0 {
-			HandleCurrentEvents(w, r)
+			app.HandleCurrentEvents(w, r)
 		} else {
 			http.Error(w, "Not supported", 500)
 		}
 	} else if r.Method == "POST" {
 		if strings.HasSuffix(r.URL.Path, "add-participant/") {
-			HandleAddParticipantToEvent(w, r)
+			app.HandleAddParticipantToEvent(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "remove-participant/") {
+			app.HandleRemoveParticipantFromEvent(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "dish/") {
+			app.HandleChangeParticipantDish(w, r)
 		} else if strings.HasSuffix(r.URL.Path, "cant-host/") {
-			HandleCantHostEvent(w, r)
+			app.HandleCantHostEvent(w, r)
 		} else {
-			HandleEditEvent(w, r)
+			app.HandleEditEvent(w, r)
 		}
+	} else if r.Method == "PATCH" {
+		app.HandleEditEvent(w, r)
 	} else if r.Method == "PUT" {
-		HandleCreateEvent(w, r)
+		app.HandleCreateEvent(w, r)
+	} else if r.Method == "DELETE" {
+		app.HandleCancelEvent(w, r)
 	} else {
 		http.Error(w, "Not supported", 500)
 	}
 }
 
-func UserHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) UserHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		if len(r.URL.Query().Get("auth0Id")) > 0 {
-			HandleUserDetails(w, r.URL.Query().Get("auth0Id"))
+		if strings.HasSuffix(r.URL.Path, "dietary-restrictions/") {
+			json.NewEncoder(w).Encode(DIETARY_RESTRICTION_VOCABULARY)
+		} else if strings.HasSuffix(r.URL.Path, "calendar-feed/") {
+			app.HandleCalendarFeedPath(w, r)
+		} else if len(r.URL.Query().Get("auth0Id")) > 0 {
+			app.HandleUserDetails(r.Context(), w, r.URL.Query().Get("auth0Id"))
 		} else {
 			http.Error(w, "Not supported", 500)
 		}
-	} else if r.Method == "POST" {
-		HandleEditUser(w, r)
+	} else if r.Method == "POST" || r.Method == "PATCH" {
+		app.HandleEditUser(w, r)
 	} else if r.Method == "PUT" {
-		HandleCreateUser(w, r)
+		app.HandleCreateUser(w, r)
 	} else {
 		http.Error(w, "Not supported", 500)
 	}
 }
 
-func HostHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) HostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		if len(r.URL.Query().Get("hostId")) > 0 {
-			HandleHostDetails(w, r)
+			app.HandleHostDetails(w, r)
 		} else if len(r.URL.Query().Get("address")) > 0 {
-			HandleSearchHostByAddress(w, r)
+			app.HandleSearchHostByAddress(w, r)
 		} else {
 			http.Error(w, "Not supported", 500)
 		}
 	} else if r.Method == "POST" {
 		if strings.HasSuffix(r.URL.Path, "user/") &&
 			len(r.URL.Query().Get("hostId")) > 0 {
-			HandleAddUserToHost(w, r)
+			app.HandleAddUserToHost(w, r)
 		} else {
-			HandleEditHost(w, r)
+			app.HandleEditHost(w, r)
 		}
+	} else if r.Method == "PATCH" {
+		app.HandleEditHost(w, r)
 	} else if r.Method == "PUT" {
-		HandleCreateHost(w, r)
+		app.HandleCreateHost(w, r)
 	} else {
 		http.Error(w, "Not supported", 500)
 	}
 }
 
-func AdminHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
+func (app *App) AdminHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "whitelist/") {
+		if r.Method == "GET" {
+			app.HandleGetWhitelist(w, r)
+		} else if r.Method == "PUT" {
+			app.HandleAddToWhitelist(w, r)
+		} else if r.Method == "DELETE" {
+			app.HandleRemoveFromWhitelist(w, r)
+		} else {
+			http.Error(w, "Not supported", 500)
+		}
+	} else if r.Method == "GET" {
+		if strings.HasSuffix(r.URL.Path, "invites/pending/") {
+			app.HandlePendingHosts(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "invites/preview/") &&
+			len(r.URL.Query().Get("numHosts")) > 0 {
+			app.HandlePreviewNextHosts(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "invites/") {
+			app.HandleInvitationHistory(w, r)
+		} else {
+			http.Error(w, "Not supported", 500)
+		}
+	} else if r.Method == "POST" {
 		if strings.HasSuffix(r.URL.Path, "invites/") &&
 			len(r.URL.Query().Get("numHosts")) > 0 {
-			HandleSendItsYourTurnEmails(w, r)
+			app.HandleSendItsYourTurnEmails(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "invites/expire/") {
+			app.HandleExpireInvitations(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "invites/status/") &&
+			len(r.URL.Query().Get("invitationId")) > 0 {
+			app.HandleSetInvitationStatus(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "whitelist/import/") {
+			app.HandleImportWhitelist(w, r)
 		} else {
 			fmt.Println("url path ", r.URL.Path)
 			fmt.Println("query", r.URL.Query().Get("numHosts"))
@@ -109,29 +156,134 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleEventDetails(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleEventDetails(w http.ResponseWriter, r *http.Request) {
 	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
 	if err != nil {
 		http.Error(w, "Invalid eventId", 400)
 	}
 
-	db, err := Connect()
+	ctx := r.Context()
+
+	event, err := app.Events.GetEvent(ctx, eventId)
+	if err != nil {
+		http.Error(w, "Couldn't get event", 400)
+		return
+	}
+
+	json.NewEncoder(w).Encode(event)
+}
+
+func writeCalendar(w http.ResponseWriter, events Events, filename string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		"inline; filename=\""+filename+"\"")
+	w.Write(BuildCalendar(events, CALENDAR_PUBLISH, ""))
+}
+
+// HandleEventCalendar serves /events/{eventId}.ics
+func (app *App) HandleEventCalendar(w http.ResponseWriter, r *http.Request) {
+	eventId, err := idFromStr(strings.TrimSuffix(
+		path.Base(r.URL.Path), ".ics"))
+	if err != nil {
+		http.Error(w, "Invalid eventId", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	event, err := app.Events.GetEvent(ctx, eventId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No such event", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't get event", 400)
+		return
+	}
+
+	writeCalendar(w, Events{event}, fmt.Sprintf("event-%d.ics", eventId))
+}
+
+// HandleCalendarFeedPath tells a user where their calendar feed is.
+// The path has a secret token in it, so only the user can get it.
+func (app *App) HandleCalendarFeedPath(w http.ResponseWriter, r *http.Request) {
+	userId, err := idFromStr(r.URL.Query().Get("userId"))
+	if err != nil {
+		http.Error(w, "Invalid userId", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	isOwner, err := app.Users.IsAuth0User(ctx, userId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	calendarToken, err := app.Users.GetCalendarToken(ctx, userId)
+	if err != nil {
+		http.Error(w, "Couldn't get calendar feed", 400)
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"feedPath": "/calendar/" + calendarToken + ".ics",
+	})
+}
+
+// CalendarFeedHandler serves /calendar/{calendarToken}.ics, the
+// user's upcoming events for calendar apps to subscribe to. Calendar
+// apps can't log in, so the token stands in for authentication.
+func (app *App) CalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || !strings.HasSuffix(r.URL.Path, ".ics") {
+		http.Error(w, "Not supported", 500)
+		return
+	}
+
+	calendarToken := strings.TrimSuffix(path.Base(r.URL.Path), ".ics")
+
+	ctx := r.Context()
+
+	userId, err := app.Users.GetUserIdByCalendarToken(ctx, calendarToken)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No such calendar", 404)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	event, err := GetEvent(db, eventId)
-	db.Close()
+	events, err := app.Events.GetUpcomingEventsForUser(ctx, userId)
+	if err != nil {
+		http.Error(w, "Couldn't get events", 500)
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+
+	writeCalendar(w, events, "foodwithfriends.ics")
+}
+
+func (app *App) HandleDietaryRestrictionReport(w http.ResponseWriter, r *http.Request) {
+	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
+	if err != nil {
+		http.Error(w, "Invalid eventId", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	event, err := app.Events.GetEvent(ctx, eventId)
 	if err != nil {
 		http.Error(w, "Couldn't get event", 400)
 		return
 	}
 
-	json.NewEncoder(w).Encode(event)
+	json.NewEncoder(w).Encode(BuildDietaryRestrictionReport(event))
 }
 
-func HandleCreateEvent(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleCreateEvent(w http.ResponseWriter, r *http.Request) {
 	var event Event
 
 	if r.Body == nil {
@@ -151,177 +303,344 @@ func HandleCreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	ctx := r.Context()
+
+	isOwner, err := app.Hosts.IsAuth0UserInHost(ctx, event.Host.HostId,
+		RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
 		return
 	}
-	canCreate, err := CanHostCreateEvent(db, event.Host.HostId)
-	if err != nil || !canCreate {
-		http.Error(w, "Not user's turn to create event", 400)
+
+	eventId, err := app.Events.CreateInvitedEvent(ctx, event)
+	if err == ErrNotHostsTurn {
+		http.Error(w, err.Error(), 400)
 		return
 	}
-
-	eventId, err := CreateEvent(db, event)
 	if err != nil {
 		http.Error(w, "Couldn't create event", 400)
+		fmt.Printf("%s\n", err.Error())
 		return
 	}
 
-	event.EventId = eventId
+	// Read it back for the defaulted timezone and localized times
+	event, err = app.Events.GetEvent(ctx, eventId)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
 	json.NewEncoder(w).Encode(event)
-
-	UpdateHostInvitation(db, event.Host.HostId, EVENT_CREATED)
-	db.Close()
 }
 
-func HandleCantHostEvent(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleCantHostEvent(w http.ResponseWriter, r *http.Request) {
 	hostId, err := idFromStr(r.URL.Query().Get("hostId"))
 	if err != nil {
 		http.Error(w, "Invalid hostId", 400)
 		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
+	ctx := r.Context()
 
-	canCreate, err := CanHostCreateEvent(db, hostId)
-	if err != nil || !canCreate {
-		http.Error(w, "Not user's turn to create event", 400)
+	isOwner, err := app.Hosts.IsAuth0UserInHost(ctx, hostId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
 		return
 	}
 
-	err = UpdateHostInvitation(db, hostId, PASS)
+	emailer, err := EmailerFromEnv()
 	if err != nil {
-		http.Error(w, err.Error(), 400)
+		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	err = SendEmailsToLeastRecentHosts(db, 1)
-	if err != nil {
+	// The emails are queued with the pass, so they're only sent once
+	// it's committed. With no one left to invite, the pass still went
+	// through.
+	_, err = app.Invitations.PassHostTurn(ctx, emailer, hostId)
+	if err == ErrNotHostsTurn {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-
-	db.Close()
+	if err != nil && err != ErrNoHostsToInvite {
+		http.Error(w, "Couldn't pass the turn", 500)
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
 }
 
-func HandleEditEvent(w http.ResponseWriter, r *http.Request) {
+// HandleEditEvent applies a merge patch (see Patch) to the event
+// with the body's eventId.
+func (app *App) HandleEditEvent(w http.ResponseWriter, r *http.Request) {
 	shouldEmailParticipants :=
 		r.URL.Query().Get("emailParticipants") == "true"
 
-	var event Event
-
 	if r.Body == nil {
 		http.Error(w, "No request body", 400)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&event)
+	patch, err := DecodePatch(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	db, err := Connect()
+	eventId, err := patch.Id("eventId")
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	updatedEvent, err := UpdateEvent(db, event)
-	db.Close()
+	update, err := patch.EventUpdate()
+	if err == nil {
+		err = ValidateEventUpdate(update)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
+	ctx := r.Context()
+
+	isOwner, err := app.Events.IsAuth0UserHostOfEvent(ctx, eventId, RequestAuth0Id(r))
+	if isOwner && update.HostId != nil {
+		// Handing the event to another host needs membership there too
+		isOwner, err = app.Hosts.IsAuth0UserInHost(ctx, *update.HostId,
+			RequestAuth0Id(r))
+	}
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	var emailer *Emailer
 	if shouldEmailParticipants {
-		EmailEventUpdates(updatedEvent)
+		emailer, err = EmailerFromEnv()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	updatedEvent, err := app.Events.UpdateEvent(ctx, emailer, eventId, update)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
 
 	json.NewEncoder(w).Encode(updatedEvent)
 }
 
-func HandleAddParticipantToEvent(w http.ResponseWriter, r *http.Request) {
+// HandleCancelEvent cancels the event and emails its participants.
+// The event is kept, marked cancelled.
+func (app *App) HandleCancelEvent(w http.ResponseWriter, r *http.Request) {
+	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
+	if err != nil {
+		http.Error(w, "Invalid eventId", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	isOwner, err := app.Events.IsAuth0UserHostOfEvent(ctx, eventId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	emailer, err := EmailerFromEnv()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	cancelledEvent, err := app.Events.CancelEvent(ctx, emailer, eventId)
+	if err == ErrEventCancelled {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't cancel event", 400)
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(cancelledEvent)
+}
+
+func (app *App) HandleAddParticipantToEvent(w http.ResponseWriter, r *http.Request) {
 	userId, err := idFromStr(r.URL.Query().Get("userId"))
 	if err != nil {
 		http.Error(w, "Invalid userId", 400)
+		return
 	}
 
 	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
 	if err != nil {
 		http.Error(w, "Invalid eventId", 400)
+		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	ctx := r.Context()
+
+	isOwner, err := app.Users.IsAuth0User(ctx, userId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
 		return
 	}
 
-	updatedEvent, err := AddUserToEvent(db, eventId, userId)
+	updatedEvent, err := app.Events.AddUserToEvent(ctx, eventId, userId)
+	if err == ErrEventFull {
+		http.Error(w, "Event is full, user added to waitlist", 409)
+		return
+	}
+	if err == ErrEventCancelled {
+		http.Error(w, err.Error(), 409)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Couldn't add user to event", 400)
+		return
 	}
-	db.Close()
 
 	json.NewEncoder(w).Encode(updatedEvent)
 }
 
-func HandleCurrentEvents(w http.ResponseWriter, r *http.Request) {
-	db, err := Connect()
+func (app *App) HandleRemoveParticipantFromEvent(w http.ResponseWriter, r *http.Request) {
+	userId, err := idFromStr(r.URL.Query().Get("userId"))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, "Invalid userId", 400)
+		return
+	}
+
+	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
+	if err != nil {
+		http.Error(w, "Invalid eventId", 400)
 		return
 	}
 
-	events, err := GetCurrentEvents(db)
-	db.Close()
+	ctx := r.Context()
+
+	// Guests can drop out themselves, or be removed by a host
+	isOwner, err := app.Users.IsAuth0User(ctx, userId, RequestAuth0Id(r))
+	if err == nil && !isOwner {
+		isOwner, err = app.Events.IsAuth0UserHostOfEvent(ctx, eventId,
+			RequestAuth0Id(r))
+	}
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	emailer, err := EmailerFromEnv()
 	if err != nil {
 		http.Error(w, err.Error(), 500)
-		// http.Error(w, "Couldn't get current events", 500)
 		return
 	}
 
-	json.NewEncoder(w).Encode(events)
+	updatedEvent, err := app.Events.RemoveUserFromEvent(ctx, emailer, eventId,
+		userId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User isn't attending this event", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't remove user from event", 400)
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(updatedEvent)
 }
 
-func HandleEventsForUser(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleChangeParticipantDish(w http.ResponseWriter, r *http.Request) {
 	userId, err := idFromStr(r.URL.Query().Get("userId"))
 	if err != nil {
 		http.Error(w, "Invalid userId", 400)
 		return
 	}
 
-	db, err := Connect()
+	eventId, err := idFromStr(r.URL.Query().Get("eventId"))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, "Invalid eventId", 400)
+		return
+	}
+
+	var change DishChange
+
+	if r.Body == nil {
+		http.Error(w, "No request body", 400)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if change.AssignedDish != "" && change.TradeWithUserId != 0 {
+		http.Error(w, "Can't claim a dish and trade in one request", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	isOwner, err := app.Users.IsAuth0User(ctx, userId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	updatedEvent, err := app.Events.ChangeParticipantDish(ctx, eventId, userId, change)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User isn't attending this event", 404)
+		return
+	}
+	if err == ErrUnknownDishSlot {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err == ErrDishSlotFull || err == ErrEventCancelled {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't change dish", 400)
+		fmt.Printf("%s\n", err.Error())
 		return
 	}
 
-	events, err := GetPastEventsForUser(db, userId)
-	db.Close()
+	json.NewEncoder(w).Encode(updatedEvent)
+}
+
+func (app *App) HandleCurrentEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	events, err := app.Events.GetCurrentEvents(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
+		// http.Error(w, "Couldn't get current events", 500)
 		return
 	}
 
 	json.NewEncoder(w).Encode(events)
 }
 
-func HandleUserDetails(w http.ResponseWriter, auth0Id string) {
-	db, err := Connect()
+func (app *App) HandleEventsForUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := idFromStr(r.URL.Query().Get("userId"))
+	if err != nil {
+		http.Error(w, "Invalid userId", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	events, err := app.Events.GetPastEventsForUser(ctx, userId)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	user, err := GetUserByAuth0Id(db, auth0Id)
-	db.Close()
+	json.NewEncoder(w).Encode(events)
+}
+
+func (app *App) HandleUserDetails(ctx context.Context, w http.ResponseWriter, auth0Id string) {
+
+	user, err := app.Users.GetUserByAuth0Id(ctx, auth0Id)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "No account for this user id", 404)
@@ -336,7 +655,32 @@ func HandleUserDetails(w http.ResponseWriter, auth0Id string) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+// checkEmail writes an error response and returns false unless email
+// is the caller's verified email address, going by their access token,
+// and it's whitelisted.
+func (app *App) checkEmail(ctx context.Context, w http.ResponseWriter, r *http.Request, email string) bool {
+	if !IsRequestEmail(r, email) {
+		http.Error(w, "Email has to be your login's verified email",
+			http.StatusForbidden)
+		return false
+	}
+
+	isWhitelisted, err := app.Users.IsEmailWhitelisted(ctx, email)
+	if err != nil {
+		http.Error(w, "Couldn't check whitelist", 500)
+		fmt.Printf("%s", err.Error())
+		return false
+	}
+	if !isWhitelisted {
+		http.Error(w, fmt.Sprintf("%s hasn't been invited to join. "+
+			"Ask an admin to add it to the whitelist.", email),
+			http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (app *App) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var user User
 
 	if r.Body == nil {
@@ -357,15 +701,17 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, "Couldn't connect to DB", 500)
-		fmt.Printf("%s", err.Error())
+	if !checkOwnership(w, user.Auth0Id == RequestAuth0Id(r), nil) {
+		return
+	}
+
+	ctx := r.Context()
+
+	if !app.checkEmail(ctx, w, r, user.Email) {
 		return
 	}
 
-	userId, err := CreateUser(db, user)
-	db.Close()
+	userId, err := app.Users.CreateUser(ctx, user)
 	if err != nil {
 		http.Error(w, "Couldn't create user", 400)
 		fmt.Printf("%s", err.Error())
@@ -376,28 +722,50 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func HandleEditUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-
+// HandleEditUser applies a merge patch (see Patch) to the user with
+// the body's auth0Id, or the logged in user.
+func (app *App) HandleEditUser(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		http.Error(w, "No request body", 400)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	patch, err := DecodePatch(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	db, err := Connect()
+	var auth0Id *string
+	err = patch.decode("auth0Id", &auth0Id)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if auth0Id == nil || len(*auth0Id) == 0 {
+		auth0Id = new(string)
+		*auth0Id = RequestAuth0Id(r)
+	}
+	if !checkOwnership(w, *auth0Id == RequestAuth0Id(r), nil) {
 		return
 	}
 
-	updatedUser, err := UpdateUser(db, user)
-	db.Close()
+	update, err := patch.UserUpdate()
+	if err == nil {
+		err = ValidateUserUpdate(update)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	if update.Email != nil && !app.checkEmail(ctx, w, r, *update.Email) {
+		return
+	}
+
+	updatedUser, err := app.Users.UpdateUser(ctx, *auth0Id, update)
 	if err != nil {
 		http.Error(w, "Couldn't update user", 400)
 		fmt.Printf("%s", err.Error())
@@ -407,7 +775,7 @@ func HandleEditUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedUser)
 }
 
-func HandleCreateHost(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleCreateHost(w http.ResponseWriter, r *http.Request) {
 	var host Host
 
 	if r.Body == nil {
@@ -427,14 +795,22 @@ func HandleCreateHost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	ctx := r.Context()
+
+	// Callers can only create a host for themselves. Others join it
+	// through its members, see HandleAddUserToHost.
+	isOwner := false
+	for _, user := range host.Users {
+		if isOwner, err = app.Users.IsAuth0User(ctx, user.UserId,
+			RequestAuth0Id(r)); !isOwner || err != nil {
+			break
+		}
+	}
+	if !checkOwnership(w, isOwner, err) {
 		return
 	}
 
-	hostId, err := CreateHost(db, host)
-	db.Close()
+	hostId, err := app.Hosts.CreateHost(ctx, host)
 	if err != nil {
 		http.Error(w, "Couldn't create host", 400)
 		fmt.Printf("%s\n", err.Error())
@@ -445,28 +821,43 @@ func HandleCreateHost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(host)
 }
 
-func HandleEditHost(w http.ResponseWriter, r *http.Request) {
-	var host Host
-
+// HandleEditHost applies a merge patch (see Patch) to the host with
+// the body's hostId.
+func (app *App) HandleEditHost(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		http.Error(w, "No request body", 400)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&host)
+	patch, err := DecodePatch(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	db, err := Connect()
+	hostId, err := patch.Id("hostId")
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	update, err := patch.HostUpdate()
+	if err == nil {
+		err = ValidateHostUpdate(update)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	updatedHost, err := UpdateHost(db, host)
-	db.Close()
+	ctx := r.Context()
+
+	isOwner, err := app.Hosts.IsAuth0UserInHost(ctx, hostId, RequestAuth0Id(r))
+	if !checkOwnership(w, isOwner, err) {
+		return
+	}
+
+	updatedHost, err := app.Hosts.UpdateHost(ctx, hostId, update)
 	if err != nil {
 		http.Error(w, "Couldn't update host", 400)
 		return
@@ -475,20 +866,15 @@ func HandleEditHost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedHost)
 }
 
-func HandleHostDetails(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleHostDetails(w http.ResponseWriter, r *http.Request) {
 	hostId, err := idFromStr(r.URL.Query().Get("hostId"))
 	if err != nil {
 		http.Error(w, "Invalid hostId", 400)
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
+	ctx := r.Context()
 
-	host, err := GetHost(db, hostId)
-	db.Close()
+	host, err := app.Hosts.GetHost(ctx, hostId)
 	if err != nil {
 		http.Error(w, "Couldn't get host", 400)
 		return
@@ -497,17 +883,12 @@ func HandleHostDetails(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(host)
 }
 
-func HandleSearchHostByAddress(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleSearchHostByAddress(w http.ResponseWriter, r *http.Request) {
 	address := r.URL.Query().Get("address")
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
+	ctx := r.Context()
 
-	hosts, err := GetHostsByAddress(db, address)
-	db.Close()
+	hosts, err := app.Hosts.GetHostsByAddress(ctx, address)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
@@ -516,7 +897,7 @@ func HandleSearchHostByAddress(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(hosts)
 }
 
-func HandleAddUserToHost(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleAddUserToHost(w http.ResponseWriter, r *http.Request) {
 	hostId, err := idFromStr(r.URL.Query().Get("hostId"))
 	if err != nil {
 		http.Error(w, "Invalid hostId", 400)
@@ -541,14 +922,19 @@ func HandleAddUserToHost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := Connect()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	ctx := r.Context()
+
+	// Members get control of the host's events and turns, so only its
+	// members or an admin can add them
+	isOwner := RequestIsAdmin(r)
+	if !isOwner {
+		isOwner, err = app.Hosts.IsAuth0UserInHost(ctx, hostId, RequestAuth0Id(r))
+	}
+	if !checkOwnership(w, isOwner, err) {
 		return
 	}
 
-	host, err := AddUserToHost(db, hostId, user.UserId)
-	db.Close()
+	host, err := app.Hosts.AddUserToHost(ctx, hostId, user.UserId)
 	if err != nil {
 		http.Error(w, "Couldn't add user to host", 400)
 		return
@@ -557,7 +943,7 @@ func HandleAddUserToHost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(host)
 }
 
-func HandleSendItsYourTurnEmails(w http.ResponseWriter, r *http.Request) {
+func (app *App) HandleSendItsYourTurnEmails(w http.ResponseWriter, r *http.Request) {
 	numHostsStr := r.URL.Query().Get("numHosts")
 	numHosts, err := strconv.Atoi(numHostsStr)
 	if err != nil {
@@ -565,18 +951,221 @@ func HandleSendItsYourTurnEmails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := Connect()
+	emailer, err := EmailerFromEnv()
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Couldn't send emails", 500)
 		return
 	}
 
-	err = SendEmailsToLeastRecentHosts(db, numHosts)
+	ctx := r.Context()
+
+	ids, err := app.Invitations.SendEmailsToLeastRecentHosts(ctx, emailer,
+		numHosts)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Couldn't send emails", 500)
 
 		return
 	}
+
+	results := EmailResults{}
+	if len(ids) > 0 {
+		results, err = DeliverOutboundEmails(ctx, app.Emails, emailer.Mailer,
+			EmailQueueConfigFromEnv(), ids)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// The invitations are recorded and unsent emails will be retried,
+	// so report who didn't get theirs rather than failing the request
+	failed := results.Failed()
+	if len(failed) > 0 && len(failed) < len(results) {
+		w.WriteHeader(207)
+	} else if len(failed) > 0 {
+		w.WriteHeader(502)
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+func (app *App) HandlePendingHosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	hosts, err := app.Invitations.GetPendingHosts(ctx)
+	if err != nil {
+		http.Error(w, "Couldn't get pending hosts", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hosts)
+}
+
+func (app *App) HandlePreviewNextHosts(w http.ResponseWriter, r *http.Request) {
+	numHosts, err := strconv.Atoi(r.URL.Query().Get("numHosts"))
+	if err != nil {
+		http.Error(w, "Invalid numHosts", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	hosts, err := app.Invitations.GetLeastRecentHosts(ctx, numHosts)
+	if err != nil {
+		http.Error(w, "Couldn't get next hosts", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hosts)
+}
+
+func (app *App) HandleInvitationHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	invitations, err := app.Invitations.GetInvitations(ctx)
+	if err != nil {
+		http.Error(w, "Couldn't get invitations", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (app *App) HandleExpireInvitations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	hosts, err := app.Invitations.ExpireEventInvitations(ctx)
+	if err != nil {
+		http.Error(w, "Couldn't expire invitations", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hosts)
+}
+
+func (app *App) HandleSetInvitationStatus(w http.ResponseWriter, r *http.Request) {
+	invitationId, err := idFromStr(r.URL.Query().Get("invitationId"))
+	if err != nil {
+		http.Error(w, "Invalid invitationId", 400)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	err = ValidateInvitationStatus(status)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	invitation, err := app.Invitations.SetInvitationStatus(ctx, invitationId, status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No such invitation", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't update invitation", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(invitation)
+}
+
+func (app *App) HandleGetWhitelist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	emails, err := app.Users.GetWhitelistedEmails(ctx)
+	if err != nil {
+		http.Error(w, "Couldn't get whitelist", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(Whitelist{Emails: emails})
+}
+
+func (app *App) HandleAddToWhitelist(w http.ResponseWriter, r *http.Request) {
+	var whitelist Whitelist
+
+	if r.Body == nil {
+		http.Error(w, "No request body", 400)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&whitelist)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if len(whitelist.Emails) == 0 {
+		http.Error(w, "Missing required fields: [emails]", 400)
+		return
+	}
+	for _, email := range whitelist.Emails {
+		if err = ValidateEmail(email); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	app.addWhitelistedEmails(r.Context(), w, whitelist.Emails, []string{})
+}
+
+func (app *App) HandleImportWhitelist(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, "No request body", 400)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	emails, invalid := ParseWhitelistEmails(string(body))
+	app.addWhitelistedEmails(r.Context(), w, emails, invalid)
+}
+
+func (app *App) addWhitelistedEmails(ctx context.Context, w http.ResponseWriter, emails []string, invalid []string) {
+
+	added, err := app.Users.AddWhitelistedEmails(ctx, emails)
+	if err != nil {
+		http.Error(w, "Couldn't add to whitelist", 500)
+		fmt.Println(err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WhitelistImportResult{
+		Added:   added,
+		Invalid: invalid,
+	})
+}
+
+func (app *App) HandleRemoveFromWhitelist(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	if len(email) == 0 {
+		http.Error(w, "Invalid email", 400)
+		return
+	}
+
+	ctx := r.Context()
+
+	err := app.Users.RemoveWhitelistedEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Email isn't whitelisted", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Couldn't remove from whitelist", 500)
+		fmt.Println(err)
+		return
+	}
 }
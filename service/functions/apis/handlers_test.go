@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newMemoryApp is an App whose handlers go through a MemoryStore, for
+// the handlers that don't need the DB.
+func newMemoryApp() (*App, *MemoryStore) {
+	store := NewMemoryStore()
+	return &App{
+		Users:       store,
+		Hosts:       store,
+		Events:      store,
+		Invitations: store,
+		Emails:      store,
+	}, store
+}
+
+// requestAs is a request from the Auth0 user, as authMiddleware would
+// pass it on.
+func requestAs(auth0Id string, method string, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	return r.WithContext(context.WithValue(r.Context(), auth0IdContextKey,
+		auth0Id))
+}
+
+func createHandlerTestUser(t *testing.T, ctx context.Context, store Store) User {
+	user := GetTestUser()
+	userId, err := store.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.UserId = userId
+	return user
+}
+
+func TestHandleAddParticipantToEvent(t *testing.T) {
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	event := createStoreEvent(t, ctx, store, GetFakeEvent())
+	maxOccupancy := int64(1)
+	_, err := store.UpdateHost(ctx, event.Host.HostId, HostUpdate{
+		MaxOccupancy: &maxOccupancy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := createHandlerTestUser(t, ctx, store)
+	otherUser := createHandlerTestUser(t, ctx, store)
+
+	addParticipant := func(auth0Id string, userId int64) int {
+		response := httptest.NewRecorder()
+		app.EventHandler(response, requestAs(auth0Id, "POST", fmt.Sprintf(
+			"/events/add-participant/?eventId=%d&userId=%d",
+			event.EventId, userId), nil))
+		return response.Code
+	}
+
+	if code := addParticipant(otherUser.Auth0Id, user.UserId); code != 403 {
+		t.Errorf("Expected adding someone else to be forbidden, got %d",
+			code)
+	}
+	if code := addParticipant(user.Auth0Id, user.UserId); code != 200 {
+		t.Errorf("Expected the user to be added, got %d", code)
+	}
+	if code := addParticipant(otherUser.Auth0Id, otherUser.UserId); code != 409 {
+		t.Errorf("Expected the full event to be a conflict, got %d", code)
+	}
+
+	storedEvent, err := store.GetEvent(ctx, event.EventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedEvent.Participants) != 1 || len(storedEvent.Waitlist) != 1 {
+		t.Errorf("Expected one participant and one waitlisted user: %v %v",
+			storedEvent.Participants, storedEvent.Waitlist)
+	}
+}
+
+func TestHandleRemoveParticipantFromEvent(t *testing.T) {
+	os.Setenv("FWF_MAILER", "memory")
+	defer os.Unsetenv("FWF_MAILER")
+
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	event := createStoreEvent(t, ctx, store, GetFakeEvent())
+	maxOccupancy := int64(1)
+	_, err := store.UpdateHost(ctx, event.Host.HostId, HostUpdate{
+		MaxOccupancy: &maxOccupancy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := createHandlerTestUser(t, ctx, store)
+	waitlistedUser := createHandlerTestUser(t, ctx, store)
+	store.AddUserToEvent(ctx, event.EventId, user.UserId)
+	store.AddUserToEvent(ctx, event.EventId, waitlistedUser.UserId)
+
+	response := httptest.NewRecorder()
+	app.EventHandler(response, requestAs(user.Auth0Id, "POST", fmt.Sprintf(
+		"/events/remove-participant/?eventId=%d&userId=%d",
+		event.EventId, user.UserId), nil))
+	if response.Code != 200 {
+		t.Fatalf("Expected the user to be able to leave, got %d",
+			response.Code)
+	}
+
+	templates := map[int64]string{}
+	for _, email := range store.outboundEmails {
+		templates[email.UserId] = email.Template
+	}
+	if len(store.outboundEmails) != 2 ||
+		templates[event.Host.Users[0].UserId] != EMAIL_PARTICIPANT_LEFT ||
+		templates[waitlistedUser.UserId] != EMAIL_WAITLIST_PROMOTED {
+		t.Errorf("Expected the host and the promoted user to be emailed: %v",
+			templates)
+	}
+}
+
+func TestHandleCreateEvent(t *testing.T) {
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	host := createStoreHost(t, ctx, store)
+	createEvent := func() *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		app.EventHandler(response, requestAs(host.Users[0].Auth0Id, "PUT",
+			"/events/", strings.NewReader(fmt.Sprintf(`{
+                          "title": "Potluck",
+                          "happeningAt": "2030-01-01T18:00:00Z",
+                          "host": {"hostId": %d}}`, host.HostId))))
+		return response
+	}
+
+	response := createEvent()
+	if response.Code != 400 ||
+		!strings.Contains(response.Body.String(), ErrNotHostsTurn.Error()) {
+		t.Errorf("Expected a 400 without an invitation, got %d %s",
+			response.Code, response.Body.String())
+	}
+
+	err := store.AddHostInvitations(ctx, Hosts{host})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = createEvent()
+	var event Event
+	if err = json.NewDecoder(response.Body).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != 200 || event.Title != "Potluck" ||
+		event.Host.HostId != host.HostId {
+		t.Errorf("Expected the event to be created, got %d %v",
+			response.Code, event)
+	}
+}
+
+func TestHandleAddUserToHost(t *testing.T) {
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	host := createStoreHost(t, ctx, store)
+	member := host.Users[0]
+	user := createHandlerTestUser(t, ctx, store)
+	other := createHandlerTestUser(t, ctx, store)
+
+	addUser := func(r *http.Request) int {
+		response := httptest.NewRecorder()
+		app.HostHandler(response, r)
+		return response.Code
+	}
+	target := fmt.Sprintf("/hosts/user/?hostId=%d", host.HostId)
+	body := func(userId int64) io.Reader {
+		return strings.NewReader(fmt.Sprintf(`{"userId": %d}`, userId))
+	}
+
+	if code := addUser(requestAs(other.Auth0Id, "POST", target,
+		body(other.UserId))); code != 403 {
+		t.Errorf("Expected a non-member joining the host to be forbidden, got %d",
+			code)
+	}
+	if code := addUser(requestAs(member.Auth0Id, "POST", target,
+		body(user.UserId))); code != 200 {
+		t.Errorf("Expected a member to be able to add a user, got %d", code)
+	}
+
+	r := requestAs(other.Auth0Id, "POST", target, body(other.UserId))
+	r = r.WithContext(context.WithValue(r.Context(), isAdminContextKey, true))
+	if code := addUser(r); code != 200 {
+		t.Errorf("Expected an admin to be able to add a user, got %d", code)
+	}
+}
+
+func TestHandleCreateHost(t *testing.T) {
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	user := createHandlerTestUser(t, ctx, store)
+	other := createHandlerTestUser(t, ctx, store)
+
+	createHost := func(userIds ...int64) int {
+		users := []string{}
+		for _, userId := range userIds {
+			users = append(users, fmt.Sprintf(`{"userId": %d}`, userId))
+		}
+		response := httptest.NewRecorder()
+		app.HostHandler(response, requestAs(other.Auth0Id, "PUT", "/hosts/",
+			strings.NewReader(fmt.Sprintf(`{
+                          "address": "123 Market St",
+                          "city": "Philadelphia",
+                          "state": "PA",
+                          "zipcode": "19147",
+                          "maxOccupancy": 7,
+                          "users": [%s]}`, strings.Join(users, ", ")))))
+		return response.Code
+	}
+
+	if code := createHost(user.UserId); code != 403 {
+		t.Errorf("Expected creating a host for someone else to be forbidden, got %d",
+			code)
+	}
+	if code := createHost(other.UserId, user.UserId); code != 403 {
+		t.Errorf("Expected adding someone else to a new host to be forbidden, got %d",
+			code)
+	}
+	if code := createHost(other.UserId); code != 200 {
+		t.Errorf("Expected creating a host for yourself to work, got %d", code)
+	}
+}
+
+func TestUserEmailHasToBeTokensEmail(t *testing.T) {
+	ctx := context.Background()
+	app, store := newMemoryApp()
+	user := createHandlerTestUser(t, ctx, store)
+
+	requestWithEmail := func(method string, body string) *http.Request {
+		r := requestAs(user.Auth0Id, method, "/users/",
+			strings.NewReader(body))
+		return r.WithContext(context.WithValue(r.Context(), emailContextKey,
+			user.Email))
+	}
+
+	response := httptest.NewRecorder()
+	app.UserHandler(response, requestWithEmail("PUT", fmt.Sprintf(`{
+                          "name": "Mallory",
+                          "email": "whitelisted@example.com",
+                          "auth0Id": "%s"}`, user.Auth0Id)))
+	if response.Code != 403 {
+		t.Errorf("Expected signing up with another email to be forbidden, got %d",
+			response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	app.UserHandler(response, requestWithEmail("PATCH",
+		`{"email": "whitelisted@example.com"}`))
+	if response.Code != 403 {
+		t.Errorf("Expected changing to another email to be forbidden, got %d",
+			response.Code)
+	}
+
+	storedUser, err := store.GetUserByAuth0Id(ctx, user.Auth0Id)
+	if err != nil || storedUser.Email != user.Email {
+		t.Errorf("Expected the user's email not to change: %v %v",
+			storedUser, err)
+	}
+}
+
+func TestHandleCancelEvent(t *testing.T) {
+	os.Setenv("FWF_MAILER", "memory")
+	defer os.Unsetenv("FWF_MAILER")
+
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	event := createStoreEvent(t, ctx, store, GetFakeEvent())
+	user := createHandlerTestUser(t, ctx, store)
+	if _, err := store.AddUserToEvent(ctx, event.EventId, user.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelEvent := func(auth0Id string) int {
+		response := httptest.NewRecorder()
+		app.EventHandler(response, requestAs(auth0Id, "DELETE",
+			fmt.Sprintf("/events/?eventId=%d", event.EventId), nil))
+		return response.Code
+	}
+
+	if code := cancelEvent(user.Auth0Id); code != 403 {
+		t.Errorf("Expected a guest cancelling to be forbidden, got %d", code)
+	}
+	if code := cancelEvent(event.Host.Users[0].Auth0Id); code != 200 {
+		t.Errorf("Expected the host to be able to cancel, got %d", code)
+	}
+	if code := cancelEvent(event.Host.Users[0].Auth0Id); code != 409 {
+		t.Errorf("Expected cancelling twice to be a conflict, got %d", code)
+	}
+
+	if len(store.outboundEmails) != 1 ||
+		store.outboundEmails[0].UserId != user.UserId ||
+		store.outboundEmails[0].Template != EMAIL_EVENT_CANCELLED {
+		t.Errorf("Expected a cancellation email to the guest: %v",
+			store.outboundEmails)
+	}
+}
+
+func TestHandleCantHostEvent(t *testing.T) {
+	os.Setenv("FWF_MAILER", "memory")
+	defer os.Unsetenv("FWF_MAILER")
+
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	host := createStoreHost(t, ctx, store)
+	if err := store.AddHostInvitations(ctx, Hosts{host}); err != nil {
+		t.Fatal(err)
+	}
+
+	cantHost := func() *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		app.EventHandler(response, requestAs(host.Users[0].Auth0Id, "POST",
+			fmt.Sprintf("/events/cant-host/?hostId=%d", host.HostId), nil))
+		return response
+	}
+
+	// There's no one to pass to, but the pass is committed, so the
+	// client mustn't be told to retry it
+	if response := cantHost(); response.Code != 200 {
+		t.Errorf("Expected the pass to succeed, got %d %s", response.Code,
+			response.Body.String())
+	}
+	canCreate, err := store.CanHostCreateEvent(ctx, host.HostId)
+	if err != nil || canCreate {
+		t.Errorf("Expected the host's turn to be passed: %v", err)
+	}
+
+	response := cantHost()
+	if response.Code != 400 ||
+		!strings.Contains(response.Body.String(), ErrNotHostsTurn.Error()) {
+		t.Errorf("Expected passing twice to be a 400, got %d %s",
+			response.Code, response.Body.String())
+	}
+}
+
+func TestHandleSendItsYourTurnEmails(t *testing.T) {
+	os.Setenv("FWF_MAILER", "memory")
+	defer os.Unsetenv("FWF_MAILER")
+
+	ctx := context.Background()
+	app, store := newMemoryApp()
+
+	host := createStoreHost(t, ctx, store)
+	createStoreHost(t, ctx, store)
+
+	response := httptest.NewRecorder()
+	app.AdminHandler(response, requestAs(host.Users[0].Auth0Id, "POST",
+		"/admin/invites/?numHosts=1", nil))
+	if response.Code != 200 {
+		t.Fatalf("Expected the emails to be sent, got %d %s", response.Code,
+			response.Body.String())
+	}
+
+	var results EmailResults
+	if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != EMAIL_SENT {
+		t.Errorf("Expected one email to be sent: %v", results)
+	}
+	pendingHosts, err := store.GetPendingHosts(ctx)
+	if err != nil || len(pendingHosts) != 1 {
+		t.Errorf("Expected one pending host: %v %v", pendingHosts, err)
+	}
+	if len(store.outboundEmails) != 1 ||
+		store.outboundEmails[0].Status != EMAIL_SENT {
+		t.Errorf("Expected the queued email to be marked sent: %v",
+			store.outboundEmails)
+	}
+}
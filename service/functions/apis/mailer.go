@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mailer delivers a raw RFC 5322 message from one address to a list of
+// recipients.
+type Mailer interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// MailerFromEnv builds the mailer named by FWF_MAILER: "smtp" (the
+// default), "file" to drop messages into the FWF_MAIL_DIR maildir, or
+// "memory" to keep them in the process.
+func MailerFromEnv() (Mailer, error) {
+	switch os.Getenv("FWF_MAILER") {
+	case "", "smtp":
+		return SMTPMailerFromEnv()
+	case "file":
+		dir := os.Getenv("FWF_MAIL_DIR")
+		if len(dir) == 0 {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown FWF_MAILER %s",
+			os.Getenv("FWF_MAILER"))
+	}
+}
+
+// SMTP
+
+const (
+	SMTP_TLS_STARTTLS = "starttls"
+	SMTP_TLS_IMPLICIT = "tls"
+	SMTP_TLS_NONE     = "none"
+)
+
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// One of SMTP_TLS_STARTTLS, SMTP_TLS_IMPLICIT or SMTP_TLS_NONE
+	TLSMode string
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); len(value) > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// SMTPMailerFromEnv configures an SMTPMailer from FWF_SMTP_HOST,
+// FWF_SMTP_PORT, FWF_SMTP_TLS, FWF_SMTP_USERNAME and FWF_SMTP_PASSWORD.
+// It defaults to Gmail with the FWF_EMAIL account.
+func SMTPMailerFromEnv() (*SMTPMailer, error) {
+	port, err := strconv.Atoi(envOrDefault("FWF_SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid FWF_SMTP_PORT: %s", err.Error())
+	}
+
+	tlsMode := envOrDefault("FWF_SMTP_TLS", SMTP_TLS_STARTTLS)
+	if tlsMode != SMTP_TLS_STARTTLS &&
+		tlsMode != SMTP_TLS_IMPLICIT &&
+		tlsMode != SMTP_TLS_NONE {
+		return nil, fmt.Errorf("Invalid FWF_SMTP_TLS %s", tlsMode)
+	}
+
+	return &SMTPMailer{
+		Host:     envOrDefault("FWF_SMTP_HOST", "smtp.gmail.com"),
+		Port:     port,
+		Username: envOrDefault("FWF_SMTP_USERNAME", os.Getenv("FWF_EMAIL")),
+		Password: envOrDefault("FWF_SMTP_PASSWORD",
+			os.Getenv("FWF_EMAIL_PASSWORD")),
+		TLSMode: tlsMode,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(from string, to []string, msg []byte) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	var auth smtp.Auth
+	if len(m.Username) > 0 {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	if m.TLSMode == SMTP_TLS_STARTTLS {
+		return smtp.SendMail(addr, auth, from, to, msg)
+	}
+
+	var conn net.Conn
+	var err error
+	if m.TLSMode == SMTP_TLS_IMPLICIT {
+		conn, err = tls.Dial("tcp", addr, &tls.Config{ServerName: m.Host})
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(msg); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// File
+
+// FileMailer delivers messages into a maildir, for reading mail in dev
+// without sending any.
+type FileMailer struct {
+	Dir string
+
+	mu      sync.Mutex
+	counter int
+}
+
+func (m *FileMailer) Send(from string, to []string, msg []byte) error {
+	if len(to) == 0 {
+		return errors.New("No recipients")
+	}
+
+	for _, subdir := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(m.Dir, subdir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.counter++
+	counter := m.counter
+	m.mu.Unlock()
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(),
+		counter, hostname)
+
+	// Maildir delivery: write to tmp, then move into new
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmpPath, msg, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
+
+// Memory
+
+type SentMail struct {
+	From string
+	To   []string
+	Msg  []byte
+}
+
+// MemoryMailer records messages instead of sending them, for tests.
+// Setting Err makes every Send fail with it.
+type MemoryMailer struct {
+	Err error
+
+	mu   sync.Mutex
+	sent []SentMail
+}
+
+func (m *MemoryMailer) Send(from string, to []string, msg []byte) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if len(to) == 0 {
+		return errors.New("No recipients")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentMail{
+		From: from,
+		To:   append([]string{}, to...),
+		Msg:  append([]byte{}, msg...),
+	})
+	return nil
+}
+
+func (m *MemoryMailer) Sent() []SentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentMail{}, m.sent...)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwf-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer := &FileMailer{Dir: dir}
+	msg := []byte("Subject: Hi\r\n\r\nHello\r\n")
+
+	for i := 0; i < 2; i++ {
+		err = mailer.Send("fwf@example.com", []string{"a@example.com"}, msg)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	delivered, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 {
+		t.Fatalf("Expected 2 delivered messages, got %d", len(delivered))
+	}
+
+	contents, err := ioutil.ReadFile(
+		filepath.Join(dir, "new", delivered[0].Name()))
+	if err != nil {
+		t.Error(err)
+	}
+	if string(contents) != string(msg) {
+		t.Errorf("Delivered message doesn't match: %s", contents)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := &MemoryMailer{}
+	msg := []byte("Subject: Hi\r\n\r\nHello\r\n")
+
+	err := mailer.Send("fwf@example.com", []string{"a@example.com"}, msg)
+	if err != nil {
+		t.Error(err)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To[0] != "a@example.com" {
+		t.Errorf("Expected one email to a@example.com: %v", sent)
+	}
+
+	mailer.Err = errors.New("Mail server down")
+	err = mailer.Send("fwf@example.com", []string{"a@example.com"}, msg)
+	if err != mailer.Err {
+		t.Errorf("Expected mailer error, got %v", err)
+	}
+	if len(mailer.Sent()) != 1 {
+		t.Errorf("Failed send shouldn't be recorded: %v", mailer.Sent())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+    "context"
     "os"
     "strconv"
     "encoding/json"
@@ -9,6 +10,7 @@ import (
     "net/http"
     "net/http/httptest"
     "net/url"
+    "time"
 
     "github.com/apex/go-apex"
 )
@@ -18,11 +20,33 @@ var isDeployEnv, _ = strconv.ParseBool(os.Getenv("DEPLOY"))
 
 // Following https://medium.com/capital-one-developers/building-a-serverless-rest-api-in-go-3ffcb549ef2
 func main() {
-    handler := FoodWithFriendsHTTPHandler()
+    // Created once, so warm Lambda invocations reuse the pool
+    app, err := NewApp(DBPoolConfigFromEnv())
+    if err != nil {
+        fmt.Printf("Couldn't open the database: %s\n", err.Error())
+        os.Exit(1)
+    }
+
+    handler := FoodWithFriendsHTTPHandler(app)
     if isDeployEnv {
         // Register the Lambda event handler
         apex.HandleFunc(func(event json.RawMessage,
             ctx  *apex.Context) (interface{}, error) {
+                if IsScheduledEvent(event) {
+                    runCtx, cancel := context.WithTimeout(
+                        context.Background(), SCHEDULED_RUN_TIMEOUT)
+                    defer cancel()
+                    result, err := RunScheduledRotation(runCtx,
+                        app.Invitations)
+                    _, deliveryErr := DeliverScheduledEmails(runCtx,
+                        app.Emails)
+                    if deliveryErr != nil {
+                        fmt.Printf("Email delivery failed: %s\n",
+                            deliveryErr.Error())
+                    }
+                    return result, err
+                }
+
                 request, err := ParseLambdaRequest(event)
                 if err != nil {
                     return FormatLambdaError(
@@ -38,6 +62,12 @@ func main() {
             })
     } else {
         fmt.Printf("Running in dev mode")
+        if runRotationLoop {
+            go RunRotationLoop(app.Invitations)
+        }
+        if runEmailQueueLoop {
+            go RunEmailQueueLoop(app.Emails)
+        }
         http.ListenAndServe(":8080", handler)
     }
 }
@@ -45,7 +75,7 @@ func main() {
 func preflightOptionsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Allow", "POST, GET, OPTIONS, PUT, DELETE")
+			w.Header().Set("Allow", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 			return
 		}
 
@@ -56,13 +86,45 @@ func preflightOptionsMiddleware(next http.Handler) http.Handler {
 func allowBasicAccessHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// deadlineMiddleware gives the request's database work until timeout.
+// Queries are cancelled once it passes, or once the client goes away,
+// and the handler's error response becomes a 504 or 503.
+func deadlineMiddleware(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(deadlineWriter{w, ctx}, r.WithContext(ctx))
+	})
+}
+
+// deadlineWriter replaces the status of an error response written after
+// the request's context is done, since the error is most likely the
+// cancelled query rather than whatever the handler reported.
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (w deadlineWriter) WriteHeader(status int) {
+	if status >= 400 {
+		switch w.ctx.Err() {
+		case context.DeadlineExceeded:
+			status = http.StatusGatewayTimeout
+		case context.Canceled:
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
 func logRequestMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !isDeployEnv {
@@ -80,18 +142,33 @@ func foodWithFriendsMiddleware(next http.Handler) http.Handler {
 				authMiddleware(next))))
 }
 
-func FoodWithFriendsHTTPHandler() http.Handler {
+// calendarFeedMiddleware is foodWithFriendsMiddleware without auth,
+// for calendar apps that can't log in
+func calendarFeedMiddleware(next http.Handler) http.Handler {
+	return logRequestMiddleware(
+		allowBasicAccessHeadersMiddleware(
+			preflightOptionsMiddleware(next)))
+}
+
+func FoodWithFriendsHTTPHandler(app *App) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.Handle("/events/", foodWithFriendsMiddleware(
-		http.HandlerFunc(EventHandler)))
-	mux.Handle("/users/", foodWithFriendsMiddleware(
-		http.HandlerFunc(UserHandler)))
-	mux.Handle("/hosts/", foodWithFriendsMiddleware(
-		http.HandlerFunc(HostHandler)))
-	mux.Handle("/admin/", foodWithFriendsMiddleware(
-		canSendInvitesMiddleware(
-			http.HandlerFunc(AdminHandler))))
+	mux.Handle("/events/", deadlineMiddleware(EVENTS_TIMEOUT,
+		foodWithFriendsMiddleware(http.HandlerFunc(app.EventHandler))))
+	mux.Handle("/users/", deadlineMiddleware(USERS_TIMEOUT,
+		foodWithFriendsMiddleware(http.HandlerFunc(app.UserHandler))))
+	mux.Handle("/hosts/", deadlineMiddleware(HOSTS_TIMEOUT,
+		foodWithFriendsMiddleware(http.HandlerFunc(app.HostHandler))))
+	mux.Handle("/calendar/", deadlineMiddleware(CALENDAR_TIMEOUT,
+		calendarFeedMiddleware(
+			http.HandlerFunc(app.CalendarFeedHandler))))
+	mux.Handle("/admin/", deadlineMiddleware(ADMIN_TIMEOUT,
+		foodWithFriendsMiddleware(
+			canSendInvitesMiddleware(
+				http.HandlerFunc(app.AdminHandler)))))
+	mux.Handle("/health", deadlineMiddleware(HEALTH_CHECK_TIMEOUT,
+		logRequestMiddleware(
+			http.HandlerFunc(app.HandleHealthCheck))))
 	return mux
 }
 
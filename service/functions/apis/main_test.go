@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failAfterContext stands in for a handler whose query is cut off: it
+// waits for the request's context and then reports a generic error.
+func failAfterContext(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+	http.Error(w, "Couldn't get event", 400)
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	response := httptest.NewRecorder()
+	deadlineMiddleware(10*time.Millisecond,
+		http.HandlerFunc(failAfterContext)).ServeHTTP(response,
+		httptest.NewRequest("GET", "/events/", nil))
+	if response.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected a 504 after the deadline, got %d", response.Code)
+	}
+
+	// The client going away cancels the request's context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	response = httptest.NewRecorder()
+	deadlineMiddleware(time.Minute,
+		http.HandlerFunc(failAfterContext)).ServeHTTP(response,
+		httptest.NewRequest("GET", "/events/", nil).WithContext(ctx))
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 once cancelled, got %d", response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	deadlineMiddleware(time.Minute, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); !ok {
+				t.Error("Expected the request to have a deadline")
+			}
+			http.Error(w, "Invalid eventId", 400)
+		})).ServeHTTP(response, httptest.NewRequest("GET", "/events/", nil))
+	if response.Code != 400 {
+		t.Errorf("Expected errors in time to keep their status, got %d",
+			response.Code)
+	}
+}
+
+func TestPreflightAllowsEdits(t *testing.T) {
+	app, _ := newMemoryApp()
+	response := httptest.NewRecorder()
+	r := httptest.NewRequest("OPTIONS", "/events/", nil)
+	r.Header.Set("Origin", "https://foodwithfriends.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PATCH")
+	FoodWithFriendsHTTPHandler(app).ServeHTTP(response, r)
+
+	if response.Code != 200 {
+		t.Errorf("Expected the preflight to succeed, got %d", response.Code)
+	}
+	allowed := response.Header().Get("Access-Control-Allow-Methods")
+	if !strings.Contains(allowed, "PATCH") ||
+		!strings.Contains(response.Header().Get("Allow"), "PATCH") {
+		t.Errorf("Expected PATCH to be allowed, got %q", allowed)
+	}
+}
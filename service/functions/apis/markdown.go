@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RenderMarkdown renders the subset of Markdown event descriptions are
+// written in to HTML: paragraphs, headings, lists, block quotes,
+// emphasis, code, and links. All text is escaped and only those tags
+// are produced, so the HTML is safe to show as is. Links are only kept
+// for http, https, and mailto URLs.
+func RenderMarkdown(markdown string) string {
+	lines := strings.Split(
+		strings.Replace(markdown, "\r\n", "\n", -1), "\n")
+
+	var buffer bytes.Buffer
+	for i := 0; i < len(lines); {
+		line := strings.TrimSpace(lines[i])
+
+		switch {
+		case len(line) == 0:
+			i++
+
+		case headingPattern.MatchString(line):
+			match := headingPattern.FindStringSubmatch(line)
+			level := len(match[1]) + 2
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(&buffer, "<h%d>%s</h%d>\n", level,
+				renderInlineMarkdown(match[2]), level)
+			i++
+
+		case unorderedItemPattern.MatchString(line):
+			i = renderMarkdownList(&buffer, lines, i, "ul",
+				unorderedItemPattern)
+
+		case orderedItemPattern.MatchString(line):
+			i = renderMarkdownList(&buffer, lines, i, "ol",
+				orderedItemPattern)
+
+		case strings.HasPrefix(line, ">"):
+			var quoted []string
+			for ; i < len(lines); i++ {
+				line := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(line, ">") {
+					break
+				}
+				quoted = append(quoted,
+					strings.TrimSpace(strings.TrimPrefix(line, ">")))
+			}
+			buffer.WriteString("<blockquote>\n")
+			buffer.WriteString(RenderMarkdown(strings.Join(quoted, "\n")))
+			buffer.WriteString("</blockquote>\n")
+
+		default:
+			var paragraph []string
+			for ; i < len(lines); i++ {
+				line := strings.TrimSpace(lines[i])
+				if len(line) == 0 || startsMarkdownBlock(line) {
+					break
+				}
+				paragraph = append(paragraph, renderInlineMarkdown(line))
+			}
+			buffer.WriteString("<p>" + strings.Join(paragraph, "<br>\n") +
+				"</p>\n")
+		}
+	}
+
+	return buffer.String()
+}
+
+var headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+var unorderedItemPattern = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+var orderedItemPattern = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+func startsMarkdownBlock(line string) bool {
+	return headingPattern.MatchString(line) ||
+		unorderedItemPattern.MatchString(line) ||
+		orderedItemPattern.MatchString(line) ||
+		strings.HasPrefix(line, ">")
+}
+
+// renderMarkdownList renders the list starting at lines[start] and
+// returns the index of the first line after it.
+func renderMarkdownList(buffer *bytes.Buffer, lines []string, start int, tag string, itemPattern *regexp.Regexp) int {
+	buffer.WriteString("<" + tag + ">\n")
+	i := start
+	for ; i < len(lines); i++ {
+		match := itemPattern.FindStringSubmatch(strings.TrimSpace(lines[i]))
+		if match == nil {
+			break
+		}
+		buffer.WriteString("<li>" + renderInlineMarkdown(match[1]) +
+			"</li>\n")
+	}
+	buffer.WriteString("</" + tag + ">\n")
+	return i
+}
+
+const markdownPunctuation = "\\`*_{}[]()#+-.!>"
+
+func isSafeLink(link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	return scheme == "http" || scheme == "https" || scheme == "mailto"
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' ||
+		b >= 'A' && b <= 'Z'
+}
+
+// renderInlineMarkdown renders emphasis, code, and links in a line of
+// text, escaping everything else.
+func renderInlineMarkdown(text string) string {
+	var buffer bytes.Buffer
+	var plain bytes.Buffer
+	flush := func() {
+		buffer.WriteString(html.EscapeString(plain.String()))
+		plain.Reset()
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		// Backslash escapes
+		if rest[0] == '\\' && len(rest) > 1 &&
+			strings.IndexByte(markdownPunctuation, rest[1]) >= 0 {
+			plain.WriteByte(rest[1])
+			i += 2
+			continue
+		}
+
+		if rest[0] == '`' {
+			if end := strings.IndexByte(rest[1:], '`'); end >= 0 {
+				flush()
+				buffer.WriteString("<code>" +
+					html.EscapeString(rest[1:end+1]) + "</code>")
+				i += end + 2
+				continue
+			}
+		}
+
+		if strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__") {
+			delimiter := rest[:2]
+			if end := strings.Index(rest[2:], delimiter); end > 0 {
+				flush()
+				buffer.WriteString("<strong>" +
+					renderInlineMarkdown(rest[2:end+2]) + "</strong>")
+				i += end + 4
+				continue
+			}
+		}
+
+		// Underscores inside words, like snake_case, aren't emphasis
+		if rest[0] == '*' || rest[0] == '_' &&
+			(i == 0 || !isWordByte(text[i-1])) {
+			delimiter := rest[:1]
+			if end := strings.Index(rest[1:], delimiter); end > 0 &&
+				rest[1] != ' ' {
+				flush()
+				buffer.WriteString("<em>" +
+					renderInlineMarkdown(rest[1:end+1]) + "</em>")
+				i += end + 2
+				continue
+			}
+		}
+
+		if rest[0] == '[' {
+			if match := linkPattern.FindStringSubmatch(rest); match != nil {
+				flush()
+				label := renderInlineMarkdown(match[1])
+				if isSafeLink(match[2]) {
+					buffer.WriteString("<a href=\"" +
+						html.EscapeString(match[2]) +
+						"\" rel=\"nofollow noopener\">" + label + "</a>")
+				} else {
+					buffer.WriteString(label)
+				}
+				i += len(match[0])
+				continue
+			}
+		}
+
+		plain.WriteByte(rest[0])
+		i++
+	}
+	flush()
+
+	return buffer.String()
+}
+
+var linkPattern = regexp.MustCompile(`^\[([^\]]*)\]\(\s*([^\s)]+)\s*\)`)
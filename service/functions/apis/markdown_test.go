@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	cases := []struct {
+		markdown string
+		html     string
+	}{
+		{"Bring a **big** appetite\nand *a fork*",
+			"<p>Bring a <strong>big</strong> appetite<br>\nand <em>a fork</em></p>\n"},
+		{"# Menu\n\n- soup\n- `bread`\n\n1. eat\n2. nap",
+			"<h3>Menu</h3>\n<ul>\n<li>soup</li>\n<li><code>bread</code></li>\n</ul>\n" +
+				"<ol>\n<li>eat</li>\n<li>nap</li>\n</ol>\n"},
+		{"> parking's\n> out back",
+			"<blockquote>\n<p>parking&#39;s<br>\nout back</p>\n</blockquote>\n"},
+		{"See [the map](https://example.com/map?a=1&b=2)",
+			"<p>See <a href=\"https://example.com/map?a=1&amp;b=2\" rel=\"nofollow noopener\">the map</a></p>\n"},
+		{"snake_case_name and 2 * 3 * 4",
+			"<p>snake_case_name and 2 * 3 * 4</p>\n"},
+		{"\\*not emphasis\\*", "<p>*not emphasis*</p>\n"},
+	}
+
+	for _, c := range cases {
+		if actual := RenderMarkdown(c.markdown); actual != c.html {
+			t.Errorf("Rendering %q\nexpected %q\ngot      %q",
+				c.markdown, c.html, actual)
+		}
+	}
+}
+
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	hostile := []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[click](javascript:alert(1))",
+		"[click](JAVASCRIPT:alert(1))",
+		"[click](data:text/html;base64,PHNjcmlwdD4=)",
+		"**<b onmouseover=alert(1)>**",
+		"`<script>`",
+		"[x](https://example.com/\"onmouseover=\"alert(1))",
+	}
+
+	for _, markdown := range hostile {
+		rendered := RenderMarkdown(markdown)
+		for _, bad := range []string{"<script", "<img", "<b ",
+			"javascript:", "JAVASCRIPT:", "data:", "\"onmouseover"} {
+			if strings.Contains(rendered, bad) {
+				t.Errorf("Rendering %q let %q through: %s",
+					markdown, bad, rendered)
+			}
+		}
+	}
+}
@@ -0,0 +1,1519 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps users, hosts, events, invitations and the emails
+// they queue in memory, for tests that don't have Postgres. It behaves
+// like PostgresStore, down to returning sql.ErrNoRows for missing rows
+// and an error where Postgres would have a constraint violation.
+type MemoryStore struct {
+	mu          sync.Mutex
+	lastIds     map[string]int64
+	users       map[int64]User // without HostId
+	hosts       map[int64]Host // without Users
+	hostUsers   []memoryHostUser
+	events      map[int64]*memoryEvent
+	invitations []Invitation // Host only has its HostId
+	// When each invitation's round started, by invitation id
+	invitationRounds map[int64]time.Time
+	// When each invitation's host was reminded, by invitation id
+	invitationReminders map[int64]time.Time
+	calendarTokens      map[int64]string // by user id
+	whitelist           []string
+	outboundEmails      OutboundEmails
+}
+
+type memoryHostUser struct {
+	hostId int64
+	userId int64
+}
+
+type memoryEvent struct {
+	// Without the host, participants, waitlist or filled dish slots
+	event        Event
+	hostId       int64
+	participants []memoryParticipant // in RSVP order
+	waitlist     []int64             // user ids, in the order they joined
+}
+
+type memoryParticipant struct {
+	userId       int64
+	assignedDish string
+	dishClaimed  bool
+	bringing     string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lastIds:             map[string]int64{},
+		users:               map[int64]User{},
+		hosts:               map[int64]Host{},
+		events:              map[int64]*memoryEvent{},
+		invitationRounds:    map[int64]time.Time{},
+		invitationReminders: map[int64]time.Time{},
+		calendarTokens:      map[int64]string{},
+	}
+}
+
+// violates stands in for the error Postgres returns when a write
+// breaks the constraint.
+func violates(constraint string) error {
+	return fmt.Errorf("Violates %s", constraint)
+}
+
+// lock takes the store's lock, unless ctx is done, in which case it
+// fails like a query would.
+func (store *MemoryStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	return nil
+}
+
+func (store *MemoryStore) nextId(table string) int64 {
+	store.lastIds[table]++
+	return store.lastIds[table]
+}
+
+// enqueue queues the emails, like EnqueueOutboundEmails, and returns
+// their ids.
+func (store *MemoryStore) enqueue(emails OutboundEmails) []int64 {
+	ids := []int64{}
+	for _, email := range emails {
+		email.OutboundEmailId = store.nextId("outbound_emails")
+		email.Status = EMAIL_PENDING
+		email.NextAttemptAt = time.Now()
+		store.outboundEmails = append(store.outboundEmails, email)
+		ids = append(ids, email.OutboundEmailId)
+	}
+	return ids
+}
+
+// Users
+
+func (store *MemoryStore) CreateUser(ctx context.Context, user User) (int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mu.Unlock()
+
+	for _, existing := range store.users {
+		if existing.Auth0Id == user.Auth0Id || existing.Email == user.Email {
+			return 0, violates("users' unique auth0_id and email")
+		}
+	}
+
+	// Like the calendar_token default
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return 0, err
+	}
+
+	userId := store.nextId("users")
+	store.calendarTokens[userId] = hex.EncodeToString(token)
+	store.users[userId] = User{
+		UserId:  userId,
+		Name:    user.Name,
+		Email:   user.Email,
+		Auth0Id: user.Auth0Id,
+		DietaryRestrictions: CanonicalizeDietaryRestrictions(
+			user.DietaryRestrictions),
+	}
+	return userId, nil
+}
+
+func (store *MemoryStore) userByAuth0Id(auth0Id string) (User, bool) {
+	for _, user := range store.users {
+		if user.Auth0Id == auth0Id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (store *MemoryStore) GetUserByAuth0Id(ctx context.Context, auth0Id string) (User, error) {
+	if err := store.lock(ctx); err != nil {
+		return User{}, err
+	}
+	defer store.mu.Unlock()
+
+	user, ok := store.userByAuth0Id(auth0Id)
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	for _, hostUser := range store.sortedHostUsers() {
+		if hostUser.userId == user.UserId {
+			user.HostId = hostUser.hostId
+			break
+		}
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) UpdateUser(ctx context.Context, auth0Id string, update UserUpdate) (User, error) {
+	if update.IsEmpty() {
+		return store.GetUserByAuth0Id(ctx, auth0Id)
+	}
+
+	if err := store.lock(ctx); err != nil {
+		return User{}, err
+	}
+	defer store.mu.Unlock()
+
+	user, ok := store.userByAuth0Id(auth0Id)
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Email != nil {
+		for _, existing := range store.users {
+			if existing.UserId != user.UserId &&
+				existing.Email == *update.Email {
+				return User{}, violates("users' unique email")
+			}
+		}
+		user.Email = *update.Email
+	}
+	if update.DietaryRestrictions != nil {
+		user.DietaryRestrictions = CanonicalizeDietaryRestrictions(
+			*update.DietaryRestrictions)
+	}
+
+	store.users[user.UserId] = user
+	return user, nil
+}
+
+func (store *MemoryStore) IsAuth0User(ctx context.Context, userId int64, auth0Id string) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	user, ok := store.users[userId]
+	return ok && user.Auth0Id == auth0Id, nil
+}
+
+func (store *MemoryStore) GetCalendarToken(ctx context.Context, userId int64) (string, error) {
+	if err := store.lock(ctx); err != nil {
+		return "", err
+	}
+	defer store.mu.Unlock()
+
+	calendarToken, ok := store.calendarTokens[userId]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return calendarToken, nil
+}
+
+func (store *MemoryStore) GetUserIdByCalendarToken(ctx context.Context, calendarToken string) (int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mu.Unlock()
+
+	for userId, userToken := range store.calendarTokens {
+		if userToken == calendarToken {
+			return userId, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// Whitelist
+
+// isWhitelistEmail matches emails case-insensitively, like the
+// whitelist's lower(email) index.
+func isWhitelistEmail(whitelisted string, email string) bool {
+	return strings.ToLower(whitelisted) == strings.ToLower(email)
+}
+
+func (store *MemoryStore) IsEmailWhitelisted(ctx context.Context, email string) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	for _, whitelisted := range store.whitelist {
+		if isWhitelistEmail(whitelisted, strings.TrimSpace(email)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *MemoryStore) GetWhitelistedEmails(ctx context.Context) ([]string, error) {
+	if err := store.lock(ctx); err != nil {
+		return []string{}, err
+	}
+	defer store.mu.Unlock()
+
+	emails := append([]string{}, store.whitelist...)
+	sort.SliceStable(emails, func(i, j int) bool {
+		return strings.ToLower(emails[i]) < strings.ToLower(emails[j])
+	})
+	return emails, nil
+}
+
+func (store *MemoryStore) AddWhitelistedEmails(ctx context.Context, emails []string) (int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mu.Unlock()
+
+	var added int64
+	for _, email := range emails {
+		isWhitelisted := false
+		for _, whitelisted := range store.whitelist {
+			isWhitelisted = isWhitelisted || isWhitelistEmail(whitelisted, email)
+		}
+		if !isWhitelisted {
+			store.whitelist = append(store.whitelist, email)
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (store *MemoryStore) RemoveWhitelistedEmail(ctx context.Context, email string) error {
+	if err := store.lock(ctx); err != nil {
+		return err
+	}
+	defer store.mu.Unlock()
+
+	whitelist := []string{}
+	for _, whitelisted := range store.whitelist {
+		if !isWhitelistEmail(whitelisted, strings.TrimSpace(email)) {
+			whitelist = append(whitelist, whitelisted)
+		}
+	}
+	if len(whitelist) == len(store.whitelist) {
+		return sql.ErrNoRows
+	}
+	store.whitelist = whitelist
+	return nil
+}
+
+// Hosts
+
+func (store *MemoryStore) CreateHost(ctx context.Context, host Host) (int64, error) {
+	if len(host.Users) == 0 {
+		return 0, ErrHostWithoutUsers
+	}
+
+	if err := store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mu.Unlock()
+
+	for i, user := range host.Users {
+		if _, ok := store.users[user.UserId]; !ok {
+			return 0, violates("host_users' user_id foreign key")
+		}
+		for _, other := range host.Users[:i] {
+			if other.UserId == user.UserId {
+				return 0, violates("host_users' unique_pair")
+			}
+		}
+	}
+
+	if len(host.Timezone) == 0 {
+		host.Timezone = TimezoneForState(host.State)
+	}
+	host.HostId = store.nextId("hosts")
+	for _, user := range host.Users {
+		store.hostUsers = append(store.hostUsers,
+			memoryHostUser{host.HostId, user.UserId})
+	}
+	host.Users = nil
+	store.hosts[host.HostId] = host
+	return host.HostId, nil
+}
+
+// sortedHostUsers is the host users ordered by host, like a query with
+// no ORDER BY would usually return them.
+func (store *MemoryStore) sortedHostUsers() []memoryHostUser {
+	hostUsers := append([]memoryHostUser{}, store.hostUsers...)
+	sort.SliceStable(hostUsers, func(i, j int) bool {
+		return hostUsers[i].hostId < hostUsers[j].hostId
+	})
+	return hostUsers
+}
+
+// hostWithUsers is the host as GetHost returns it.
+func (store *MemoryStore) hostWithUsers(hostId int64) (Host, bool) {
+	host, ok := store.hosts[hostId]
+	if !ok {
+		return Host{}, false
+	}
+
+	host.Users = Users{}
+	for _, hostUser := range store.hostUsers {
+		if hostUser.hostId != hostId {
+			continue
+		}
+		user := store.users[hostUser.userId]
+		user.HostId = hostId
+		host.Users = append(host.Users, user)
+	}
+	return host, true
+}
+
+func (store *MemoryStore) isAuth0UserInHost(hostId int64, auth0Id string) bool {
+	for _, hostUser := range store.hostUsers {
+		if hostUser.hostId == hostId &&
+			store.users[hostUser.userId].Auth0Id == auth0Id {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *MemoryStore) GetHost(ctx context.Context, hostId int64) (Host, error) {
+	if err := store.lock(ctx); err != nil {
+		return Host{}, err
+	}
+	defer store.mu.Unlock()
+
+	host, ok := store.hostWithUsers(hostId)
+	if !ok {
+		return Host{}, sql.ErrNoRows
+	}
+	return host, nil
+}
+
+// GetHostsByAddress matches hosts whose address has any of the numbers
+// in address, or every host if it has none, like the SIMILAR TO query.
+func (store *MemoryStore) GetHostsByAddress(ctx context.Context, address string) (Hosts, error) {
+	if err := store.lock(ctx); err != nil {
+		return Hosts{}, err
+	}
+	defer store.mu.Unlock()
+
+	numbers := regexp.MustCompile("[0-9]+").FindAllString(address, -1)
+
+	hosts := Hosts{}
+	for _, hostId := range store.sortedHostIds() {
+		host, _ := store.hostWithUsers(hostId)
+		matches := len(numbers) == 0
+		for _, number := range numbers {
+			matches = matches || strings.Contains(host.Address, number)
+		}
+		if !matches {
+			continue
+		}
+		if len(host.Users) <= 0 {
+			return Hosts{}, errors.New("Didn't find any users for host")
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (store *MemoryStore) sortedHostIds() []int64 {
+	hostIds := []int64{}
+	for hostId := range store.hosts {
+		hostIds = append(hostIds, hostId)
+	}
+	sort.Slice(hostIds, func(i, j int) bool {
+		return hostIds[i] < hostIds[j]
+	})
+	return hostIds
+}
+
+func (store *MemoryStore) AddUserToHost(ctx context.Context, hostId int64, userId int64) (Host, error) {
+	if err := store.lock(ctx); err != nil {
+		return Host{}, err
+	}
+	defer store.mu.Unlock()
+
+	if _, ok := store.hosts[hostId]; !ok {
+		return Host{}, violates("host_users' host_id foreign key")
+	}
+	if _, ok := store.users[userId]; !ok {
+		return Host{}, violates("host_users' user_id foreign key")
+	}
+	for _, hostUser := range store.hostUsers {
+		if hostUser.hostId == hostId && hostUser.userId == userId {
+			return Host{}, violates("host_users' unique_pair")
+		}
+	}
+
+	store.hostUsers = append(store.hostUsers,
+		memoryHostUser{hostId, userId})
+	host, _ := store.hostWithUsers(hostId)
+	return host, nil
+}
+
+func (store *MemoryStore) UpdateHost(ctx context.Context, hostId int64, update HostUpdate) (Host, error) {
+	if err := store.lock(ctx); err != nil {
+		return Host{}, err
+	}
+	defer store.mu.Unlock()
+
+	host, ok := store.hosts[hostId]
+	if !ok {
+		return Host{}, sql.ErrNoRows
+	}
+
+	if update.Address != nil {
+		host.Address = *update.Address
+	}
+	if update.City != nil {
+		host.City = *update.City
+	}
+	if update.State != nil {
+		host.State = *update.State
+	}
+	if update.Zipcode != nil {
+		host.Zipcode = *update.Zipcode
+	}
+	if update.MaxOccupancy != nil {
+		host.MaxOccupancy = *update.MaxOccupancy
+	}
+	if update.Timezone != nil {
+		host.Timezone = *update.Timezone
+		if len(host.Timezone) == 0 {
+			host.Timezone = TimezoneForState(host.State)
+		}
+	}
+
+	store.hosts[hostId] = host
+	host, _ = store.hostWithUsers(hostId)
+	return host, nil
+}
+
+func (store *MemoryStore) IsAuth0UserInHost(ctx context.Context, hostId int64, auth0Id string) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	return store.isAuth0UserInHost(hostId, auth0Id), nil
+}
+
+// Events
+
+func (store *MemoryStore) CreateInvitedEvent(ctx context.Context, event Event) (int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mu.Unlock()
+
+	hostId := event.Host.HostId
+	if !store.hasPendingInvitation(hostId) {
+		return 0, ErrNotHostsTurn
+	}
+
+	// Postgres keeps microseconds
+	happeningAt := event.HappeningAt.Round(time.Microsecond)
+	endsAt := EventEndsAt(event)
+	if endsAt != nil {
+		rounded := endsAt.Round(time.Microsecond)
+		if !rounded.After(happeningAt) {
+			return 0, violates("events_ends_after_start")
+		}
+		endsAt = &rounded
+	}
+
+	timezone := event.Timezone
+	if len(timezone) == 0 {
+		timezone = store.hosts[hostId].Timezone
+	}
+
+	dishSlots := event.DishSlots
+	if len(dishSlots) == 0 {
+		dishSlots = DEFAULT_DISH_SLOTS
+	}
+	if err := checkDishSlots(dishSlots); err != nil {
+		return 0, err
+	}
+
+	eventId := store.nextId("events")
+	store.events[eventId] = &memoryEvent{
+		event: Event{
+			EventId:     eventId,
+			Title:       event.Title,
+			Description: event.Description,
+			HappeningAt: happeningAt,
+			EndsAt:      endsAt,
+			Timezone:    timezone,
+			DishSlots:   append(DishSlots{}, dishSlots...),
+			Status:      EVENT_SCHEDULED,
+		},
+		hostId: hostId,
+	}
+
+	store.updateHostInvitations(hostId, EVENT_CREATED)
+	return eventId, nil
+}
+
+// checkDishSlots stands in for event_dish_slots' constraints.
+func checkDishSlots(dishSlots DishSlots) error {
+	names := map[string]bool{}
+	for _, dishSlot := range dishSlots {
+		if names[dishSlot.Name] {
+			return violates("event_dish_slots' unique name")
+		}
+		if dishSlot.TargetCount <= 0 {
+			return violates("event_dish_slots' target_count check")
+		}
+		names[dishSlot.Name] = true
+	}
+	return nil
+}
+
+// copy is a copy of the event that can be changed without changing
+// stored, for writes that may still fail.
+func (stored *memoryEvent) copy() *memoryEvent {
+	copied := *stored
+	copied.event.DishSlots = append(DishSlots{}, stored.event.DishSlots...)
+	copied.participants = append([]memoryParticipant{},
+		stored.participants...)
+	copied.waitlist = append([]int64{}, stored.waitlist...)
+	return &copied
+}
+
+// eventWithDetails is the event as GetEvent returns it.
+func (store *MemoryStore) eventWithDetails(stored *memoryEvent) Event {
+	event := stored.event
+	event.DescriptionHTML = RenderMarkdown(event.Description)
+	event.Host, _ = store.hostWithUsers(stored.hostId)
+
+	event.Participants = Users{}
+	for _, participant := range stored.participants {
+		user := store.users[participant.userId]
+		event.Participants = append(event.Participants, User{
+			UserId:              user.UserId,
+			Name:                user.Name,
+			Email:               user.Email,
+			DietaryRestrictions: user.DietaryRestrictions,
+			AssignedDish:        participant.assignedDish,
+			Bringing:            participant.bringing,
+		})
+	}
+
+	event.Waitlist = Users{}
+	for _, userId := range stored.waitlist {
+		user := store.users[userId]
+		event.Waitlist = append(event.Waitlist, User{
+			UserId:              user.UserId,
+			Name:                user.Name,
+			Email:               user.Email,
+			DietaryRestrictions: user.DietaryRestrictions,
+		})
+	}
+
+	event.DishSlots = DishSlots{}
+	for _, dishSlot := range stored.event.DishSlots {
+		dishSlot.Filled = stored.filled(dishSlot.Name)
+		event.DishSlots = append(event.DishSlots, dishSlot)
+	}
+
+	return LocalizeEvent(event)
+}
+
+func (stored *memoryEvent) filled(dish string) int64 {
+	var filled int64
+	for _, participant := range stored.participants {
+		if participant.assignedDish == dish {
+			filled++
+		}
+	}
+	return filled
+}
+
+// leastFilledDish is the dish slot with the lowest ratio of assigned
+// participants to target count, the earliest on a tie, like
+// leastFilledDishSlotQuery.
+func (stored *memoryEvent) leastFilledDish() string {
+	dish := ""
+	lowest := 0.0
+	for _, dishSlot := range stored.event.DishSlots {
+		ratio := float64(stored.filled(dishSlot.Name)) /
+			float64(dishSlot.TargetCount)
+		if dish == "" || ratio < lowest {
+			dish = dishSlot.Name
+			lowest = ratio
+		}
+	}
+	return dish
+}
+
+// rebalanceDishes reassigns the dishes participants didn't claim, in
+// RSVP order, like rebalanceDishes.
+func (stored *memoryEvent) rebalanceDishes() {
+	for i := range stored.participants {
+		if !stored.participants[i].dishClaimed {
+			stored.participants[i].assignedDish = ""
+		}
+	}
+	for i := range stored.participants {
+		if !stored.participants[i].dishClaimed {
+			stored.participants[i].assignedDish = stored.leastFilledDish()
+		}
+	}
+}
+
+func (stored *memoryEvent) hasDishSlot(dish string) bool {
+	for _, dishSlot := range stored.event.DishSlots {
+		if dishSlot.Name == dish {
+			return true
+		}
+	}
+	return false
+}
+
+func (stored *memoryEvent) participantIndex(userId int64) int {
+	for i, participant := range stored.participants {
+		if participant.userId == userId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (store *MemoryStore) GetEvent(ctx context.Context, eventId int64) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, ok := store.events[eventId]
+	if !ok {
+		return Event{}, sql.ErrNoRows
+	}
+	return store.eventWithDetails(stored), nil
+}
+
+// filterEvents returns the events include picks, by event id.
+func (store *MemoryStore) filterEvents(include func(stored *memoryEvent) bool) Events {
+	eventIds := []int64{}
+	for eventId := range store.events {
+		eventIds = append(eventIds, eventId)
+	}
+	sort.Slice(eventIds, func(i, j int) bool {
+		return eventIds[i] < eventIds[j]
+	})
+
+	events := Events{}
+	for _, eventId := range eventIds {
+		if stored := store.events[eventId]; include(stored) {
+			events = append(events, store.eventWithDetails(stored))
+		}
+	}
+	return events
+}
+
+func (store *MemoryStore) GetCurrentEvents(ctx context.Context) (Events, error) {
+	if err := store.lock(ctx); err != nil {
+		return Events{}, err
+	}
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	return store.filterEvents(func(stored *memoryEvent) bool {
+		return !stored.event.HappeningAt.Before(now) &&
+			stored.event.Status != EVENT_CANCELLED
+	}), nil
+}
+
+// eventsForUser returns the events the user is going to or hosting,
+// sorted by when they happen.
+func (store *MemoryStore) eventsForUser(userId int64, include func(happeningAt time.Time) bool, newestFirst bool) Events {
+	events := store.filterEvents(func(stored *memoryEvent) bool {
+		if !include(stored.event.HappeningAt) {
+			return false
+		}
+		if stored.participantIndex(userId) >= 0 {
+			return true
+		}
+		for _, hostUser := range store.hostUsers {
+			if hostUser.hostId == stored.hostId &&
+				hostUser.userId == userId {
+				return true
+			}
+		}
+		return false
+	})
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if newestFirst {
+			return events[i].HappeningAt.After(events[j].HappeningAt)
+		}
+		return events[i].HappeningAt.Before(events[j].HappeningAt)
+	})
+	return events
+}
+
+func (store *MemoryStore) GetPastEventsForUser(ctx context.Context, userId int64) (Events, error) {
+	if err := store.lock(ctx); err != nil {
+		return Events{}, err
+	}
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	return store.eventsForUser(userId, func(happeningAt time.Time) bool {
+		return happeningAt.Before(now)
+	}, true), nil
+}
+
+func (store *MemoryStore) GetUpcomingEventsForUser(ctx context.Context, userId int64) (Events, error) {
+	if err := store.lock(ctx); err != nil {
+		return Events{}, err
+	}
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	return store.eventsForUser(userId, func(happeningAt time.Time) bool {
+		return !happeningAt.Before(now)
+	}, false), nil
+}
+
+// participantsEvent is the event participants are changing, or
+// sql.ErrNoRows or ErrEventCancelled, like lockEventForParticipants.
+func (store *MemoryStore) participantsEvent(eventId int64) (*memoryEvent, error) {
+	stored, ok := store.events[eventId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if stored.event.Status == EVENT_CANCELLED {
+		return nil, ErrEventCancelled
+	}
+	return stored, nil
+}
+
+func (store *MemoryStore) AddUserToEvent(ctx context.Context, eventId int64, userId int64) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, err := store.participantsEvent(eventId)
+	if err != nil {
+		return Event{}, err
+	}
+	if _, ok := store.users[userId]; !ok {
+		return Event{}, violates("event_users' user_id foreign key")
+	}
+
+	isParticipant := stored.participantIndex(userId) >= 0
+	if int64(len(stored.participants)) >= store.hosts[stored.hostId].MaxOccupancy {
+		isWaitlisted := false
+		for _, waitlisted := range stored.waitlist {
+			isWaitlisted = isWaitlisted || waitlisted == userId
+		}
+		if !isParticipant && !isWaitlisted {
+			stored.waitlist = append(stored.waitlist, userId)
+		}
+		return Event{}, ErrEventFull
+	}
+
+	if isParticipant {
+		return Event{}, violates("event_users' unique_event_user_pair")
+	}
+	stored.participants = append(stored.participants, memoryParticipant{
+		userId:       userId,
+		assignedDish: stored.leastFilledDish(),
+	})
+	return store.eventWithDetails(stored), nil
+}
+
+func (store *MemoryStore) ChangeParticipantDish(ctx context.Context, eventId int64, userId int64, change DishChange) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, err := store.participantsEvent(eventId)
+	if err != nil {
+		return Event{}, err
+	}
+	i := stored.participantIndex(userId)
+	if i < 0 {
+		return Event{}, sql.ErrNoRows
+	}
+
+	if change.TradeWithUserId != 0 {
+		j := stored.participantIndex(change.TradeWithUserId)
+		if j < 0 {
+			return Event{}, sql.ErrNoRows
+		}
+		participants := stored.participants
+		participants[i].assignedDish, participants[j].assignedDish =
+			participants[j].assignedDish, participants[i].assignedDish
+		participants[i].dishClaimed = true
+		participants[j].dishClaimed = true
+	} else if change.AssignedDish != "" &&
+		change.AssignedDish != stored.participants[i].assignedDish {
+		var dishSlot *DishSlot
+		for k := range stored.event.DishSlots {
+			if stored.event.DishSlots[k].Name == change.AssignedDish {
+				dishSlot = &stored.event.DishSlots[k]
+			}
+		}
+		if dishSlot == nil {
+			return Event{}, ErrUnknownDishSlot
+		}
+		if stored.filled(dishSlot.Name) >= dishSlot.TargetCount {
+			return Event{}, ErrDishSlotFull
+		}
+		stored.participants[i].assignedDish = change.AssignedDish
+		stored.participants[i].dishClaimed = true
+	}
+
+	if change.Bringing != nil {
+		stored.participants[i].bringing = *change.Bringing
+	}
+
+	return store.eventWithDetails(stored), nil
+}
+
+func (store *MemoryStore) RemoveUserFromEvent(ctx context.Context, emailer *Emailer, eventId int64, userId int64) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, err := store.participantsEvent(eventId)
+	if err != nil {
+		return Event{}, err
+	}
+
+	updated := stored.copy()
+	emails := OutboundEmails{}
+	if i := updated.participantIndex(userId); i >= 0 {
+		if emailer != nil {
+			// From before they left, with the dish they were bringing
+			event := store.eventWithDetails(stored)
+			emails, err = participantLeftEmails(emailer, event,
+				event.Participants[i])
+			if err != nil {
+				return Event{}, err
+			}
+		}
+		updated.participants = append(updated.participants[:i],
+			updated.participants[i+1:]...)
+		updated.rebalanceDishes()
+	} else {
+		waitlist := []int64{}
+		for _, waitlisted := range updated.waitlist {
+			if waitlisted != userId {
+				waitlist = append(waitlist, waitlisted)
+			}
+		}
+		if len(waitlist) == len(updated.waitlist) {
+			return Event{}, sql.ErrNoRows
+		}
+		updated.waitlist = waitlist
+	}
+
+	// Fill the open spots from the waitlist, like PromoteFromWaitlist
+	maxOccupancy := store.hosts[updated.hostId].MaxOccupancy
+	promoted := Users{}
+	for len(updated.waitlist) > 0 &&
+		int64(len(updated.participants)) < maxOccupancy {
+		user := store.users[updated.waitlist[0]]
+		promoted = append(promoted, User{
+			UserId:              user.UserId,
+			Name:                user.Name,
+			Email:               user.Email,
+			DietaryRestrictions: user.DietaryRestrictions,
+		})
+		updated.participants = append(updated.participants,
+			memoryParticipant{
+				userId:       updated.waitlist[0],
+				assignedDish: updated.leastFilledDish(),
+			})
+		updated.waitlist = updated.waitlist[1:]
+	}
+	if emailer != nil && len(promoted) > 0 {
+		promotedEmails, err := waitlistPromotedEmails(emailer,
+			store.eventWithDetails(stored), promoted)
+		if err != nil {
+			return Event{}, err
+		}
+		emails = append(emails, promotedEmails...)
+	}
+
+	store.events[eventId] = updated
+	store.enqueue(emails)
+	return store.eventWithDetails(updated), nil
+}
+
+func (store *MemoryStore) UpdateEvent(ctx context.Context, emailer *Emailer, eventId int64, update EventUpdate) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, ok := store.events[eventId]
+	if !ok {
+		return Event{}, sql.ErrNoRows
+	}
+	if update.IsEmpty() {
+		return store.eventWithDetails(stored), nil
+	}
+
+	updated := stored.copy()
+	event := &updated.event
+	if update.Title != nil {
+		event.Title = *update.Title
+	}
+	if update.Description != nil {
+		event.Description = *update.Description
+	}
+	if update.HostId != nil {
+		if _, ok := store.hosts[*update.HostId]; !ok {
+			return Event{}, violates("events' host_id foreign key")
+		}
+		updated.hostId = *update.HostId
+	}
+	if update.Timezone != nil {
+		event.Timezone = *update.Timezone
+		if len(event.Timezone) == 0 {
+			event.Timezone = store.hosts[updated.hostId].Timezone
+		}
+	}
+
+	// The end is worked out from the event before the update, like the
+	// SET expressions
+	happeningAt := event.HappeningAt
+	if update.HappeningAt != nil {
+		happeningAt = update.HappeningAt.Round(time.Microsecond)
+	}
+	if update.EndsAt != nil && update.EndsAt.IsZero() {
+		event.EndsAt = nil
+	} else if update.EndsAt != nil {
+		endsAt := update.EndsAt.Round(time.Microsecond)
+		event.EndsAt = &endsAt
+	} else if update.DurationMinutes != nil && *update.DurationMinutes == 0 {
+		event.EndsAt = nil
+	} else if update.DurationMinutes != nil {
+		event.EndsAt = EventEndsAt(Event{
+			HappeningAt:     happeningAt,
+			DurationMinutes: *update.DurationMinutes,
+		})
+	} else if update.HappeningAt != nil && event.EndsAt != nil {
+		endsAt := happeningAt.Add(event.EndsAt.Sub(event.HappeningAt))
+		event.EndsAt = &endsAt
+	}
+	event.HappeningAt = happeningAt
+	if event.EndsAt != nil && !event.EndsAt.After(event.HappeningAt) {
+		return Event{}, violates("events_ends_after_start")
+	}
+	event.Sequence++
+
+	if update.DishSlots != nil {
+		dishSlots := *update.DishSlots
+		if len(dishSlots) == 0 {
+			dishSlots = DEFAULT_DISH_SLOTS
+		}
+		if err := checkDishSlots(dishSlots); err != nil {
+			return Event{}, err
+		}
+		event.DishSlots = append(DishSlots{}, dishSlots...)
+
+		// Like reassignRemovedDishes
+		for i, participant := range updated.participants {
+			if !updated.hasDishSlot(participant.assignedDish) {
+				updated.participants[i].dishClaimed = false
+			}
+		}
+		updated.rebalanceDishes()
+	}
+
+	emails := OutboundEmails{}
+	if emailer != nil {
+		// UpdateEvent emails the participants from before the update
+		emailed := store.eventWithDetails(updated)
+		emailed.Participants = store.eventWithDetails(stored).Participants
+		var err error
+		emails, err = eventUpdateEmails(emailer, emailed)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+
+	store.events[eventId] = updated
+	store.enqueue(emails)
+	return store.eventWithDetails(updated), nil
+}
+
+func (store *MemoryStore) CancelEvent(ctx context.Context, emailer *Emailer, eventId int64) (Event, error) {
+	if err := store.lock(ctx); err != nil {
+		return Event{}, err
+	}
+	defer store.mu.Unlock()
+
+	stored, ok := store.events[eventId]
+	if !ok {
+		return Event{}, sql.ErrNoRows
+	}
+	if stored.event.Status == EVENT_CANCELLED {
+		return Event{}, ErrEventCancelled
+	}
+
+	updated := stored.copy()
+	updated.event.Status = EVENT_CANCELLED
+	updated.event.Sequence++
+	event := store.eventWithDetails(updated)
+	emails, err := eventCancelledEmails(emailer, event)
+	if err != nil {
+		return Event{}, err
+	}
+
+	store.events[eventId] = updated
+
+	// Only the host's latest invitation, like CancelEvent
+	latest := -1
+	for i, invitation := range store.invitations {
+		if invitation.Host.HostId == updated.hostId {
+			latest = i
+		}
+	}
+	if latest >= 0 {
+		invitation := &store.invitations[latest]
+		roundStartedAt := store.invitationRounds[invitation.InvitationId]
+		if invitation.Status == EVENT_CREATED &&
+			roundStartedAt.Equal(store.currentRound()) {
+			now := time.Now()
+			invitation.Status = PENDING
+			invitation.SentAt = now
+			invitation.UpdatedAt = now
+			delete(store.invitationReminders, invitation.InvitationId)
+		}
+	}
+	store.enqueue(emails)
+	return event, nil
+}
+
+func (store *MemoryStore) IsAuth0UserHostOfEvent(ctx context.Context, eventId int64, auth0Id string) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	stored, ok := store.events[eventId]
+	return ok && store.isAuth0UserInHost(stored.hostId, auth0Id), nil
+}
+
+// Invitations
+
+func (store *MemoryStore) AddHostInvitations(ctx context.Context, hosts Hosts) error {
+	if err := store.lock(ctx); err != nil {
+		return err
+	}
+	defer store.mu.Unlock()
+
+	for _, host := range hosts {
+		if _, ok := store.hosts[host.HostId]; !ok {
+			return violates("event_creation_invites' host_id foreign key")
+		}
+	}
+
+	store.addHostInvitations(hosts, time.Now())
+	return nil
+}
+
+// addHostInvitations invites the hosts, in the round started at
+// roundStartedAt.
+func (store *MemoryStore) addHostInvitations(hosts Hosts, roundStartedAt time.Time) {
+	now := time.Now()
+	for _, host := range hosts {
+		invitationId := store.nextId("event_creation_invites")
+		store.invitations = append(store.invitations, Invitation{
+			InvitationId: invitationId,
+			Host:         Host{HostId: host.HostId},
+			Status:       PENDING,
+			SentAt:       now,
+			UpdatedAt:    now,
+		})
+		store.invitationRounds[invitationId] = roundStartedAt
+	}
+}
+
+// currentRound is when the latest round of invitations started, or the
+// zero time if there hasn't been one.
+func (store *MemoryStore) currentRound() time.Time {
+	var currentRound time.Time
+	for _, roundStartedAt := range store.invitationRounds {
+		if roundStartedAt.After(currentRound) {
+			currentRound = roundStartedAt
+		}
+	}
+	return currentRound
+}
+
+// nextHosts picks the hosts to invite like getNextHosts: hosts that
+// haven't hosted yet, then the ones who hosted longest ago. Hosts with
+// a pending invitation are left out, and so are hosts who passed in the
+// current round if isReplacement is set.
+func (store *MemoryStore) nextHosts(numHosts int, isReplacement bool) Hosts {
+	lastEventIds := map[int64]int64{} // by host id
+	for eventId, stored := range store.events {
+		if stored.event.Status != EVENT_CANCELLED &&
+			eventId > lastEventIds[stored.hostId] {
+			lastEventIds[stored.hostId] = eventId
+		}
+	}
+
+	currentRound := store.currentRound()
+	isLeftOut := map[int64]bool{}
+	for _, invitation := range store.invitations {
+		isPassedThisRound := invitation.Status == PASS &&
+			store.invitationRounds[invitation.InvitationId].Equal(currentRound)
+		if invitation.Status == PENDING ||
+			(isReplacement && isPassedThisRound) {
+			isLeftOut[invitation.Host.HostId] = true
+		}
+	}
+
+	hostIds := store.sortedHostIds()
+	sort.SliceStable(hostIds, func(i, j int) bool {
+		return lastEventIds[hostIds[i]] < lastEventIds[hostIds[j]]
+	})
+
+	hosts := Hosts{}
+	for _, hostId := range hostIds {
+		if len(hosts) >= numHosts {
+			break
+		}
+		if !isLeftOut[hostId] {
+			host, _ := store.hostWithUsers(hostId)
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func (store *MemoryStore) GetLeastRecentHosts(ctx context.Context, numHosts int) (Hosts, error) {
+	if err := store.lock(ctx); err != nil {
+		return Hosts{}, err
+	}
+	defer store.mu.Unlock()
+
+	return store.nextHosts(numHosts, false), nil
+}
+
+func (store *MemoryStore) SendEmailsToLeastRecentHosts(ctx context.Context, emailer *Emailer, numHosts int) ([]int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return []int64{}, err
+	}
+	defer store.mu.Unlock()
+
+	hosts := store.nextHosts(numHosts, false)
+	if len(hosts) <= 0 {
+		if numHosts > 0 {
+			return []int64{}, ErrNoHostsToInvite
+		}
+		return []int64{}, nil
+	}
+
+	emails, err := yourTurnEmails(emailer, hosts)
+	if err != nil {
+		return []int64{}, err
+	}
+	store.addHostInvitations(hosts, time.Now())
+	return store.enqueue(emails), nil
+}
+
+func (store *MemoryStore) PassHostTurn(ctx context.Context, emailer *Emailer, hostId int64) ([]int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return []int64{}, err
+	}
+	defer store.mu.Unlock()
+
+	if !store.hasPendingInvitation(hostId) {
+		return []int64{}, ErrNotHostsTurn
+	}
+
+	hosts := store.nextHosts(1, true)
+	if len(hosts) <= 0 {
+		store.updateHostInvitations(hostId, PASS)
+		return []int64{}, ErrNoHostsToInvite
+	}
+
+	// Rendered first, so a failure leaves the pass undone too
+	emails, err := yourTurnEmails(emailer, hosts)
+	if err != nil {
+		return []int64{}, err
+	}
+	roundStartedAt := store.currentRound()
+	if roundStartedAt.IsZero() {
+		roundStartedAt = time.Now()
+	}
+	store.updateHostInvitations(hostId, PASS)
+	store.addHostInvitations(hosts, roundStartedAt)
+	return store.enqueue(emails), nil
+}
+
+func (store *MemoryStore) hasPendingInvitation(hostId int64) bool {
+	for _, invitation := range store.invitations {
+		if invitation.Host.HostId == hostId &&
+			invitation.Status == PENDING {
+			return true
+		}
+	}
+	return false
+}
+
+// updateHostInvitations sets the status of the host's open invitations,
+// like UpdateHostInvitation.
+func (store *MemoryStore) updateHostInvitations(hostId int64, status string) {
+	for i, invitation := range store.invitations {
+		if invitation.Host.HostId != hostId ||
+			invitation.Status == EVENT_CREATED ||
+			invitation.Status == COMPLETE || invitation.Status == PASS {
+			continue
+		}
+		store.invitations[i].Status = status
+		store.invitations[i].UpdatedAt = time.Now()
+	}
+}
+
+// invitationWithHost is the invitation as GetInvitations returns it.
+func (store *MemoryStore) invitationWithHost(invitation Invitation) Invitation {
+	invitation.Host, _ = store.hostWithUsers(invitation.Host.HostId)
+	return invitation
+}
+
+func (store *MemoryStore) CanHostCreateEvent(ctx context.Context, hostId int64) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	return store.hasPendingInvitation(hostId), nil
+}
+
+func (store *MemoryStore) GetInvitations(ctx context.Context) (Invitations, error) {
+	if err := store.lock(ctx); err != nil {
+		return Invitations{}, err
+	}
+	defer store.mu.Unlock()
+
+	invitations := Invitations{}
+	for _, invitation := range store.invitations {
+		invitations = append(invitations,
+			store.invitationWithHost(invitation))
+	}
+	sort.SliceStable(invitations, func(i, j int) bool {
+		if !invitations[i].SentAt.Equal(invitations[j].SentAt) {
+			return invitations[i].SentAt.After(invitations[j].SentAt)
+		}
+		return invitations[i].InvitationId > invitations[j].InvitationId
+	})
+	return invitations, nil
+}
+
+func (store *MemoryStore) GetPendingHosts(ctx context.Context) (Hosts, error) {
+	if err := store.lock(ctx); err != nil {
+		return Hosts{}, err
+	}
+	defer store.mu.Unlock()
+
+	hosts := Hosts{}
+	for _, invitation := range store.invitations {
+		if invitation.Status != PENDING {
+			continue
+		}
+		host, _ := store.hostWithUsers(invitation.Host.HostId)
+		if len(host.Users) <= 0 {
+			return Hosts{}, errors.New("Didn't find any users for host")
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (store *MemoryStore) SetInvitationStatus(ctx context.Context, invitationId int64, status string) (Invitation, error) {
+	if err := store.lock(ctx); err != nil {
+		return Invitation{}, err
+	}
+	defer store.mu.Unlock()
+
+	isStatus := false
+	for _, invitationStatus := range INVITATION_STATUSES {
+		isStatus = isStatus || status == invitationStatus
+	}
+	if !isStatus {
+		return Invitation{}, violates("event_creation_status enum")
+	}
+
+	for i, invitation := range store.invitations {
+		if invitation.InvitationId == invitationId {
+			store.invitations[i].Status = status
+			store.invitations[i].UpdatedAt = time.Now()
+			return store.invitationWithHost(store.invitations[i]), nil
+		}
+	}
+	return Invitation{}, sql.ErrNoRows
+}
+
+func (store *MemoryStore) ExpireEventInvitations(ctx context.Context) (Hosts, error) {
+	if err := store.lock(ctx); err != nil {
+		return Hosts{}, err
+	}
+	defer store.mu.Unlock()
+
+	hosts := Hosts{}
+	for i, invitation := range store.invitations {
+		if invitation.Status != EVENT_CREATED {
+			continue
+		}
+		store.invitations[i].Status = COMPLETE
+		store.invitations[i].UpdatedAt = time.Now()
+		host, _ := store.hostWithUsers(invitation.Host.HostId)
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (store *MemoryStore) GetStalePendingInvitations(ctx context.Context, age time.Duration, unremindedOnly bool) (Invitations, error) {
+	if err := store.lock(ctx); err != nil {
+		return Invitations{}, err
+	}
+	defer store.mu.Unlock()
+
+	sentBy := time.Now().Add(-age)
+	invitations := Invitations{}
+	for _, invitation := range store.invitations {
+		_, isReminded := store.invitationReminders[invitation.InvitationId]
+		if invitation.Status != PENDING || invitation.SentAt.After(sentBy) ||
+			(unremindedOnly && isReminded) {
+			continue
+		}
+		invitations = append(invitations,
+			store.invitationWithHost(invitation))
+	}
+	sort.SliceStable(invitations, func(i, j int) bool {
+		if !invitations[i].SentAt.Equal(invitations[j].SentAt) {
+			return invitations[i].SentAt.Before(invitations[j].SentAt)
+		}
+		return invitations[i].InvitationId < invitations[j].InvitationId
+	})
+	return invitations, nil
+}
+
+func (store *MemoryStore) RemindPendingHost(ctx context.Context, emailer *Emailer, invitation Invitation) ([]int64, error) {
+	if err := store.lock(ctx); err != nil {
+		return []int64{}, err
+	}
+	defer store.mu.Unlock()
+
+	emails, err := emailer.render(EMAIL_HOST_REMINDER,
+		EmailData{Host: invitation.Host}, invitation.Host.Users)
+	if err != nil {
+		return []int64{}, err
+	}
+
+	// Like MarkInvitationReminded, a missing invitation isn't an error
+	for _, stored := range store.invitations {
+		if stored.InvitationId == invitation.InvitationId {
+			store.invitationReminders[stored.InvitationId] = time.Now()
+		}
+	}
+	return store.enqueue(emails), nil
+}
+
+func (store *MemoryStore) IsInvitationRoundDue(ctx context.Context, cadence time.Duration) (bool, error) {
+	if err := store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer store.mu.Unlock()
+
+	currentRound := store.currentRound()
+	return currentRound.IsZero() ||
+		!currentRound.After(time.Now().Add(-cadence)), nil
+}
+
+// Outbound emails
+
+func (store *MemoryStore) ClaimOutboundEmails(ctx context.Context, ids []int64, limit int, lease time.Duration) (OutboundEmails, error) {
+	if err := store.lock(ctx); err != nil {
+		return OutboundEmails{}, err
+	}
+	defer store.mu.Unlock()
+
+	isClaimable := map[int64]bool{}
+	for _, id := range ids {
+		isClaimable[id] = true
+	}
+
+	now := time.Now()
+	due := []int{} // indexes into outboundEmails
+	for i, email := range store.outboundEmails {
+		if email.Status == EMAIL_PENDING && !email.NextAttemptAt.After(now) &&
+			(len(ids) == 0 || isClaimable[email.OutboundEmailId]) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return store.outboundEmails[due[i]].NextAttemptAt.Before(
+			store.outboundEmails[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := OutboundEmails{}
+	for _, i := range due {
+		store.outboundEmails[i].Attempts++
+		store.outboundEmails[i].NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, store.outboundEmails[i])
+	}
+	return claimed, nil
+}
+
+func (store *MemoryStore) MarkOutboundEmailSent(ctx context.Context, outboundEmailId int64) error {
+	if err := store.lock(ctx); err != nil {
+		return err
+	}
+	defer store.mu.Unlock()
+
+	for i, email := range store.outboundEmails {
+		if email.OutboundEmailId == outboundEmailId {
+			store.outboundEmails[i].Status = EMAIL_SENT
+			store.outboundEmails[i].LastError = ""
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) MarkOutboundEmailFailed(ctx context.Context, outboundEmailId int64, sendErr string, retryAfter time.Duration) error {
+	if err := store.lock(ctx); err != nil {
+		return err
+	}
+	defer store.mu.Unlock()
+
+	status := EMAIL_PENDING
+	if retryAfter == 0 {
+		status = EMAIL_FAILED
+	}
+
+	for i, email := range store.outboundEmails {
+		if email.OutboundEmailId == outboundEmailId {
+			store.outboundEmails[i].Status = status
+			store.outboundEmails[i].LastError = sendErr
+			store.outboundEmails[i].NextAttemptAt = time.Now().Add(retryAfter)
+		}
+	}
+	return nil
+}
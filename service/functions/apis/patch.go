@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Patch is a JSON Merge Patch (RFC 7396) body for editing a user, host
+// or event. Members that are left out aren't changed, and null clears
+// a member, or resets it to its default if it can't be empty. Members
+// that can't be edited, like participants, are ignored.
+type Patch map[string]json.RawMessage
+
+func DecodePatch(r io.Reader) (Patch, error) {
+	var patch Patch
+	if err := json.NewDecoder(r).Decode(&patch); err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, errors.New("Patch has to be a JSON object")
+	}
+	return patch, nil
+}
+
+// decode decodes the member into field, a pointer to a pointer. The
+// pointer stays nil if the member is absent, and points to a zero value
+// if the member is null.
+func (patch Patch) decode(member string, field interface{}) error {
+	raw, ok := patch[member]
+	if !ok {
+		return nil
+	}
+
+	pointer := reflect.ValueOf(field).Elem()
+	pointer.Set(reflect.New(pointer.Type().Elem()))
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(raw, pointer.Interface()); err != nil {
+		return fmt.Errorf("Invalid %s: %s", member, err)
+	}
+	return nil
+}
+
+func (patch Patch) decodeAll(fields map[string]interface{}) error {
+	for member, field := range fields {
+		if err := patch.decode(member, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Id reads the id of the resource being patched, e.g. eventId.
+func (patch Patch) Id(member string) (int64, error) {
+	var id *int64
+	if err := patch.decode(member, &id); err != nil {
+		return 0, err
+	}
+	if id == nil || *id == 0 {
+		return 0, fmt.Errorf("Missing %s", member)
+	}
+	return *id, nil
+}
+
+// UserUpdate is a partial update of a user. nil fields are left
+// unchanged.
+type UserUpdate struct {
+	Name                *string
+	Email               *string
+	DietaryRestrictions *[]string // empty clears them
+}
+
+func (update UserUpdate) IsEmpty() bool {
+	return update.Name == nil && update.Email == nil &&
+		update.DietaryRestrictions == nil
+}
+
+func (patch Patch) UserUpdate() (UserUpdate, error) {
+	var update UserUpdate
+	err := patch.decodeAll(map[string]interface{}{
+		"name":                &update.Name,
+		"email":               &update.Email,
+		"dietaryRestrictions": &update.DietaryRestrictions,
+	})
+	return update, err
+}
+
+// HostUpdate is a partial update of a host. nil fields are left
+// unchanged.
+type HostUpdate struct {
+	Address      *string
+	City         *string
+	State        *string
+	Zipcode      *string
+	MaxOccupancy *int64
+	Timezone     *string // empty resets it from the state
+}
+
+func (update HostUpdate) IsEmpty() bool {
+	return update.Address == nil && update.City == nil &&
+		update.State == nil && update.Zipcode == nil &&
+		update.MaxOccupancy == nil && update.Timezone == nil
+}
+
+func (patch Patch) HostUpdate() (HostUpdate, error) {
+	var update HostUpdate
+	err := patch.decodeAll(map[string]interface{}{
+		"address":      &update.Address,
+		"city":         &update.City,
+		"state":        &update.State,
+		"zipcode":      &update.Zipcode,
+		"maxOccupancy": &update.MaxOccupancy,
+		"timezone":     &update.Timezone,
+	})
+	return update, err
+}
+
+// EventUpdate is a partial update of an event. nil fields are left
+// unchanged.
+type EventUpdate struct {
+	Title           *string
+	Description     *string // empty clears it
+	HappeningAt     *time.Time
+	EndsAt          *time.Time // zero clears it
+	DurationMinutes *int64     // zero clears the end time
+	Timezone        *string    // empty resets it to the host's
+	HostId          *int64
+	DishSlots       *DishSlots // empty resets them to the defaults
+}
+
+func (update EventUpdate) IsEmpty() bool {
+	return update.Title == nil && update.Description == nil &&
+		update.HappeningAt == nil && update.EndsAt == nil &&
+		update.DurationMinutes == nil && update.Timezone == nil &&
+		update.HostId == nil && update.DishSlots == nil
+}
+
+func (patch Patch) EventUpdate() (EventUpdate, error) {
+	var update EventUpdate
+	var host *Host
+	err := patch.decodeAll(map[string]interface{}{
+		"title":           &update.Title,
+		"description":     &update.Description,
+		"happeningAt":     &update.HappeningAt,
+		"endsAt":          &update.EndsAt,
+		"durationMinutes": &update.DurationMinutes,
+		"timezone":        &update.Timezone,
+		"host":            &host,
+		"dishSlots":       &update.DishSlots,
+	})
+	if host != nil {
+		update.HostId = &host.HostId
+	}
+	return update, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventUpdateFromPatch(t *testing.T) {
+	patch, err := DecodePatch(strings.NewReader(`{
+		"eventId": 7,
+		"title": "Soup night",
+		"description": null,
+		"host": {"hostId": 3, "address": "ignored"},
+		"participants": []
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if eventId, err := patch.Id("eventId"); err != nil || eventId != 7 {
+		t.Errorf("Expected eventId 7, got %d %v", eventId, err)
+	}
+
+	update, err := patch.EventUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Title == nil || *update.Title != "Soup night" {
+		t.Errorf("Expected the title to be set: %v", update.Title)
+	}
+	if update.Description == nil || *update.Description != "" {
+		t.Errorf("Expected null to clear the description: %v",
+			update.Description)
+	}
+	if update.HostId == nil || *update.HostId != 3 {
+		t.Errorf("Expected the host to change: %v", update.HostId)
+	}
+	if update.HappeningAt != nil || update.EndsAt != nil ||
+		update.DishSlots != nil || update.Timezone != nil {
+		t.Errorf("Expected absent members to be left alone: %+v", update)
+	}
+}
+
+func TestEmptyPatch(t *testing.T) {
+	patch, err := DecodePatch(strings.NewReader(`{"hostId": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostUpdate, err := patch.HostUpdate()
+	if err != nil || !hostUpdate.IsEmpty() {
+		t.Errorf("Expected an empty host update: %+v %v", hostUpdate, err)
+	}
+	userUpdate, err := patch.UserUpdate()
+	if err != nil || !userUpdate.IsEmpty() {
+		t.Errorf("Expected an empty user update: %+v %v", userUpdate, err)
+	}
+	if _, err := patch.Id("eventId"); err == nil {
+		t.Error("Expected a missing eventId to be an error")
+	}
+}
+
+func TestInvalidPatch(t *testing.T) {
+	for _, body := range []string{`null`, `[]`, `{"title": 1`} {
+		if _, err := DecodePatch(strings.NewReader(body)); err == nil {
+			t.Errorf("Expected %s to be rejected", body)
+		}
+	}
+
+	patch := Patch{"maxOccupancy": []byte(`"lots"`)}
+	if _, err := patch.HostUpdate(); err == nil ||
+		!strings.Contains(err.Error(), "maxOccupancy") {
+		t.Errorf("Expected a bad maxOccupancy to be rejected: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RotationConfig controls the "your turn to host" rotation. The
+// rotation is meant to be run often (e.g. daily); each run only does
+// what's due.
+type RotationConfig struct {
+	// How long between rounds of invitations
+	Cadence time.Duration
+	// How long a pending host has before they're sent a reminder
+	ReminderAfter time.Duration
+	// How long a pending host has before their turn is passed along
+	PassAfter time.Duration
+	// How many hosts are invited each round
+	HostsPerRound int
+	// How often the dev mode loop runs the rotation
+	CheckInterval time.Duration
+}
+
+type RotationResult struct {
+	Expired  Hosts `json:"expired"`
+	Reminded Hosts `json:"reminded"`
+	Passed   Hosts `json:"passed"`
+	NewRound bool  `json:"newRound"`
+}
+
+var runRotationLoop, _ = strconv.ParseBool(os.Getenv("FWF_ROTATION_LOOP"))
+
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func RotationConfigFromEnv() RotationConfig {
+	day := 24 * time.Hour
+	return RotationConfig{
+		Cadence: time.Duration(
+			envInt("FWF_ROTATION_CADENCE_DAYS", 14)) * day,
+		ReminderAfter: time.Duration(
+			envInt("FWF_ROTATION_REMINDER_DAYS", 3)) * day,
+		PassAfter: time.Duration(
+			envInt("FWF_ROTATION_PASS_DAYS", 7)) * day,
+		HostsPerRound: envInt("FWF_ROTATION_HOSTS_PER_ROUND", 1),
+		CheckInterval: time.Duration(
+			envInt("FWF_ROTATION_CHECK_MINUTES", 60)) * time.Minute,
+	}
+}
+
+// RunRotation passes along the turns of hosts that haven't acted by
+// PassAfter, reminds the ones that haven't acted by ReminderAfter,
+// and, once per Cadence, expires the last round's invitations and
+// invites the next HostsPerRound least recent hosts.
+func RunRotation(ctx context.Context, invitations InvitationStore, emailer *Emailer, config RotationConfig) (RotationResult, error) {
+	result := RotationResult{
+		Expired:  Hosts{},
+		Reminded: Hosts{},
+		Passed:   Hosts{},
+	}
+
+	timedOut, err := invitations.GetStalePendingInvitations(ctx,
+		config.PassAfter, false)
+	if err != nil {
+		return result, err
+	}
+	for _, invitation := range timedOut {
+		// Passes and invites the next host together, like a host
+		// passing themselves
+		_, err = invitations.PassHostTurn(ctx, emailer,
+			invitation.Host.HostId)
+		if err == ErrNotHostsTurn {
+			// They acted on it since it was read
+			continue
+		}
+		if err != nil && err != ErrNoHostsToInvite {
+			return result, err
+		}
+		result.Passed = append(result.Passed, invitation.Host)
+	}
+
+	needReminder, err := invitations.GetStalePendingInvitations(ctx,
+		config.ReminderAfter, true)
+	if err != nil {
+		return result, err
+	}
+	for _, invitation := range needReminder {
+		_, err = invitations.RemindPendingHost(ctx, emailer, invitation)
+		if err != nil {
+			return result, err
+		}
+		result.Reminded = append(result.Reminded, invitation.Host)
+	}
+
+	isRoundDue, err := invitations.IsInvitationRoundDue(ctx, config.Cadence)
+	if err != nil {
+		return result, err
+	}
+	if isRoundDue {
+		result.Expired, err = invitations.ExpireEventInvitations(ctx)
+		if err != nil {
+			return result, err
+		}
+		_, err = invitations.SendEmailsToLeastRecentHosts(ctx, emailer,
+			config.HostsPerRound)
+		if err != nil {
+			return result, err
+		}
+		result.NewRound = true
+	}
+
+	return result, nil
+}
+
+func RunScheduledRotation(ctx context.Context, invitations InvitationStore) (RotationResult, error) {
+	emailer, err := EmailerFromEnv()
+	if err != nil {
+		return RotationResult{}, err
+	}
+
+	return RunRotation(ctx, invitations, emailer, RotationConfigFromEnv())
+}
+
+// RunRotationLoop runs the rotation every CheckInterval, forever. It
+// stands in for the scheduled Lambda trigger in dev mode.
+func RunRotationLoop(invitations InvitationStore) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			SCHEDULED_RUN_TIMEOUT)
+		result, err := RunScheduledRotation(ctx, invitations)
+		cancel()
+		if err != nil {
+			fmt.Printf("Rotation failed: %s\n", err.Error())
+		} else {
+			fmt.Printf("Rotation ran: %+v\n", result)
+		}
+		time.Sleep(RotationConfigFromEnv().CheckInterval)
+	}
+}
+
+// IsScheduledEvent reports whether a Lambda event came from a
+// CloudWatch Events schedule rather than API Gateway.
+func IsScheduledEvent(event json.RawMessage) bool {
+	var scheduledEvent struct {
+		Source     string `json:"source"`
+		DetailType string `json:"detail-type"`
+	}
+	if err := json.Unmarshal(event, &scheduledEvent); err != nil {
+		return false
+	}
+	return scheduledEvent.Source == "aws.events" &&
+		scheduledEvent.DetailType == "Scheduled Event"
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testRotationConfig() RotationConfig {
+	day := 24 * time.Hour
+	return RotationConfig{
+		Cadence:       14 * day,
+		ReminderAfter: 3 * day,
+		PassAfter:     7 * day,
+		HostsPerRound: 1,
+	}
+}
+
+// sendInvitationDaysAgo backdates the host's pending invitation, and
+// its round if it started later.
+func sendInvitationDaysAgo(t *testing.T, db *sql.DB, hostId int64, days int) {
+	_, err := db.Exec(`UPDATE event_creation_invites
+                           SET sent_at = current_timestamp -
+                                         make_interval(days => $2),
+                               round_started_at = LEAST(round_started_at,
+                                   current_timestamp -
+                                   make_interval(days => $2))
+                           WHERE host_id = $1
+                           AND status = 'pending'`, hostId, days)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsScheduledEvent(t *testing.T) {
+	scheduledEvent := json.RawMessage(`{
+		"source": "aws.events",
+		"detail-type": "Scheduled Event",
+		"resources": ["arn:aws:events:us-east-1:123456789012:rule/rotation"]
+	}`)
+	if !IsScheduledEvent(scheduledEvent) {
+		t.Errorf("Expected CloudWatch schedule to be a scheduled event")
+	}
+
+	apiGatewayEvent := json.RawMessage(`{
+		"httpMethod": "GET",
+		"path": "/events/"
+	}`)
+	if IsScheduledEvent(apiGatewayEvent) {
+		t.Errorf("Expected API Gateway request not to be a scheduled event")
+	}
+}
+
+func TestRotationPassesTimedOutTurns(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	defer testPostgres.FailOn("")
+	emailer, _ := newTestEmailer(t)
+	store := PostgresStore{DB: db}
+
+	timedOutHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddHostInvitations(ctx, db, Hosts{timedOutHost}); err != nil {
+		t.Fatal(err)
+	}
+	sendInvitationDaysAgo(t, db, timedOutHost.HostId, 8)
+	nextHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastHost, err := CreateFakeHost(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testPostgres.FailOn("INSERT INTO outbound_emails")
+	if _, err := RunRotation(ctx, store, emailer, testRotationConfig()); err != errInjected {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+	testPostgres.FailOn("")
+
+	canCreate, err := CanHostCreateEvent(ctx, db, timedOutHost.HostId)
+	if err != nil || !canCreate {
+		t.Error("Expected the pass to be rolled back with the invitation")
+	}
+
+	result, err := RunRotation(ctx, store, emailer, testRotationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Passed) != 1 ||
+		result.Passed[0].HostId != timedOutHost.HostId {
+		t.Errorf("Expected the timed out host to be passed: %v", result)
+	}
+
+	if result.NewRound {
+		t.Error("Expected the replacement invitation not to start a round")
+	}
+
+	// The replacement times out too, and the turn has to go to the
+	// host who hasn't had it this round
+	pendingHosts, err := GetPendingHosts(ctx, db)
+	if err != nil || len(pendingHosts) != 1 {
+		t.Fatalf("Expected another host to be invited: %v %v",
+			pendingHosts, err)
+	}
+	sendInvitationDaysAgo(t, db, pendingHosts[0].HostId, 8)
+	result, err = RunRotation(ctx, store, emailer, testRotationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NewRound {
+		t.Error("Expected the second replacement not to start a round")
+	}
+
+	if len(result.Passed) != 1 {
+		t.Fatalf("Expected the replacement to be passed: %v", result)
+	}
+
+	pendingHosts, err = GetPendingHosts(ctx, db)
+	if err != nil || len(pendingHosts) != 1 ||
+		(pendingHosts[0].HostId != nextHost.HostId &&
+			pendingHosts[0].HostId != lastHost.HostId) ||
+		pendingHosts[0].HostId == result.Passed[0].HostId {
+		t.Errorf("Expected the host who hasn't passed to be invited: %v %v",
+			pendingHosts, err)
+	}
+
+	DeleteEverything(db)
+}
+
+func TestRotationAfterCancelledEvent(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+	emailer, _ := newTestEmailer(t)
+	store := PostgresStore{DB: db}
+
+	event, err := CreateFakeEvent(ctx, db, GetFakeEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE event_creation_invites
+                          SET sent_at = current_timestamp - interval '8 days'
+                          WHERE host_id = $1`, event.Host.HostId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = CancelEvent(ctx, db, emailer, event.EventId); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RunRotation(ctx, store, emailer, testRotationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Passed) != 0 {
+		t.Errorf("Expected the host of the cancelled event not to be passed: %v",
+			result.Passed)
+	}
+
+	canCreate, err := CanHostCreateEvent(ctx, db, event.Host.HostId)
+	if err != nil || !canCreate {
+		t.Errorf("Expected the host to still be able to create an event: %v",
+			err)
+	}
+
+	DeleteEverything(db)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserStore, HostStore, EventStore, InvitationStore and EmailQueueStore
+// are what handlers and the scheduled runs read and write through, so
+// they can be tested against MemoryStore instead of Postgres. Both implementations have to pass
+// the conformance suite in store_test.go.
+//
+// Writes that email people, like cancelling or editing an event, take
+// the Emailer that renders the emails, and queue them along with the
+// write.
+type UserStore interface {
+	CreateUser(ctx context.Context, user User) (int64, error)
+	GetUserByAuth0Id(ctx context.Context, auth0Id string) (User, error)
+	UpdateUser(ctx context.Context, auth0Id string, update UserUpdate) (User, error)
+	IsAuth0User(ctx context.Context, userId int64, auth0Id string) (bool, error)
+	GetCalendarToken(ctx context.Context, userId int64) (string, error)
+	GetUserIdByCalendarToken(ctx context.Context, calendarToken string) (int64, error)
+	IsEmailWhitelisted(ctx context.Context, email string) (bool, error)
+	GetWhitelistedEmails(ctx context.Context) ([]string, error)
+	AddWhitelistedEmails(ctx context.Context, emails []string) (int64, error)
+	RemoveWhitelistedEmail(ctx context.Context, email string) error
+}
+
+type HostStore interface {
+	CreateHost(ctx context.Context, host Host) (int64, error)
+	GetHost(ctx context.Context, hostId int64) (Host, error)
+	GetHostsByAddress(ctx context.Context, address string) (Hosts, error)
+	AddUserToHost(ctx context.Context, hostId int64, userId int64) (Host, error)
+	UpdateHost(ctx context.Context, hostId int64, update HostUpdate) (Host, error)
+	IsAuth0UserInHost(ctx context.Context, hostId int64, auth0Id string) (bool, error)
+}
+
+type EventStore interface {
+	CreateInvitedEvent(ctx context.Context, event Event) (int64, error)
+	GetEvent(ctx context.Context, eventId int64) (Event, error)
+	GetCurrentEvents(ctx context.Context) (Events, error)
+	GetPastEventsForUser(ctx context.Context, userId int64) (Events, error)
+	GetUpcomingEventsForUser(ctx context.Context, userId int64) (Events, error)
+	AddUserToEvent(ctx context.Context, eventId int64, userId int64) (Event, error)
+	ChangeParticipantDish(ctx context.Context, eventId int64, userId int64, change DishChange) (Event, error)
+	RemoveUserFromEvent(ctx context.Context, emailer *Emailer, eventId int64, userId int64) (Event, error)
+	UpdateEvent(ctx context.Context, emailer *Emailer, eventId int64, update EventUpdate) (Event, error)
+	CancelEvent(ctx context.Context, emailer *Emailer, eventId int64) (Event, error)
+	IsAuth0UserHostOfEvent(ctx context.Context, eventId int64, auth0Id string) (bool, error)
+}
+
+type InvitationStore interface {
+	AddHostInvitations(ctx context.Context, hosts Hosts) error
+	CanHostCreateEvent(ctx context.Context, hostId int64) (bool, error)
+	GetInvitations(ctx context.Context) (Invitations, error)
+	GetPendingHosts(ctx context.Context) (Hosts, error)
+	SetInvitationStatus(ctx context.Context, invitationId int64, status string) (Invitation, error)
+	ExpireEventInvitations(ctx context.Context) (Hosts, error)
+	GetLeastRecentHosts(ctx context.Context, numHosts int) (Hosts, error)
+	SendEmailsToLeastRecentHosts(ctx context.Context, emailer *Emailer, numHosts int) ([]int64, error)
+	PassHostTurn(ctx context.Context, emailer *Emailer, hostId int64) ([]int64, error)
+	GetStalePendingInvitations(ctx context.Context, age time.Duration, unremindedOnly bool) (Invitations, error)
+	RemindPendingHost(ctx context.Context, emailer *Emailer, invitation Invitation) ([]int64, error)
+	IsInvitationRoundDue(ctx context.Context, cadence time.Duration) (bool, error)
+}
+
+// EmailQueueStore is the outbound email queue that the writes above
+// add to, and that DeliverOutboundEmails sends from.
+type EmailQueueStore interface {
+	ClaimOutboundEmails(ctx context.Context, ids []int64, limit int, lease time.Duration) (OutboundEmails, error)
+	MarkOutboundEmailSent(ctx context.Context, outboundEmailId int64) error
+	MarkOutboundEmailFailed(ctx context.Context, outboundEmailId int64, sendErr string, retryAfter time.Duration) error
+}
+
+// Store is all of the stores, which both implementations are.
+type Store interface {
+	UserStore
+	HostStore
+	EventStore
+	InvitationStore
+	EmailQueueStore
+}
+
+// PostgresStore is the stores backed by the functions in db.go.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func (store PostgresStore) CreateUser(ctx context.Context, user User) (int64, error) {
+	return CreateUser(ctx, store.DB, user)
+}
+
+func (store PostgresStore) GetUserByAuth0Id(ctx context.Context, auth0Id string) (User, error) {
+	return GetUserByAuth0Id(ctx, store.DB, auth0Id)
+}
+
+func (store PostgresStore) UpdateUser(ctx context.Context, auth0Id string, update UserUpdate) (User, error) {
+	return UpdateUser(ctx, store.DB, auth0Id, update)
+}
+
+func (store PostgresStore) IsAuth0User(ctx context.Context, userId int64, auth0Id string) (bool, error) {
+	return IsAuth0User(ctx, store.DB, userId, auth0Id)
+}
+
+func (store PostgresStore) GetCalendarToken(ctx context.Context, userId int64) (string, error) {
+	return GetCalendarToken(ctx, store.DB, userId)
+}
+
+func (store PostgresStore) GetUserIdByCalendarToken(ctx context.Context, calendarToken string) (int64, error) {
+	return GetUserIdByCalendarToken(ctx, store.DB, calendarToken)
+}
+
+func (store PostgresStore) IsEmailWhitelisted(ctx context.Context, email string) (bool, error) {
+	return IsEmailWhitelisted(ctx, store.DB, email)
+}
+
+func (store PostgresStore) GetWhitelistedEmails(ctx context.Context) ([]string, error) {
+	return GetWhitelistedEmails(ctx, store.DB)
+}
+
+func (store PostgresStore) AddWhitelistedEmails(ctx context.Context, emails []string) (int64, error) {
+	return AddWhitelistedEmails(ctx, store.DB, emails)
+}
+
+func (store PostgresStore) RemoveWhitelistedEmail(ctx context.Context, email string) error {
+	return RemoveWhitelistedEmail(ctx, store.DB, email)
+}
+
+func (store PostgresStore) CreateHost(ctx context.Context, host Host) (int64, error) {
+	return CreateHost(ctx, store.DB, host)
+}
+
+func (store PostgresStore) GetHost(ctx context.Context, hostId int64) (Host, error) {
+	return GetHost(ctx, store.DB, hostId)
+}
+
+func (store PostgresStore) GetHostsByAddress(ctx context.Context, address string) (Hosts, error) {
+	return GetHostsByAddress(ctx, store.DB, address)
+}
+
+func (store PostgresStore) AddUserToHost(ctx context.Context, hostId int64, userId int64) (Host, error) {
+	return AddUserToHost(ctx, store.DB, hostId, userId)
+}
+
+func (store PostgresStore) UpdateHost(ctx context.Context, hostId int64, update HostUpdate) (Host, error) {
+	return UpdateHost(ctx, store.DB, hostId, update)
+}
+
+func (store PostgresStore) IsAuth0UserInHost(ctx context.Context, hostId int64, auth0Id string) (bool, error) {
+	return IsAuth0UserInHost(ctx, store.DB, hostId, auth0Id)
+}
+
+func (store PostgresStore) CreateInvitedEvent(ctx context.Context, event Event) (int64, error) {
+	return CreateInvitedEvent(ctx, store.DB, event)
+}
+
+func (store PostgresStore) GetEvent(ctx context.Context, eventId int64) (Event, error) {
+	return GetEvent(ctx, store.DB, eventId)
+}
+
+func (store PostgresStore) GetCurrentEvents(ctx context.Context) (Events, error) {
+	return GetCurrentEvents(ctx, store.DB)
+}
+
+func (store PostgresStore) GetPastEventsForUser(ctx context.Context, userId int64) (Events, error) {
+	return GetPastEventsForUser(ctx, store.DB, userId)
+}
+
+func (store PostgresStore) GetUpcomingEventsForUser(ctx context.Context, userId int64) (Events, error) {
+	return GetUpcomingEventsForUser(ctx, store.DB, userId)
+}
+
+func (store PostgresStore) AddUserToEvent(ctx context.Context, eventId int64, userId int64) (Event, error) {
+	return AddUserToEvent(ctx, store.DB, eventId, userId)
+}
+
+func (store PostgresStore) ChangeParticipantDish(ctx context.Context, eventId int64, userId int64, change DishChange) (Event, error) {
+	return ChangeParticipantDish(ctx, store.DB, eventId, userId, change)
+}
+
+func (store PostgresStore) RemoveUserFromEvent(ctx context.Context, emailer *Emailer, eventId int64, userId int64) (Event, error) {
+	return RemoveUserFromEvent(ctx, store.DB, emailer, eventId, userId)
+}
+
+func (store PostgresStore) UpdateEvent(ctx context.Context, emailer *Emailer, eventId int64, update EventUpdate) (Event, error) {
+	return UpdateEvent(ctx, store.DB, emailer, eventId, update)
+}
+
+func (store PostgresStore) CancelEvent(ctx context.Context, emailer *Emailer, eventId int64) (Event, error) {
+	return CancelEvent(ctx, store.DB, emailer, eventId)
+}
+
+func (store PostgresStore) IsAuth0UserHostOfEvent(ctx context.Context, eventId int64, auth0Id string) (bool, error) {
+	return IsAuth0UserHostOfEvent(ctx, store.DB, eventId, auth0Id)
+}
+
+func (store PostgresStore) AddHostInvitations(ctx context.Context, hosts Hosts) error {
+	return AddHostInvitations(ctx, store.DB, hosts)
+}
+
+func (store PostgresStore) CanHostCreateEvent(ctx context.Context, hostId int64) (bool, error) {
+	return CanHostCreateEvent(ctx, store.DB, hostId)
+}
+
+func (store PostgresStore) GetInvitations(ctx context.Context) (Invitations, error) {
+	return GetInvitations(ctx, store.DB)
+}
+
+func (store PostgresStore) GetPendingHosts(ctx context.Context) (Hosts, error) {
+	return GetPendingHosts(ctx, store.DB)
+}
+
+func (store PostgresStore) SetInvitationStatus(ctx context.Context, invitationId int64, status string) (Invitation, error) {
+	return SetInvitationStatus(ctx, store.DB, invitationId, status)
+}
+
+func (store PostgresStore) ExpireEventInvitations(ctx context.Context) (Hosts, error) {
+	return ExpireEventInvitations(ctx, store.DB)
+}
+
+func (store PostgresStore) GetLeastRecentHosts(ctx context.Context, numHosts int) (Hosts, error) {
+	return GetLeastRecentHosts(ctx, store.DB, numHosts)
+}
+
+func (store PostgresStore) SendEmailsToLeastRecentHosts(ctx context.Context, emailer *Emailer, numHosts int) ([]int64, error) {
+	return SendEmailsToLeastRecentHosts(ctx, store.DB, emailer, numHosts)
+}
+
+func (store PostgresStore) PassHostTurn(ctx context.Context, emailer *Emailer, hostId int64) ([]int64, error) {
+	return PassHostTurn(ctx, store.DB, emailer, hostId)
+}
+
+func (store PostgresStore) GetStalePendingInvitations(ctx context.Context, age time.Duration, unremindedOnly bool) (Invitations, error) {
+	return GetStalePendingInvitations(ctx, store.DB, age, unremindedOnly)
+}
+
+func (store PostgresStore) RemindPendingHost(ctx context.Context, emailer *Emailer, invitation Invitation) ([]int64, error) {
+	return RemindPendingHost(ctx, store.DB, emailer, invitation)
+}
+
+func (store PostgresStore) IsInvitationRoundDue(ctx context.Context, cadence time.Duration) (bool, error) {
+	return IsInvitationRoundDue(ctx, store.DB, cadence)
+}
+
+func (store PostgresStore) ClaimOutboundEmails(ctx context.Context, ids []int64, limit int, lease time.Duration) (OutboundEmails, error) {
+	return ClaimOutboundEmails(ctx, store.DB, ids, limit, lease)
+}
+
+func (store PostgresStore) MarkOutboundEmailSent(ctx context.Context, outboundEmailId int64) error {
+	return MarkOutboundEmailSent(ctx, store.DB, outboundEmailId)
+}
+
+func (store PostgresStore) MarkOutboundEmailFailed(ctx context.Context, outboundEmailId int64, sendErr string, retryAfter time.Duration) error {
+	return MarkOutboundEmailFailed(ctx, store.DB, outboundEmailId, sendErr, retryAfter)
+}
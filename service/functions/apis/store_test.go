@@ -0,0 +1,945 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance suite: each test runs against an empty store, and
+// has to pass for both PostgresStore and MemoryStore.
+
+type storeTest struct {
+	name string
+	test func(t *testing.T, ctx context.Context, store Store)
+}
+
+var storeTests = []storeTest{
+	{"Users", testStoreUsers},
+	{"Hosts", testStoreHosts},
+	{"HostsByAddress", testStoreHostsByAddress},
+	{"CreateInvitedEvent", testStoreCreateInvitedEvent},
+	{"AddUserToEvent", testStoreAddUserToEvent},
+	{"ChangeParticipantDish", testStoreChangeParticipantDish},
+	{"EventsForUser", testStoreEventsForUser},
+	{"RemoveUserFromEvent", testStoreRemoveUserFromEvent},
+	{"UpdateEvent", testStoreUpdateEvent},
+	{"CancelEvent", testStoreCancelEvent},
+	{"Invitations", testStoreInvitations},
+	{"Rotation", testStoreRotation},
+	{"RotationRun", testStoreRotationRun},
+	{"EmailQueue", testStoreEmailQueue},
+	{"CalendarTokens", testStoreCalendarTokens},
+	{"Whitelist", testStoreWhitelist},
+	{"CancelledContext", testStoreCancelledContext},
+}
+
+func runStoreTests(t *testing.T, emptyStore func() Store) {
+	for _, storeTest := range storeTests {
+		test := storeTest.test
+		t.Run(storeTest.name, func(t *testing.T) {
+			test(t, context.Background(), emptyStore())
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreTests(t, func() Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	db, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runStoreTests(t, func() Store {
+		DeleteEverything(db)
+		return PostgresStore{DB: db}
+	})
+
+	DeleteEverything(db)
+	db.Close()
+}
+
+func createStoreHost(t *testing.T, ctx context.Context, store Store) Host {
+	user := GetTestUser()
+	userId, err := store.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.UserId = userId
+
+	host := GetTestHost()
+	host.Users = Users{user}
+	host.HostId, err = store.CreateHost(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
+
+// createStoreEvent invites a new host and has them create the event.
+func createStoreEvent(t *testing.T, ctx context.Context, store Store, event Event) Event {
+	event.Host = createStoreHost(t, ctx, store)
+	err := store.AddHostInvitations(ctx, Hosts{event.Host})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event.EventId, err = store.CreateInvitedEvent(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func createStoreUser(t *testing.T, ctx context.Context, store Store) int64 {
+	userId, err := store.CreateUser(ctx, GetTestUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userId
+}
+
+func testStoreUsers(t *testing.T, ctx context.Context, store Store) {
+	user := GetTestUser()
+	userId, err := store.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedUser, err := store.GetUserByAuth0Id(ctx, user.Auth0Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedUser.UserId != userId || !AreUsersEqual(storedUser, user) {
+		t.Errorf("Stored user doesn't match created user: %v %v",
+			storedUser, user)
+	}
+
+	if _, err = store.CreateUser(ctx, user); err == nil {
+		t.Error("Expected an error creating a user with the same Auth0 id")
+	}
+
+	_, err = store.GetUserByAuth0Id(ctx, "nobody")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing user, got %v", err)
+	}
+
+	name := "Alice Smith"
+	restrictions := []string{" Vegan ", "vegan", "shellfish"}
+	updatedUser, err := store.UpdateUser(ctx, user.Auth0Id, UserUpdate{
+		Name:                &name,
+		DietaryRestrictions: &restrictions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Name = name
+	user.DietaryRestrictions = CanonicalizeDietaryRestrictions(restrictions)
+	if !AreUsersEqual(updatedUser, user) {
+		t.Errorf("Updated user doesn't match update: %v %v",
+			updatedUser, user)
+	}
+
+	isOwner, err := store.IsAuth0User(ctx, userId, user.Auth0Id)
+	if err != nil || !isOwner {
+		t.Errorf("Expected user to be the Auth0 user: %v", err)
+	}
+	isOwner, err = store.IsAuth0User(ctx, userId, "someone else")
+	if err != nil || isOwner {
+		t.Errorf("Expected user not to be another Auth0 user: %v", err)
+	}
+}
+
+func testStoreHosts(t *testing.T, ctx context.Context, store Store) {
+	if _, err := store.CreateHost(ctx, GetTestHost()); err != ErrHostWithoutUsers {
+		t.Errorf("Expected ErrHostWithoutUsers, got %v", err)
+	}
+
+	host := createStoreHost(t, ctx, store)
+	storedHost, err := store.GetHost(ctx, host.HostId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AreHostsEqual(storedHost, host) {
+		t.Errorf("Stored host doesn't match created host: %v %v",
+			storedHost, host)
+	}
+	if storedHost.Timezone != TimezoneForState(host.State) {
+		t.Errorf("Expected the host's timezone from its state, got %s",
+			storedHost.Timezone)
+	}
+
+	user, err := store.GetUserByAuth0Id(ctx, host.Users[0].Auth0Id)
+	if err != nil || user.HostId != host.HostId {
+		t.Errorf("Expected the user to have the host's id: %v %v", user, err)
+	}
+
+	if _, err = store.GetHost(ctx, host.HostId+1); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing host, got %v", err)
+	}
+
+	userId := createStoreUser(t, ctx, store)
+	updatedHost, err := store.AddUserToHost(ctx, host.HostId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updatedHost.Users) != 2 ||
+		!UsersContainsId(updatedHost.Users, userId) {
+		t.Errorf("Expected the user to be added to the host: %v",
+			updatedHost.Users)
+	}
+	if _, err = store.AddUserToHost(ctx, host.HostId, userId); err == nil {
+		t.Error("Expected an error adding a user to a host twice")
+	}
+
+	state := "CA"
+	timezone := ""
+	maxOccupancy := int64(12)
+	updatedHost, err = store.UpdateHost(ctx, host.HostId, HostUpdate{
+		State:        &state,
+		Timezone:     &timezone,
+		MaxOccupancy: &maxOccupancy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updatedHost.State != state || updatedHost.MaxOccupancy != 12 ||
+		updatedHost.Timezone != TimezoneForState(state) ||
+		updatedHost.Address != host.Address ||
+		len(updatedHost.Users) != 2 {
+		t.Errorf("Updated host doesn't match update: %v", updatedHost)
+	}
+
+	isOwner, err := store.IsAuth0UserInHost(ctx, host.HostId,
+		host.Users[0].Auth0Id)
+	if err != nil || !isOwner {
+		t.Errorf("Expected the host's user to be in the host: %v", err)
+	}
+	isOwner, err = store.IsAuth0UserInHost(ctx, host.HostId, "someone else")
+	if err != nil || isOwner {
+		t.Errorf("Expected another user not to be in the host: %v", err)
+	}
+}
+
+func testStoreHostsByAddress(t *testing.T, ctx context.Context, store Store) {
+	host := createStoreHost(t, ctx, store)
+
+	hosts, err := store.GetHostsByAddress(ctx, "123 Market Street")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].HostId != host.HostId ||
+		len(hosts[0].Users) != 1 {
+		t.Errorf("Expected to find the host by its address: %v", hosts)
+	}
+
+	hosts, err = store.GetHostsByAddress(ctx, "456 Market St")
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("Expected no hosts at another number: %v %v", hosts, err)
+	}
+}
+
+func testStoreCreateInvitedEvent(t *testing.T, ctx context.Context, store Store) {
+	event := GetFakeEvent()
+	event.Host = createStoreHost(t, ctx, store)
+	if _, err := store.CreateInvitedEvent(ctx, event); err != ErrNotHostsTurn {
+		t.Errorf("Expected ErrNotHostsTurn without an invitation, got %v",
+			err)
+	}
+
+	event = createStoreEvent(t, ctx, store, GetFakeEvent())
+	storedEvent, err := store.GetEvent(ctx, event.EventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AreEventsEqual(storedEvent, event) {
+		t.Errorf("Stored event doesn't match created event: %v %v",
+			storedEvent, event)
+	}
+	if storedEvent.Status != EVENT_SCHEDULED ||
+		len(storedEvent.DishSlots) != len(DEFAULT_DISH_SLOTS) ||
+		storedEvent.Timezone != TimezoneForState(event.Host.State) {
+		t.Errorf("Expected the event's defaults: %v", storedEvent)
+	}
+
+	canCreate, err := store.CanHostCreateEvent(ctx, event.Host.HostId)
+	if err != nil || canCreate {
+		t.Errorf("Expected the invitation to be used up: %v", err)
+	}
+	if _, err = store.CreateInvitedEvent(ctx, event); err != ErrNotHostsTurn {
+		t.Errorf("Expected ErrNotHostsTurn for a second event, got %v", err)
+	}
+
+	if _, err = store.GetEvent(ctx, event.EventId+1); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing event, got %v", err)
+	}
+
+	events, err := store.GetCurrentEvents(ctx)
+	if err != nil || len(events) != 1 || events[0].EventId != event.EventId {
+		t.Errorf("Expected the event to be current: %v %v", events, err)
+	}
+
+	isHost, err := store.IsAuth0UserHostOfEvent(ctx, event.EventId,
+		event.Host.Users[0].Auth0Id)
+	if err != nil || !isHost {
+		t.Errorf("Expected the host's user to host the event: %v", err)
+	}
+	isHost, err = store.IsAuth0UserHostOfEvent(ctx, event.EventId,
+		"someone else")
+	if err != nil || isHost {
+		t.Errorf("Expected another user not to host the event: %v", err)
+	}
+}
+
+func testStoreAddUserToEvent(t *testing.T, ctx context.Context, store Store) {
+	event := GetFakeEvent()
+	event.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+	}
+	event = createStoreEvent(t, ctx, store, event)
+
+	maxOccupancy := int64(2)
+	_, err := store.UpdateHost(ctx, event.Host.HostId, HostUpdate{
+		MaxOccupancy: &maxOccupancy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstUserId := createStoreUser(t, ctx, store)
+	secondUserId := createStoreUser(t, ctx, store)
+	thirdUserId := createStoreUser(t, ctx, store)
+
+	updatedEvent, err := store.AddUserToEvent(ctx, event.EventId, firstUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updatedEvent.Participants) != 1 ||
+		updatedEvent.Participants[0].AssignedDish != "main" {
+		t.Errorf("Expected the first user to bring the main: %v",
+			updatedEvent.Participants)
+	}
+
+	if _, err = store.AddUserToEvent(ctx, event.EventId, firstUserId); err == nil {
+		t.Error("Expected an error adding a participant twice")
+	}
+
+	updatedEvent, err = store.AddUserToEvent(ctx, event.EventId, secondUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, participant := range updatedEvent.Participants {
+		if participant.UserId == secondUserId &&
+			participant.AssignedDish != "dessert" {
+			t.Errorf("Expected the second user to bring the least filled dish: %v",
+				participant)
+		}
+	}
+
+	_, err = store.AddUserToEvent(ctx, event.EventId, thirdUserId)
+	if err != ErrEventFull {
+		t.Errorf("Expected ErrEventFull, got %v", err)
+	}
+	_, err = store.AddUserToEvent(ctx, event.EventId, thirdUserId)
+	if err != ErrEventFull {
+		t.Errorf("Expected ErrEventFull again, got %v", err)
+	}
+
+	storedEvent, err := store.GetEvent(ctx, event.EventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedEvent.Participants) != 2 ||
+		len(storedEvent.Waitlist) != 1 ||
+		storedEvent.Waitlist[0].UserId != thirdUserId {
+		t.Errorf("Expected the third user to be waitlisted once: %v %v",
+			storedEvent.Participants, storedEvent.Waitlist)
+	}
+
+	_, err = store.AddUserToEvent(ctx, event.EventId+1, thirdUserId)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing event, got %v", err)
+	}
+}
+
+func testStoreChangeParticipantDish(t *testing.T, ctx context.Context, store Store) {
+	event := GetFakeEvent()
+	event.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+		DishSlot{Name: "chairs", TargetCount: 1},
+	}
+	event = createStoreEvent(t, ctx, store, event)
+
+	firstUserId := createStoreUser(t, ctx, store)
+	secondUserId := createStoreUser(t, ctx, store)
+	store.AddUserToEvent(ctx, event.EventId, firstUserId)
+	store.AddUserToEvent(ctx, event.EventId, secondUserId)
+
+	_, err := store.ChangeParticipantDish(ctx, event.EventId, firstUserId,
+		DishChange{AssignedDish: "dessert"})
+	if err != ErrDishSlotFull {
+		t.Errorf("Expected ErrDishSlotFull, got %v", err)
+	}
+
+	_, err = store.ChangeParticipantDish(ctx, event.EventId, firstUserId,
+		DishChange{AssignedDish: "soup"})
+	if err != ErrUnknownDishSlot {
+		t.Errorf("Expected ErrUnknownDishSlot, got %v", err)
+	}
+
+	bringing := "folding chairs"
+	_, err = store.ChangeParticipantDish(ctx, event.EventId, firstUserId,
+		DishChange{AssignedDish: "chairs", Bringing: &bringing})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updatedEvent, err := store.ChangeParticipantDish(ctx, event.EventId,
+		firstUserId, DishChange{TradeWithUserId: secondUserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, participant := range updatedEvent.Participants {
+		if participant.UserId == firstUserId &&
+			(participant.AssignedDish != "dessert" ||
+				participant.Bringing != bringing) {
+			t.Errorf("First user's dish wasn't traded: %v", participant)
+		}
+		if participant.UserId == secondUserId &&
+			participant.AssignedDish != "chairs" {
+			t.Errorf("Second user's dish wasn't traded: %v", participant)
+		}
+	}
+	for _, dishSlot := range updatedEvent.DishSlots {
+		if dishSlot.Name != "main" && dishSlot.Filled != 1 {
+			t.Errorf("Expected %s to be filled: %v", dishSlot.Name,
+				dishSlot)
+		}
+	}
+
+	_, err = store.ChangeParticipantDish(ctx, event.EventId,
+		createStoreUser(t, ctx, store), DishChange{AssignedDish: "main"})
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a non-participant, got %v", err)
+	}
+}
+
+func testStoreEventsForUser(t *testing.T, ctx context.Context, store Store) {
+	upcomingEvent := createStoreEvent(t, ctx, store, GetFakeEvent())
+	pastEvent := GetFakeEvent()
+	pastEvent.HappeningAt = time.Now().AddDate(0, -1, 0)
+	pastEvent = createStoreEvent(t, ctx, store, pastEvent)
+
+	userId := createStoreUser(t, ctx, store)
+	for _, event := range (Events{upcomingEvent, pastEvent}) {
+		_, err := store.AddUserToEvent(ctx, event.EventId, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := store.GetUpcomingEventsForUser(ctx, userId)
+	if err != nil || len(events) != 1 ||
+		events[0].EventId != upcomingEvent.EventId {
+		t.Errorf("Expected only the upcoming event: %v %v", events, err)
+	}
+
+	events, err = store.GetPastEventsForUser(ctx, userId)
+	if err != nil || len(events) != 1 ||
+		events[0].EventId != pastEvent.EventId {
+		t.Errorf("Expected only the past event: %v %v", events, err)
+	}
+
+	// Hosts see the events they hosted too
+	events, err = store.GetPastEventsForUser(ctx,
+		pastEvent.Host.Users[0].UserId)
+	if err != nil || len(events) != 1 ||
+		events[0].EventId != pastEvent.EventId {
+		t.Errorf("Expected the host's past event: %v %v", events, err)
+	}
+
+	events, err = store.GetCurrentEvents(ctx)
+	if err != nil || len(events) != 1 ||
+		events[0].EventId != upcomingEvent.EventId {
+		t.Errorf("Expected only the upcoming event to be current: %v %v",
+			events, err)
+	}
+}
+
+func testStoreInvitations(t *testing.T, ctx context.Context, store Store) {
+	if err := store.AddHostInvitations(ctx, Hosts{}); err != nil {
+		t.Errorf("Expected inviting no hosts to do nothing, got %v", err)
+	}
+
+	host := createStoreHost(t, ctx, store)
+	otherHost := createStoreHost(t, ctx, store)
+	err := store.AddHostInvitations(ctx, Hosts{host, otherHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canCreate, err := store.CanHostCreateEvent(ctx, host.HostId)
+	if err != nil || !canCreate {
+		t.Errorf("Expected the invited host to be able to create an event: %v",
+			err)
+	}
+
+	hosts, err := store.GetPendingHosts(ctx)
+	if err != nil || len(hosts) != 2 {
+		t.Errorf("Expected both hosts to be pending: %v %v", hosts, err)
+	}
+
+	invitations, err := store.GetInvitations(ctx)
+	if err != nil || len(invitations) != 2 {
+		t.Fatalf("Expected two invitations: %v %v", invitations, err)
+	}
+
+	var invitation Invitation
+	for _, invitation = range invitations {
+		if invitation.Host.HostId == host.HostId {
+			break
+		}
+	}
+	if invitation.Status != PENDING ||
+		!AreHostsEqual(invitation.Host, host) {
+		t.Errorf("Expected a pending invitation for the host: %v",
+			invitation)
+	}
+
+	updatedInvitation, err := store.SetInvitationStatus(ctx,
+		invitation.InvitationId, EVENT_CREATED)
+	if err != nil || updatedInvitation.Status != EVENT_CREATED {
+		t.Errorf("Expected the invitation's status to change: %v %v",
+			updatedInvitation, err)
+	}
+	_, err = store.SetInvitationStatus(ctx, invitation.InvitationId,
+		"not a status")
+	if err == nil {
+		t.Error("Expected an error setting an unknown status")
+	}
+	_, err = store.SetInvitationStatus(ctx, 0, PASS)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing invitation, got %v",
+			err)
+	}
+
+	hosts, err = store.ExpireEventInvitations(ctx)
+	if err != nil || len(hosts) != 1 || hosts[0].HostId != host.HostId {
+		t.Errorf("Expected the host's invitation to expire: %v %v",
+			hosts, err)
+	}
+
+	hosts, err = store.GetPendingHosts(ctx)
+	if err != nil || len(hosts) != 1 || hosts[0].HostId != otherHost.HostId {
+		t.Errorf("Expected only the other host to be pending: %v %v",
+			hosts, err)
+	}
+}
+
+func testStoreRemoveUserFromEvent(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	event := GetFakeEvent()
+	event.DishSlots = DishSlots{
+		DishSlot{Name: "main", TargetCount: 1},
+		DishSlot{Name: "dessert", TargetCount: 1},
+	}
+	event = createStoreEvent(t, ctx, store, event)
+
+	maxOccupancy := int64(2)
+	_, err := store.UpdateHost(ctx, event.Host.HostId, HostUpdate{
+		MaxOccupancy: &maxOccupancy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userIds := []int64{}
+	for i := 0; i < 3; i++ {
+		userId := createStoreUser(t, ctx, store)
+		_, err = store.AddUserToEvent(ctx, event.EventId, userId)
+		if err != nil && err != ErrEventFull {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, userId)
+	}
+
+	updatedEvent, err := store.RemoveUserFromEvent(ctx, emailer,
+		event.EventId, userIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	participants := updatedEvent.Participants
+	if len(participants) != 2 || len(updatedEvent.Waitlist) != 0 ||
+		participants[0].UserId != userIds[1] ||
+		participants[0].AssignedDish != "main" ||
+		participants[1].UserId != userIds[2] ||
+		participants[1].AssignedDish != "dessert" {
+		t.Errorf("Expected dishes rebalanced and the waitlist promoted: %v %v",
+			participants, updatedEvent.Waitlist)
+	}
+
+	_, err = store.RemoveUserFromEvent(ctx, emailer, event.EventId,
+		userIds[0])
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows removing them again, got %v", err)
+	}
+}
+
+func testStoreUpdateEvent(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	event := createStoreEvent(t, ctx, store, GetFakeEvent())
+	for i := 0; i < 2; i++ {
+		_, err := store.AddUserToEvent(ctx, event.EventId,
+			createStoreUser(t, ctx, store))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unchangedEvent, err := store.UpdateEvent(ctx, emailer, event.EventId,
+		EventUpdate{})
+	if err != nil || unchangedEvent.Sequence != 0 {
+		t.Errorf("Expected an empty update to change nothing: %v %v",
+			unchangedEvent, err)
+	}
+
+	title := "Moved to the park"
+	dishSlots := DishSlots{
+		DishSlot{Name: "side", TargetCount: 1},
+		DishSlot{Name: "salad", TargetCount: 2},
+	}
+	updatedEvent, err := store.UpdateEvent(ctx, emailer, event.EventId,
+		EventUpdate{Title: &title, DishSlots: &dishSlots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updatedEvent.Title != title || updatedEvent.Sequence != 1 {
+		t.Errorf("Expected the title to change and the sequence to be bumped: %v",
+			updatedEvent)
+	}
+	if len(updatedEvent.DishSlots) != 2 ||
+		updatedEvent.Participants[0].AssignedDish != "side" ||
+		updatedEvent.Participants[1].AssignedDish != "salad" {
+		t.Errorf("Expected participants to move to the new dish slots: %v %v",
+			updatedEvent.DishSlots, updatedEvent.Participants)
+	}
+
+	happeningAt := event.HappeningAt.Add(time.Hour)
+	endsAt := event.HappeningAt
+	_, err = store.UpdateEvent(ctx, nil, event.EventId, EventUpdate{
+		HappeningAt: &happeningAt,
+		EndsAt:      &endsAt,
+	})
+	if err == nil {
+		t.Error("Expected an error ending the event before it starts")
+	}
+
+	_, err = store.UpdateEvent(ctx, nil, event.EventId+1,
+		EventUpdate{Title: &title})
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing event, got %v", err)
+	}
+}
+
+func testStoreCancelEvent(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	olderEvent := createStoreEvent(t, ctx, store, GetFakeEvent())
+
+	// The host's second event, from their second invitation
+	event := olderEvent
+	err := store.AddHostInvitations(ctx, Hosts{event.Host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.EventId, err = store.CreateInvitedEvent(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userId := createStoreUser(t, ctx, store)
+	if _, err := store.AddUserToEvent(ctx, event.EventId, userId); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelledEvent, err := store.CancelEvent(ctx, emailer, event.EventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelledEvent.Status != EVENT_CANCELLED ||
+		cancelledEvent.Sequence != 1 ||
+		len(cancelledEvent.Participants) != 1 {
+		t.Errorf("Expected a cancelled event that kept its participants: %v",
+			cancelledEvent)
+	}
+
+	invitations, err := store.GetInvitations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := 0
+	for _, invitation := range invitations {
+		if invitation.Status == PENDING {
+			pending++
+		}
+	}
+	if pending != 1 || len(invitations) != 2 {
+		t.Errorf("Expected only the cancelled event's invitation to be pending again: %v",
+			invitations)
+	}
+
+	_, err = store.CancelEvent(ctx, emailer, event.EventId)
+	if err != ErrEventCancelled {
+		t.Errorf("Expected ErrEventCancelled cancelling twice, got %v", err)
+	}
+	_, err = store.RemoveUserFromEvent(ctx, emailer, event.EventId, userId)
+	if err != ErrEventCancelled {
+		t.Errorf("Expected ErrEventCancelled leaving, got %v", err)
+	}
+}
+
+func testStoreRotation(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	hosts := Hosts{}
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, createStoreHost(t, ctx, store))
+	}
+
+	ids, err := store.SendEmailsToLeastRecentHosts(ctx, emailer, 1)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Expected one host to be emailed: %v %v", ids, err)
+	}
+	pendingHosts, err := store.GetPendingHosts(ctx)
+	if err != nil || len(pendingHosts) != 1 {
+		t.Fatalf("Expected one pending host: %v %v", pendingHosts, err)
+	}
+	passedHostId := pendingHosts[0].HostId
+
+	nextHosts, err := store.GetLeastRecentHosts(ctx, len(hosts))
+	if err != nil || len(nextHosts) != 2 {
+		t.Errorf("Expected the pending host to be left out: %v %v",
+			nextHosts, err)
+	}
+
+	// The turn goes to each host who hasn't had it, and then runs out
+	for i := 0; i < 2; i++ {
+		ids, err = store.PassHostTurn(ctx, emailer, pendingHosts[0].HostId)
+		if err != nil || len(ids) != 1 {
+			t.Fatalf("Expected the next host to be emailed: %v %v", ids, err)
+		}
+		pendingHosts, err = store.GetPendingHosts(ctx)
+		if err != nil || len(pendingHosts) != 1 ||
+			pendingHosts[0].HostId == passedHostId {
+			t.Fatalf("Expected a host who hasn't passed to be pending: %v %v",
+				pendingHosts, err)
+		}
+	}
+	ids, err = store.PassHostTurn(ctx, emailer, pendingHosts[0].HostId)
+	if err != ErrNoHostsToInvite || len(ids) != 0 {
+		t.Errorf("Expected no one left to invite, got %v %v", ids, err)
+	}
+
+	pendingHosts, err = store.GetPendingHosts(ctx)
+	if err != nil || len(pendingHosts) != 0 {
+		t.Errorf("Expected the last pass to be kept: %v %v",
+			pendingHosts, err)
+	}
+	_, err = store.PassHostTurn(ctx, emailer, passedHostId)
+	if err != ErrNotHostsTurn {
+		t.Errorf("Expected ErrNotHostsTurn passing twice, got %v", err)
+	}
+}
+
+// testStoreRotationRun runs RunRotation against the store, with pending
+// hosts reminded straight away and never passed over.
+func testStoreRotationRun(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	config := RotationConfig{
+		Cadence:       time.Hour,
+		ReminderAfter: 0,
+		PassAfter:     time.Hour,
+		HostsPerRound: 1,
+	}
+	host := createStoreHost(t, ctx, store)
+
+	isRoundDue, err := store.IsInvitationRoundDue(ctx, config.Cadence)
+	if err != nil || !isRoundDue {
+		t.Errorf("Expected the first round to be due: %v", err)
+	}
+
+	result, err := RunRotation(ctx, store, emailer, config)
+	if err != nil || !result.NewRound || len(result.Reminded) != 0 {
+		t.Fatalf("Expected a new round: %+v %v", result, err)
+	}
+	isRoundDue, err = store.IsInvitationRoundDue(ctx, config.Cadence)
+	if err != nil || isRoundDue {
+		t.Errorf("Expected the next round to wait for the cadence: %v", err)
+	}
+
+	stale, err := store.GetStalePendingInvitations(ctx, 0, true)
+	if err != nil || len(stale) != 1 || stale[0].Host.HostId != host.HostId ||
+		len(stale[0].Host.Users) != 1 {
+		t.Fatalf("Expected the host's invitation to be stale: %v %v",
+			stale, err)
+	}
+	stale, err = store.GetStalePendingInvitations(ctx, time.Hour, false)
+	if err != nil || len(stale) != 0 {
+		t.Errorf("Expected no invitations an hour old: %v %v", stale, err)
+	}
+
+	result, err = RunRotation(ctx, store, emailer, config)
+	if err != nil || result.NewRound || len(result.Reminded) != 1 ||
+		result.Reminded[0].HostId != host.HostId {
+		t.Errorf("Expected the host to be reminded: %+v %v", result, err)
+	}
+	result, err = RunRotation(ctx, store, emailer, config)
+	if err != nil || len(result.Reminded) != 0 {
+		t.Errorf("Expected the host to be reminded once: %+v %v",
+			result, err)
+	}
+	stale, err = store.GetStalePendingInvitations(ctx, 0, false)
+	if err != nil || len(stale) != 1 {
+		t.Errorf("Expected the reminded invitation to still be pending: %v %v",
+			stale, err)
+	}
+}
+
+func testStoreEmailQueue(t *testing.T, ctx context.Context, store Store) {
+	emailer, _ := newTestEmailer(t)
+	config := EmailQueueConfig{
+		MaxAttempts:   2,
+		RetryDelay:    time.Minute,
+		MaxRetryDelay: time.Hour,
+		BatchSize:     10,
+		Lease:         time.Minute,
+	}
+	createStoreHost(t, ctx, store)
+	createStoreHost(t, ctx, store)
+
+	failedIds, err := store.SendEmailsToLeastRecentHosts(ctx, emailer, 1)
+	if err != nil || len(failedIds) != 1 {
+		t.Fatalf("Expected one email to be queued: %v %v", failedIds, err)
+	}
+	failing := &MemoryMailer{Err: errors.New("Mailbox unavailable")}
+	results, err := DeliverOutboundEmails(ctx, store, failing, config,
+		failedIds)
+	if err != nil || len(results) != 1 ||
+		results[0].Status != EMAIL_PENDING ||
+		results[0].OutboundEmailId != failedIds[0] {
+		t.Errorf("Expected the email to be retried: %v %v", results, err)
+	}
+
+	ids, err := store.SendEmailsToLeastRecentHosts(ctx, emailer, 1)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Expected one email to be queued: %v %v", ids, err)
+	}
+	mailer := &MemoryMailer{}
+	// The failed email waits for its retry
+	results, err = DeliverOutboundEmails(ctx, store, mailer, config, nil)
+	if err != nil || len(results) != 1 || results[0].Status != EMAIL_SENT ||
+		results[0].OutboundEmailId != ids[0] {
+		t.Errorf("Expected only the new email to be sent: %v %v",
+			results, err)
+	}
+	if len(mailer.Sent()) != 1 {
+		t.Errorf("Expected one email to be sent: %v", mailer.Sent())
+	}
+
+	results, err = DeliverOutboundEmails(ctx, store, mailer, config, nil)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected nothing left to send: %v %v", results, err)
+	}
+
+	// Out of attempts
+	err = store.MarkOutboundEmailFailed(ctx, failedIds[0], "Bounced", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := store.ClaimOutboundEmails(ctx, failedIds, 10, time.Minute)
+	if err != nil || len(claimed) != 0 {
+		t.Errorf("Expected a failed email not to be claimed: %v %v",
+			claimed, err)
+	}
+}
+
+func testStoreCalendarTokens(t *testing.T, ctx context.Context, store Store) {
+	userId := createStoreUser(t, ctx, store)
+	otherUserId := createStoreUser(t, ctx, store)
+
+	calendarToken, err := store.GetCalendarToken(ctx, userId)
+	if err != nil || len(calendarToken) != 64 {
+		t.Errorf("Expected a 256 bit calendar token, got %q: %v",
+			calendarToken, err)
+	}
+	otherToken, err := store.GetCalendarToken(ctx, otherUserId)
+	if err != nil || otherToken == calendarToken {
+		t.Errorf("Expected each user to have their own token: %v", err)
+	}
+
+	tokenUserId, err := store.GetUserIdByCalendarToken(ctx, calendarToken)
+	if err != nil || tokenUserId != userId {
+		t.Errorf("Expected token to belong to user %d, got %d: %v",
+			userId, tokenUserId, err)
+	}
+	_, err = store.GetUserIdByCalendarToken(ctx, "not-a-token")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a bad token, got %v", err)
+	}
+}
+
+func testStoreWhitelist(t *testing.T, ctx context.Context, store Store) {
+	added, err := store.AddWhitelistedEmails(ctx, []string{
+		"b@example.com", "a@example.com", "A@example.com"})
+	if err != nil || added != 2 {
+		t.Errorf("Expected two emails to be added, got %d: %v", added, err)
+	}
+
+	isWhitelisted, err := store.IsEmailWhitelisted(ctx, " A@EXAMPLE.com ")
+	if err != nil || !isWhitelisted {
+		t.Errorf("Expected emails to match whatever their case: %v", err)
+	}
+
+	err = store.RemoveWhitelistedEmail(ctx, "B@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.RemoveWhitelistedEmail(ctx, "b@example.com")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows removing twice, got %v", err)
+	}
+
+	added, err = store.AddWhitelistedEmails(ctx, []string{
+		"c@example.com", "a@example.com"})
+	if err != nil || added != 1 {
+		t.Errorf("Expected only the new email to be added, got %d: %v",
+			added, err)
+	}
+
+	emails, err := store.GetWhitelistedEmails(ctx)
+	if err != nil || len(emails) != 2 ||
+		strings.ToLower(emails[0]) != "a@example.com" ||
+		emails[1] != "c@example.com" {
+		t.Errorf("Expected the whitelist in order: %v %v", emails, err)
+	}
+}
+
+func testStoreCancelledContext(t *testing.T, ctx context.Context, store Store) {
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := store.CreateUser(cancelled, GetTestUser()); err == nil {
+		t.Error("Expected an error creating a user after cancelling")
+	}
+	if _, err := store.GetCurrentEvents(cancelled); err == nil {
+		t.Error("Expected an error reading events after cancelling")
+	}
+}
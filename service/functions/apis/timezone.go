@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hosts in a state we don't know about, and rows that existed before
+// hosts had a timezone, use DEFAULT_TIMEZONE. Events default to their
+// host's timezone.
+const DEFAULT_TIMEZONE = "America/New_York"
+
+// stateTimezones maps US state abbreviations to the IANA timezone most
+// of the state observes. Hosts in split states can set their own.
+var stateTimezones = map[string]string{
+	"AL": "America/Chicago",
+	"AK": "America/Anchorage",
+	"AZ": "America/Phoenix",
+	"AR": "America/Chicago",
+	"CA": "America/Los_Angeles",
+	"CO": "America/Denver",
+	"CT": "America/New_York",
+	"DC": "America/New_York",
+	"DE": "America/New_York",
+	"FL": "America/New_York",
+	"GA": "America/New_York",
+	"HI": "Pacific/Honolulu",
+	"ID": "America/Boise",
+	"IL": "America/Chicago",
+	"IN": "America/Indiana/Indianapolis",
+	"IA": "America/Chicago",
+	"KS": "America/Chicago",
+	"KY": "America/New_York",
+	"LA": "America/Chicago",
+	"ME": "America/New_York",
+	"MD": "America/New_York",
+	"MA": "America/New_York",
+	"MI": "America/Detroit",
+	"MN": "America/Chicago",
+	"MS": "America/Chicago",
+	"MO": "America/Chicago",
+	"MT": "America/Denver",
+	"NE": "America/Chicago",
+	"NV": "America/Los_Angeles",
+	"NH": "America/New_York",
+	"NJ": "America/New_York",
+	"NM": "America/Denver",
+	"NY": "America/New_York",
+	"NC": "America/New_York",
+	"ND": "America/Chicago",
+	"OH": "America/New_York",
+	"OK": "America/Chicago",
+	"OR": "America/Los_Angeles",
+	"PA": "America/New_York",
+	"PR": "America/Puerto_Rico",
+	"RI": "America/New_York",
+	"SC": "America/New_York",
+	"SD": "America/Chicago",
+	"TN": "America/Chicago",
+	"TX": "America/Chicago",
+	"UT": "America/Denver",
+	"VT": "America/New_York",
+	"VA": "America/New_York",
+	"WA": "America/Los_Angeles",
+	"WV": "America/New_York",
+	"WI": "America/Chicago",
+	"WY": "America/Denver",
+}
+
+// TimezoneForState guesses a host's timezone from their state.
+func TimezoneForState(state string) string {
+	timezone, ok := stateTimezones[strings.ToUpper(strings.TrimSpace(state))]
+	if !ok {
+		return DEFAULT_TIMEZONE
+	}
+	return timezone
+}
+
+// LoadTimezone loads an IANA timezone. Unlike time.LoadLocation it
+// doesn't accept "" or "Local", which would depend on the server.
+func LoadTimezone(name string) (*time.Location, error) {
+	if len(name) == 0 || name == "Local" {
+		return nil, errors.New("Missing timezone")
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timezone: %s", name)
+	}
+	return location, nil
+}
+
+// EventEndsAt is when the event ends: its endsAt, or durationMinutes
+// after it starts. It's nil if the event has neither.
+func EventEndsAt(event Event) *time.Time {
+	if event.EndsAt != nil {
+		return event.EndsAt
+	}
+	if event.DurationMinutes > 0 && !event.HappeningAt.IsZero() {
+		endsAt := event.HappeningAt.Add(
+			time.Duration(event.DurationMinutes) * time.Minute)
+		return &endsAt
+	}
+	return nil
+}
+
+// LocalizeEvent puts the event's times in its own timezone, so they're
+// shown in the zone it's happening in rather than the server's, and
+// fills in its duration from its end time.
+func LocalizeEvent(event Event) Event {
+	location, err := LoadTimezone(event.Timezone)
+	if err != nil {
+		return event
+	}
+	event.HappeningAt = event.HappeningAt.In(location)
+	if event.EndsAt != nil {
+		endsAt := event.EndsAt.In(location)
+		event.EndsAt = &endsAt
+		event.DurationMinutes = int64(endsAt.Sub(event.HappeningAt) /
+			time.Minute)
+	}
+	return event
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimezoneForState(t *testing.T) {
+	for state, expected := range map[string]string{
+		"PA":  "America/New_York",
+		" ca": "America/Los_Angeles",
+		"ZZ":  DEFAULT_TIMEZONE,
+	} {
+		if timezone := TimezoneForState(state); timezone != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, state, timezone)
+		}
+		if _, err := LoadTimezone(TimezoneForState(state)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, timezone := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		if _, err := LoadTimezone(timezone); err == nil {
+			t.Errorf("Expected %q to be rejected", timezone)
+		}
+	}
+}
+
+func TestLocalizeEvent(t *testing.T) {
+	endsAt := time.Date(2018, 7, 2, 1, 30, 0, 0, time.UTC)
+	event := LocalizeEvent(Event{
+		HappeningAt: time.Date(2018, 7, 1, 23, 0, 0, 0, time.UTC),
+		EndsAt:      &endsAt,
+		Timezone:    "America/Los_Angeles",
+	})
+
+	if formatted := event.HappeningAt.Format(time.RFC3339); formatted !=
+		"2018-07-01T16:00:00-07:00" {
+		t.Errorf("Expected the start in Pacific time, got %s", formatted)
+	}
+	if formatted := event.EndsAt.Format(time.RFC3339); formatted !=
+		"2018-07-01T18:30:00-07:00" {
+		t.Errorf("Expected the end in Pacific time, got %s", formatted)
+	}
+	if event.DurationMinutes != 150 {
+		t.Errorf("Expected a 150 minute event, got %d",
+			event.DurationMinutes)
+	}
+}
+
+func TestEventEndsAt(t *testing.T) {
+	happeningAt := time.Date(2018, 3, 2, 18, 30, 0, 0, time.UTC)
+	if endsAt := EventEndsAt(Event{HappeningAt: happeningAt}); endsAt != nil {
+		t.Errorf("Expected no end, got %s", endsAt)
+	}
+
+	endsAt := EventEndsAt(Event{HappeningAt: happeningAt, DurationMinutes: 90})
+	if endsAt == nil || !endsAt.Equal(happeningAt.Add(90*time.Minute)) {
+		t.Errorf("Expected the end 90 minutes after the start, got %v",
+			endsAt)
+	}
+}
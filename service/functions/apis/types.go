@@ -5,16 +5,41 @@ import (
 )
 
 type Event struct {
-	EventId      int64     `json:"eventId"`
-	Title        string    `json:"title"`
-	Description  string    `json:"description"`
-	HappeningAt  time.Time `json:"happeningAt"` // expects RFC3339
-	Host         Host      `json:"host"`
-	Participants Users     `json:"participants"`
+	EventId         int64      `json:"eventId"`
+	Title           string     `json:"title"`
+	Description     string     `json:"description"`               // Markdown
+	DescriptionHTML string     `json:"descriptionHtml"`           // sanitized, read only
+	HappeningAt     time.Time  `json:"happeningAt"`               // expects RFC3339
+	EndsAt          *time.Time `json:"endsAt,omitempty"`          // optional
+	DurationMinutes int64      `json:"durationMinutes,omitempty"` // or instead of endsAt
+	Timezone        string     `json:"timezone"`                  // IANA, defaults to the host's
+	Host            Host       `json:"host"`
+	Participants    Users      `json:"participants"`
+	Waitlist        Users      `json:"waitlist"`
+	DishSlots       DishSlots  `json:"dishSlots"`
+	Sequence        int64      `json:"sequence"` // bumped on every update
+	Status          string     `json:"status"`
 }
 
 type Events []Event
 
+type DishSlot struct {
+	Name        string `json:"name"`
+	TargetCount int64  `json:"targetCount"`
+	Filled      int64  `json:"filled"`
+}
+
+type DishSlots []DishSlot
+
+// DishChange is a participant's request to claim an open dish slot,
+// trade slots with another participant, and/or set what they're
+// bringing. A nil Bringing leaves it unchanged.
+type DishChange struct {
+	AssignedDish    string  `json:"assignedDish,omitempty"`
+	TradeWithUserId int64   `json:"tradeWithUserId,omitempty"`
+	Bringing        *string `json:"bringing,omitempty"`
+}
+
 type Host struct {
 	HostId       int64  `json:"hostId"`
 	Address      string `json:"address"`
@@ -22,6 +47,7 @@ type Host struct {
 	State        string `json:"state"`
 	Zipcode      string `json:"zipcode"`
 	MaxOccupancy int64  `json:"maxOccupancy"`
+	Timezone     string `json:"timezone"` // IANA, defaults from the state
 	Users        Users  `json:"users"`
 }
 
@@ -40,6 +66,40 @@ type User struct {
 
 type Users []User
 
+type Invitation struct {
+	InvitationId int64     `json:"invitationId"`
+	Host         Host      `json:"host"`
+	Status       string    `json:"status"`
+	SentAt       time.Time `json:"sentAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type Invitations []Invitation
+
+type OutboundEmail struct {
+	OutboundEmailId int64     `json:"outboundEmailId"`
+	UserId          int64     `json:"userId"`
+	Template        string    `json:"template"`
+	Sender          string    `json:"sender"`
+	Recipient       string    `json:"recipient"`
+	Message         []byte    `json:"-"`
+	Status          string    `json:"status"`
+	Attempts        int64     `json:"attempts"`
+	LastError       string    `json:"lastError"`
+	NextAttemptAt   time.Time `json:"nextAttemptAt"`
+}
+
+type OutboundEmails []OutboundEmail
+
+type Whitelist struct {
+	Emails []string `json:"emails"`
+}
+
+type WhitelistImportResult struct {
+	Added   int64    `json:"added"`
+	Invalid []string `json:"invalid"`
+}
+
 // AWS Lambda / apex
 
 type (
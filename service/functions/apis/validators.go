@@ -3,6 +3,8 @@ package main
 import (
     "fmt"
     "errors"
+    "strings"
+    "unicode/utf8"
 )
 
 func ValidateUser(user User) error {
@@ -37,6 +39,71 @@ func ValidateEvent(event Event) error {
         err := fmt.Sprintf("Missing required fields: %s", missingFields)
         return errors.New(err)
     }
+    err := ValidateDescription(event.Description)
+    if err != nil {
+        return err
+    }
+    err = ValidateEventTimes(event)
+    if err != nil {
+        return err
+    }
+    return ValidateDishSlots(event.DishSlots)
+}
+
+// ValidateEventTimes checks the event's end, duration and timezone.
+// Any of them can be left out, e.g. when editing an event.
+func ValidateEventTimes(event Event) error {
+    if event.EndsAt != nil && event.DurationMinutes != 0 {
+        return errors.New("Set either endsAt or durationMinutes, not both")
+    }
+    if event.DurationMinutes < 0 {
+        return errors.New("durationMinutes can't be negative")
+    }
+    if event.EndsAt != nil && !event.HappeningAt.IsZero() &&
+        !event.EndsAt.After(event.HappeningAt) {
+        return errors.New("Event has to end after it starts")
+    }
+    return ValidateTimezone(event.Timezone)
+}
+
+// ValidateTimezone checks that timezone, if set, is an IANA timezone.
+func ValidateTimezone(timezone string) error {
+    if len(timezone) == 0 {
+        return nil
+    }
+    _, err := LoadTimezone(timezone)
+    return err
+}
+
+// The events.description column is a varchar(600)
+const MAX_DESCRIPTION_LENGTH = 600
+
+func ValidateDescription(description string) error {
+    if utf8.RuneCountInString(description) > MAX_DESCRIPTION_LENGTH {
+        err := fmt.Sprintf("Description can't be longer than %d characters",
+            MAX_DESCRIPTION_LENGTH)
+        return errors.New(err)
+    }
+    return nil
+}
+
+func ValidateDishSlots(dishSlots DishSlots) error {
+    seenNames := make(map[string]bool)
+    for _, dishSlot := range dishSlots {
+        if len(dishSlot.Name) == 0 {
+            return errors.New("Dish slots must have a name")
+        }
+        if seenNames[dishSlot.Name] {
+            err := fmt.Sprintf("Duplicate dish slot: %s", dishSlot.Name)
+            return errors.New(err)
+        }
+        if dishSlot.TargetCount < 1 {
+            err := fmt.Sprintf("Dish slot %s needs a targetCount of at least 1",
+                dishSlot.Name)
+            return errors.New(err)
+        }
+        seenNames[dishSlot.Name] = true
+    }
     return nil
 }
 
@@ -73,5 +140,144 @@ func ValidateHost(host Host) error {
         err := fmt.Sprintf("Missing required fields: %s", missingFields)
         return errors.New(err)
     }
+    return ValidateTimezone(host.Timezone)
+}
+
+// Required fields can be left out of an update, but not cleared
+func clearedFieldsError(clearedFields []string) error {
+    if len(clearedFields) > 0 {
+        err := fmt.Sprintf("Required fields can't be cleared: %s",
+            clearedFields)
+        return errors.New(err)
+    }
+    return nil
+}
+
+func ValidateUserUpdate(update UserUpdate) error {
+    var clearedFields []string
+    if update.Name != nil && len(*update.Name) == 0 {
+        clearedFields = append(clearedFields, "name")
+    }
+    if update.Email != nil && len(*update.Email) == 0 {
+        clearedFields = append(clearedFields, "email")
+    }
+    return clearedFieldsError(clearedFields)
+}
+
+func ValidateHostUpdate(update HostUpdate) error {
+    var clearedFields []string
+    if update.Address != nil && len(*update.Address) == 0 {
+        clearedFields = append(clearedFields, "address")
+    }
+    if update.City != nil && len(*update.City) == 0 {
+        clearedFields = append(clearedFields, "city")
+    }
+    if update.State != nil && len(*update.State) == 0 {
+        clearedFields = append(clearedFields, "state")
+    }
+    if update.Zipcode != nil && len(*update.Zipcode) == 0 {
+        clearedFields = append(clearedFields, "zipcode")
+    }
+    if err := clearedFieldsError(clearedFields); err != nil {
+        return err
+    }
+
+    if update.MaxOccupancy != nil && *update.MaxOccupancy < 1 {
+        return errors.New("maxOccupancy has to be at least 1")
+    }
+    if update.Timezone != nil {
+        return ValidateTimezone(*update.Timezone)
+    }
+    return nil
+}
+
+func ValidateEventUpdate(update EventUpdate) error {
+    var clearedFields []string
+    if update.Title != nil && len(*update.Title) == 0 {
+        clearedFields = append(clearedFields, "title")
+    }
+    if update.HappeningAt != nil && update.HappeningAt.IsZero() {
+        clearedFields = append(clearedFields, "happeningAt")
+    }
+    if update.HostId != nil && *update.HostId == 0 {
+        clearedFields = append(clearedFields, "host")
+    }
+    if err := clearedFieldsError(clearedFields); err != nil {
+        return err
+    }
+
+    if update.Description != nil {
+        if err := ValidateDescription(*update.Description); err != nil {
+            return err
+        }
+    }
+
+    // Checked like a whole event, where zero values mean left out
+    event := Event{}
+    if update.HappeningAt != nil {
+        event.HappeningAt = *update.HappeningAt
+    }
+    if update.EndsAt != nil && !update.EndsAt.IsZero() {
+        event.EndsAt = update.EndsAt
+    }
+    if update.DurationMinutes != nil {
+        if update.EndsAt != nil {
+            return errors.New("Set either endsAt or durationMinutes, not both")
+        }
+        event.DurationMinutes = *update.DurationMinutes
+    }
+    if update.Timezone != nil {
+        event.Timezone = *update.Timezone
+    }
+    if err := ValidateEventTimes(event); err != nil {
+        return err
+    }
+
+    if update.DishSlots != nil {
+        return ValidateDishSlots(*update.DishSlots)
+    }
+    return nil
+}
+
+// ParseWhitelistEmails splits a bulk import (one email per line, or
+// comma separated, e.g. pasted from a spreadsheet) into valid emails
+// and entries that don't look like an email.
+func ParseWhitelistEmails(text string) ([]string, []string) {
+    valid := []string{}
+    invalid := []string{}
+    entries := strings.FieldsFunc(text, func(r rune) bool {
+        return r == '\n' || r == '\r' || r == ',' || r == ';'
+    })
+    for _, entry := range entries {
+        email := strings.TrimSpace(entry)
+        if len(email) == 0 {
+            continue
+        }
+        if ValidateEmail(email) != nil {
+            invalid = append(invalid, email)
+        } else {
+            valid = append(valid, email)
+        }
+    }
+    return valid, invalid
+}
+
+func ValidateEmail(email string) error {
+    at := strings.Index(email, "@")
+    if at < 1 || at == len(email)-1 || strings.ContainsAny(email, " \t") {
+        err := fmt.Sprintf("Invalid email: %s", email)
+        return errors.New(err)
+    }
     return nil
 }
+
+func ValidateInvitationStatus(status string) error {
+    for _, validStatus := range INVITATION_STATUSES {
+        if status == validStatus {
+            return nil
+        }
+    }
+    err := fmt.Sprintf("Invalid status %s, must be one of: %s", status,
+        INVITATION_STATUSES)
+    return errors.New(err)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWhitelistEmails(t *testing.T) {
+	emails, invalid := ParseWhitelistEmails(
+		"alice@example.com\r\nbob@example.com, carol@example.com\n\n" +
+			"not an email;@example.com;dave@")
+
+	if !reflect.DeepEqual(emails, []string{"alice@example.com",
+		"bob@example.com", "carol@example.com"}) {
+		t.Errorf("Wrong valid emails: %v", emails)
+	}
+
+	if !reflect.DeepEqual(invalid, []string{"not an email",
+		"@example.com", "dave@"}) {
+		t.Errorf("Wrong invalid emails: %v", invalid)
+	}
+}
+
+func TestValidateDescription(t *testing.T) {
+	// 600 multi-byte characters fit in the column
+	if err := ValidateDescription(strings.Repeat("é", 600)); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateDescription(strings.Repeat("a", 601)); err == nil {
+		t.Error("Expected a 601 character description to be invalid")
+	}
+}
+
+func TestValidateEventTimes(t *testing.T) {
+	happeningAt := time.Date(2018, 3, 2, 18, 30, 0, 0, time.UTC)
+	before := happeningAt.Add(-time.Hour)
+	after := happeningAt.Add(time.Hour)
+
+	for _, event := range []Event{
+		{HappeningAt: happeningAt},
+		{HappeningAt: happeningAt, EndsAt: &after, Timezone: "Europe/Berlin"},
+		{DurationMinutes: 120},
+		{EndsAt: &before},
+	} {
+		if err := ValidateEventTimes(event); err != nil {
+			t.Errorf("Expected %v to be valid: %s", event, err)
+		}
+	}
+
+	for _, event := range []Event{
+		{HappeningAt: happeningAt, EndsAt: &before},
+		{HappeningAt: happeningAt, EndsAt: &after, DurationMinutes: 60},
+		{DurationMinutes: -5},
+		{Timezone: "EST5EDT Somewhere"},
+	} {
+		if err := ValidateEventTimes(event); err == nil {
+			t.Errorf("Expected %v to be invalid", event)
+		}
+	}
+}
+
+func TestValidateUpdates(t *testing.T) {
+	empty := ""
+	zero := int64(0)
+	slots := DishSlots{{Name: "main", TargetCount: 0}}
+
+	for _, err := range []error{
+		ValidateUserUpdate(UserUpdate{Email: &empty}),
+		ValidateHostUpdate(HostUpdate{Address: &empty}),
+		ValidateHostUpdate(HostUpdate{MaxOccupancy: &zero}),
+		ValidateEventUpdate(EventUpdate{Title: &empty}),
+		ValidateEventUpdate(EventUpdate{HostId: &zero}),
+		ValidateEventUpdate(EventUpdate{DishSlots: &slots}),
+	} {
+		if err == nil {
+			t.Error("Expected an invalid update")
+		}
+	}
+
+	cleared := []string{}
+	emptySlots := DishSlots{}
+	for _, err := range []error{
+		ValidateUserUpdate(UserUpdate{}),
+		ValidateUserUpdate(UserUpdate{DietaryRestrictions: &cleared}),
+		ValidateHostUpdate(HostUpdate{Timezone: &empty}),
+		ValidateEventUpdate(EventUpdate{Description: &empty}),
+		ValidateEventUpdate(EventUpdate{DurationMinutes: &zero}),
+		ValidateEventUpdate(EventUpdate{DishSlots: &emptySlots}),
+	} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE event_waitlist (
+       event_waitlist_id        serial PRIMARY KEY,
+       event_id                 serial REFERENCES events ON DELETE CASCADE,
+       user_id                  serial REFERENCES users ON DELETE CASCADE,
+       created_at               timestamp DEFAULT current_timestamp,
+       UNIQUE(event_id, user_id)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE event_waitlist;
@@ -0,0 +1,58 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE event_dish_slots (
+       event_dish_slot_id       serial PRIMARY KEY,
+       event_id                 serial REFERENCES events ON DELETE CASCADE,
+       name                     varchar(240) NOT NULL,
+       target_count             integer NOT NULL DEFAULT 1 CHECK (target_count > 0),
+       position                 integer NOT NULL,
+       UNIQUE(event_id, name)
+);
+
+INSERT INTO event_dish_slots (event_id, name, target_count, position)
+SELECT events.event_id, defaults.name, 1, defaults.position
+FROM events,
+     (VALUES ('main', 0),
+             ('side', 1),
+             ('appetizer', 2),
+             ('drinks', 3)) AS defaults (name, position);
+
+ALTER TABLE event_users
+      ALTER COLUMN assigned_dish TYPE varchar(240)
+      USING assigned_dish::text;
+
+DROP FUNCTION next_dish(d dish);
+DROP TYPE dish;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+CREATE TYPE dish AS ENUM (
+       'appetizer',
+       'side',
+       'drinks',
+       'main'
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION next_dish(d dish)
+RETURNS dish AS $$
+BEGIN
+  RETURN (CASE WHEN d='main'::dish THEN 'side'::dish
+               WHEN d='side'::dish THEN 'appetizer'::dish
+               WHEN d='appetizer'::dish THEN 'drinks'::dish
+               ELSE 'main'::dish
+          END);
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+UPDATE event_users SET assigned_dish = NULL
+WHERE assigned_dish NOT IN ('appetizer', 'side', 'drinks', 'main');
+
+ALTER TABLE event_users
+      ALTER COLUMN assigned_dish TYPE dish
+      USING assigned_dish::dish;
+
+DROP TABLE event_dish_slots;
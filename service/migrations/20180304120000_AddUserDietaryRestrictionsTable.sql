@@ -0,0 +1,60 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE user_dietary_restrictions (
+       user_id                  serial REFERENCES users ON DELETE CASCADE,
+       restriction              varchar(240) NOT NULL,
+       position                 integer NOT NULL,
+       created_at               timestamp DEFAULT current_timestamp,
+       UNIQUE(user_id, restriction)
+);
+
+CREATE INDEX user_dietary_restrictions_restriction
+       ON user_dietary_restrictions (restriction);
+
+-- Split the old '+'-joined strings, matching canonical restrictions
+-- regardless of case, spacing, or hyphens (see dietary.go).
+WITH vocabulary (name) AS (
+     VALUES ('vegan'),
+            ('vegetarian'),
+            ('pescatarian'),
+            ('gluten-free'),
+            ('dairy-free'),
+            ('nut allergy'),
+            ('shellfish allergy'),
+            ('kosher'),
+            ('halal')
+), split AS (
+     SELECT users.user_id,
+            trim(r.restriction) AS restriction,
+            r.position
+     FROM users,
+          unnest(string_to_array(users.dietary_restrictions, '+'))
+          WITH ORDINALITY AS r(restriction, position)
+     WHERE trim(r.restriction) <> ''
+)
+INSERT INTO user_dietary_restrictions (user_id, restriction, position)
+SELECT split.user_id,
+       COALESCE(vocabulary.name, split.restriction),
+       split.position
+FROM split
+LEFT JOIN vocabulary
+ON regexp_replace(lower(split.restriction), '[\s-]+', ' ', 'g') =
+   replace(vocabulary.name, '-', ' ')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users DROP COLUMN dietary_restrictions;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE users ADD COLUMN dietary_restrictions varchar(600);
+
+UPDATE users SET dietary_restrictions = joined.restrictions
+FROM (SELECT user_id,
+             string_agg(restriction, '+' ORDER BY position) AS restrictions
+      FROM user_dietary_restrictions
+      GROUP BY user_id) AS joined
+WHERE users.user_id = joined.user_id;
+
+DROP TABLE user_dietary_restrictions;
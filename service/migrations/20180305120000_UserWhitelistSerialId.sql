@@ -0,0 +1,29 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+DELETE FROM user_whitelist a
+USING user_whitelist b
+WHERE lower(a.email) = lower(b.email)
+AND a.user_whitelist_id > b.user_whitelist_id;
+
+CREATE SEQUENCE user_whitelist_user_whitelist_id_seq
+       OWNED BY user_whitelist.user_whitelist_id;
+SELECT setval('user_whitelist_user_whitelist_id_seq',
+              COALESCE(MAX(user_whitelist_id), 0) + 1, false)
+FROM user_whitelist;
+ALTER TABLE user_whitelist
+      ALTER COLUMN user_whitelist_id
+      SET DEFAULT nextval('user_whitelist_user_whitelist_id_seq');
+
+ALTER TABLE user_whitelist
+      ADD COLUMN created_at timestamp DEFAULT current_timestamp;
+
+CREATE UNIQUE INDEX user_whitelist_email ON user_whitelist (lower(email));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP INDEX user_whitelist_email;
+ALTER TABLE user_whitelist DROP COLUMN created_at;
+ALTER TABLE user_whitelist ALTER COLUMN user_whitelist_id DROP DEFAULT;
+DROP SEQUENCE user_whitelist_user_whitelist_id_seq;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE event_creation_invites
+      ADD COLUMN event_creation_invite_id serial PRIMARY KEY;
+ALTER TABLE event_creation_invites
+      ADD COLUMN updated_at timestamp DEFAULT current_timestamp;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE event_creation_invites DROP COLUMN updated_at;
+ALTER TABLE event_creation_invites DROP COLUMN event_creation_invite_id;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE outbound_emails (
+       outbound_email_id        serial PRIMARY KEY,
+       user_id                  integer REFERENCES users ON DELETE SET NULL,
+       template                 varchar NOT NULL,
+       sender                   varchar NOT NULL,
+       recipient                varchar NOT NULL,
+       message                  bytea NOT NULL,
+       status                   varchar NOT NULL DEFAULT 'pending',
+       attempts                 integer NOT NULL DEFAULT 0,
+       last_error               text,
+       next_attempt_at          timestamp NOT NULL DEFAULT current_timestamp,
+       created_at               timestamp DEFAULT current_timestamp,
+       sent_at                  timestamp
+);
+
+CREATE INDEX outbound_emails_due
+       ON outbound_emails (next_attempt_at)
+       WHERE status = 'pending';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE outbound_emails;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Bumped on every update so calendar entries update in place
+ALTER TABLE events ADD COLUMN sequence integer NOT NULL DEFAULT 0;
+
+-- Secret for each user's calendar feed URL, since calendar apps can't
+-- log in
+ALTER TABLE users ADD COLUMN calendar_token varchar NOT NULL
+      DEFAULT md5(random()::text || clock_timestamp()::text);
+CREATE UNIQUE INDEX users_calendar_token ON users (calendar_token);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP INDEX users_calendar_token;
+ALTER TABLE users DROP COLUMN calendar_token;
+ALTER TABLE events DROP COLUMN sequence;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE events ADD COLUMN status varchar NOT NULL DEFAULT 'scheduled';
+ALTER TABLE events ADD COLUMN cancelled_at timestamp;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE events DROP COLUMN cancelled_at;
+ALTER TABLE events DROP COLUMN status;
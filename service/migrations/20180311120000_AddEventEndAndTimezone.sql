@@ -0,0 +1,27 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE hosts ADD COLUMN timezone varchar NOT NULL DEFAULT 'America/New_York';
+
+-- Times were written and read by servers running in UTC
+ALTER TABLE events ALTER COLUMN happening_at TYPE timestamptz
+      USING happening_at AT TIME ZONE 'UTC';
+ALTER TABLE events ADD COLUMN ends_at timestamptz;
+ALTER TABLE events ADD CONSTRAINT events_ends_after_start
+      CHECK (ends_at IS NULL OR ends_at > happening_at);
+
+ALTER TABLE events ADD COLUMN timezone varchar;
+UPDATE events SET timezone = hosts.timezone
+       FROM hosts WHERE hosts.host_id = events.host_id;
+ALTER TABLE events ALTER COLUMN timezone SET NOT NULL;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE events DROP COLUMN timezone;
+ALTER TABLE events DROP CONSTRAINT events_ends_after_start;
+ALTER TABLE events DROP COLUMN ends_at;
+ALTER TABLE events ALTER COLUMN happening_at TYPE timestamp
+      USING happening_at AT TIME ZONE 'UTC';
+
+ALTER TABLE hosts DROP COLUMN timezone;
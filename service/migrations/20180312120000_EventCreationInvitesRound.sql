@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- When the round the invite belongs to started. Invites sent to
+-- replace a host who passed belong to the round they were passed in,
+-- so they don't move the next round.
+ALTER TABLE event_creation_invites ADD COLUMN round_started_at timestamp
+      NOT NULL DEFAULT current_timestamp;
+UPDATE event_creation_invites SET round_started_at = sent_at
+WHERE sent_at IS NOT NULL;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE event_creation_invites DROP COLUMN round_started_at;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+-- Calendar tokens are the only thing protecting a user's feed, so they
+-- come from a CSPRNG instead of random()
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+ALTER TABLE users ALTER COLUMN calendar_token
+      SET DEFAULT encode(gen_random_bytes(32), 'hex');
+UPDATE users SET calendar_token = encode(gen_random_bytes(32), 'hex');
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE users ALTER COLUMN calendar_token
+      SET DEFAULT md5(random()::text || clock_timestamp()::text);